@@ -0,0 +1,393 @@
+package engine
+
+import (
+	"errors"
+	"sort"
+)
+
+// An assoc is an AVL tree of key-value pairs ordered by the standard order of the keys.
+// Like SWI-Prolog, t is the empty assoc and t(K, V, Balance, L, R) is a node where Balance is <, =, or > as the depth
+// of L is less than, equal to, or greater than the depth of R. Since it's an ordinary term, it can be copied and asserted.
+
+// errNotAssoc is reported by the internal functions when they come across a term which is not an assoc.
+var errNotAssoc = errors.New("not an assoc")
+
+type assocNode struct {
+	key, value  Term
+	balance     int // the depth of right minus the depth of left.
+	left, right Term
+}
+
+var assocBalances = map[Atom]int{
+	atomLessThan:    1,
+	atomEqual:       0,
+	atomGreaterThan: -1,
+}
+
+// parseAssoc returns the root node of t. It reports false if t is the empty assoc.
+func parseAssoc(t Term, env *Env) (assocNode, bool, error) {
+	switch t := env.Resolve(t).(type) {
+	case Atom:
+		if t == atomT {
+			return assocNode{}, false, nil
+		}
+	case Compound:
+		if t.Functor() != atomT || t.Arity() != 5 {
+			break
+		}
+		b, ok := env.Resolve(t.Arg(2)).(Atom)
+		if !ok {
+			break
+		}
+		balance, ok := assocBalances[b]
+		if !ok {
+			break
+		}
+		return assocNode{key: t.Arg(0), value: t.Arg(1), balance: balance, left: t.Arg(3), right: t.Arg(4)}, true, nil
+	}
+	return assocNode{}, false, errNotAssoc
+}
+
+func (n assocNode) term() Term {
+	b := atomEqual
+	switch n.balance {
+	case 1:
+		b = atomLessThan
+	case -1:
+		b = atomGreaterThan
+	}
+	return atomT.Apply(n.key, n.value, b, n.left, n.right)
+}
+
+// rebalance rotates n of which the balance is either -2 or 2. It reports whether the depth decreased by the rotation.
+func (n assocNode) rebalance(env *Env) (Term, bool, error) {
+	if n.balance < 0 {
+		l, _, err := parseAssoc(n.left, env)
+		if err != nil {
+			return nil, false, err
+		}
+		if l.balance <= 0 {
+			n.left = l.right
+			shrunk := l.balance < 0
+			if shrunk {
+				n.balance, l.balance = 0, 0
+			} else {
+				n.balance, l.balance = -1, 1
+			}
+			l.right = n.term()
+			return l.term(), shrunk, nil
+		}
+		lr, _, err := parseAssoc(l.right, env)
+		if err != nil {
+			return nil, false, err
+		}
+		l.right, n.left = lr.left, lr.right
+		l.balance, n.balance = 0, 0
+		switch lr.balance {
+		case -1:
+			n.balance = 1
+		case 1:
+			l.balance = -1
+		}
+		lr.balance, lr.left, lr.right = 0, l.term(), n.term()
+		return lr.term(), true, nil
+	}
+
+	r, _, err := parseAssoc(n.right, env)
+	if err != nil {
+		return nil, false, err
+	}
+	if r.balance >= 0 {
+		n.right = r.left
+		shrunk := r.balance > 0
+		if shrunk {
+			n.balance, r.balance = 0, 0
+		} else {
+			n.balance, r.balance = 1, -1
+		}
+		r.left = n.term()
+		return r.term(), shrunk, nil
+	}
+	rl, _, err := parseAssoc(r.left, env)
+	if err != nil {
+		return nil, false, err
+	}
+	n.right, r.left = rl.left, rl.right
+	n.balance, r.balance = 0, 0
+	switch rl.balance {
+	case -1:
+		r.balance = 1
+	case 1:
+		n.balance = -1
+	}
+	rl.balance, rl.left, rl.right = 0, n.term(), r.term()
+	return rl.term(), true, nil
+}
+
+// grown returns n after either of the subtrees grew by delta, -1 for left and 1 for right.
+// It reports whether n grew as well.
+func (n assocNode) grown(delta int, env *Env) (Term, bool, error) {
+	n.balance += delta
+	switch n.balance {
+	case 0:
+		return n.term(), false, nil
+	case -1, 1:
+		return n.term(), true, nil
+	default:
+		t, _, err := n.rebalance(env)
+		return t, false, err
+	}
+}
+
+// shrunk returns n after either of the subtrees shrank by delta, 1 for left and -1 for right.
+// It reports whether n shrank as well.
+func (n assocNode) shrunk(delta int, env *Env) (Term, bool, error) {
+	n.balance += delta
+	switch n.balance {
+	case 0:
+		return n.term(), true, nil
+	case -1, 1:
+		return n.term(), false, nil
+	default:
+		return n.rebalance(env)
+	}
+}
+
+// putAssoc returns t with key associated with value. It reports whether the depth increased.
+func putAssoc(t, key, value Term, env *Env) (Term, bool, error) {
+	n, ok, err := parseAssoc(t, env)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return atomT.Apply(key, value, atomEqual, atomT, atomT), true, nil
+	}
+	switch o := env.Resolve(key).Compare(n.key, env); {
+	case o < 0:
+		var grew bool
+		n.left, grew, err = putAssoc(n.left, key, value, env)
+		if err != nil || !grew {
+			return n.term(), false, err
+		}
+		return n.grown(-1, env)
+	case o > 0:
+		var grew bool
+		n.right, grew, err = putAssoc(n.right, key, value, env)
+		if err != nil || !grew {
+			return n.term(), false, err
+		}
+		return n.grown(1, env)
+	default:
+		n.value = value
+		return n.term(), false, nil
+	}
+}
+
+// delAssoc returns t without key and the value associated with key. It reports whether the depth decreased and
+// whether key was found.
+func delAssoc(t, key Term, env *Env) (_ Term, value Term, shrunk, found bool, err error) {
+	n, ok, err := parseAssoc(t, env)
+	if err != nil || !ok {
+		return t, nil, false, false, err
+	}
+	switch o := env.Resolve(key).Compare(n.key, env); {
+	case o < 0:
+		n.left, value, shrunk, found, err = delAssoc(n.left, key, env)
+		if err != nil || !shrunk {
+			return n.term(), value, false, found, err
+		}
+		t, shrunk, err = n.shrunk(1, env)
+		return t, value, shrunk, found, err
+	case o > 0:
+		n.right, value, shrunk, found, err = delAssoc(n.right, key, env)
+		if err != nil || !shrunk {
+			return n.term(), value, false, found, err
+		}
+		t, shrunk, err = n.shrunk(-1, env)
+		return t, value, shrunk, found, err
+	default:
+		value = n.value
+		if _, ok, err := parseAssoc(n.left, env); err != nil || !ok {
+			return n.right, value, true, true, err
+		}
+		if _, ok, err := parseAssoc(n.right, env); err != nil || !ok {
+			return n.left, value, true, true, err
+		}
+		n.right, n.key, n.value, shrunk, err = delMinAssoc(n.right, env)
+		if err != nil || !shrunk {
+			return n.term(), value, false, true, err
+		}
+		t, shrunk, err = n.shrunk(-1, env)
+		return t, value, shrunk, true, err
+	}
+}
+
+// delMinAssoc returns the non-empty assoc t without the smallest key and the smallest key-value pair.
+// It reports whether the depth decreased.
+func delMinAssoc(t Term, env *Env) (_ Term, key, value Term, shrunk bool, err error) {
+	n, _, err := parseAssoc(t, env)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	if _, ok, err := parseAssoc(n.left, env); err != nil || !ok {
+		return n.right, n.key, n.value, true, err
+	}
+	n.left, key, value, shrunk, err = delMinAssoc(n.left, env)
+	if err != nil || !shrunk {
+		return n.term(), key, value, false, err
+	}
+	t, shrunk, err = n.shrunk(1, env)
+	return t, key, value, shrunk, err
+}
+
+// walkAssoc calls f for each key-value pair of t in the order of the keys.
+func walkAssoc(t Term, env *Env, f func(key, value Term)) error {
+	n, ok, err := parseAssoc(t, env)
+	if err != nil || !ok {
+		return err
+	}
+	if err := walkAssoc(n.left, env, f); err != nil {
+		return err
+	}
+	f(n.key, n.value)
+	return walkAssoc(n.right, env, f)
+}
+
+// assocError returns an exception for assoc which turned out not to be an assoc.
+func assocError(assoc Term, env *Env) error {
+	if _, ok := env.Resolve(assoc).(Variable); ok {
+		return InstantiationError(env)
+	}
+	return typeError(validTypeAssoc, assoc, env)
+}
+
+// EmptyAssoc succeeds if assoc is the empty assoc.
+func EmptyAssoc(vm *VM, assoc Term, k Cont, env *Env) *Promise {
+	return Unify(vm, assoc, atomT, k, env)
+}
+
+// GetAssoc succeeds if key is associated with value in assoc.
+func GetAssoc(vm *VM, key, assoc, value Term, k Cont, env *Env) *Promise {
+	key = env.Resolve(key)
+	t := assoc
+	for {
+		n, ok, err := parseAssoc(t, env)
+		if err != nil {
+			return Error(assocError(assoc, env))
+		}
+		if !ok {
+			return Bool(false)
+		}
+		switch o := key.Compare(n.key, env); {
+		case o < 0:
+			t = n.left
+		case o > 0:
+			t = n.right
+		default:
+			return Unify(vm, value, n.value, k, env)
+		}
+	}
+}
+
+// PutAssoc succeeds if assoc1 is assoc0 with key associated with value.
+func PutAssoc(vm *VM, key, assoc0, value, assoc1 Term, k Cont, env *Env) *Promise {
+	t, _, err := putAssoc(assoc0, key, value, env)
+	if err != nil {
+		return Error(assocError(assoc0, env))
+	}
+	return Unify(vm, assoc1, t, k, env)
+}
+
+// DelAssoc succeeds if assoc1 is assoc0 without key which was associated with value.
+func DelAssoc(vm *VM, key, assoc0, value, assoc1 Term, k Cont, env *Env) *Promise {
+	t, v, _, found, err := delAssoc(assoc0, key, env)
+	if err != nil {
+		return Error(assocError(assoc0, env))
+	}
+	if !found {
+		return Bool(false)
+	}
+	return Unify(vm, tuple(value, assoc1), tuple(v, t), k, env)
+}
+
+// ListToAssoc succeeds if assoc is the assoc of pairs, a list of Key-Value. Keys in pairs must be unique.
+func ListToAssoc(vm *VM, pairs, assoc Term, k Cont, env *Env) *Promise {
+	var ps []Compound
+	iter := ListIterator{List: pairs, Env: env}
+	for iter.Next() {
+		switch p := env.Resolve(iter.Current()).(type) {
+		case Variable:
+			return Error(InstantiationError(env))
+		case Compound:
+			if p.Functor() != atomMinus || p.Arity() != 2 {
+				return Error(typeError(validTypePair, p, env))
+			}
+			ps = append(ps, p)
+		default:
+			return Error(typeError(validTypePair, p, env))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return Error(err)
+	}
+
+	sort.SliceStable(ps, func(i, j int) bool {
+		return ps[i].Arg(0).Compare(ps[j].Arg(0), env) < 0
+	})
+	for i := 1; i < len(ps); i++ {
+		if ps[i-1].Arg(0).Compare(ps[i].Arg(0), env) == 0 {
+			return Error(domainError(validDomainUniqueKeyPairs, pairs, env))
+		}
+	}
+
+	t, _ := sortedAssoc(ps)
+	return Unify(vm, assoc, t, k, env)
+}
+
+// sortedAssoc builds a balanced assoc from pairs sorted by the keys and returns it with its depth.
+func sortedAssoc(ps []Compound) (Term, int) {
+	if len(ps) == 0 {
+		return atomT, 0
+	}
+	m := len(ps) / 2
+	l, dl := sortedAssoc(ps[:m])
+	r, dr := sortedAssoc(ps[m+1:])
+	n := assocNode{key: ps[m].Arg(0), value: ps[m].Arg(1), balance: dr - dl, left: l, right: r}
+	if dl > dr {
+		return n.term(), dl + 1
+	}
+	return n.term(), dr + 1
+}
+
+// AssocToList succeeds if pairs is the list of Key-Value in assoc in the order of the keys.
+func AssocToList(vm *VM, assoc, pairs Term, k Cont, env *Env) *Promise {
+	var ps []Term
+	if err := walkAssoc(assoc, env, func(key, value Term) {
+		ps = append(ps, atomMinus.Apply(key, value))
+	}); err != nil {
+		return Error(assocError(assoc, env))
+	}
+	return Unify(vm, pairs, List(ps...), k, env)
+}
+
+// AssocToKeys succeeds if keys is the list of the keys in assoc in order.
+func AssocToKeys(vm *VM, assoc, keys Term, k Cont, env *Env) *Promise {
+	var ks []Term
+	if err := walkAssoc(assoc, env, func(key, _ Term) {
+		ks = append(ks, key)
+	}); err != nil {
+		return Error(assocError(assoc, env))
+	}
+	return Unify(vm, keys, List(ks...), k, env)
+}
+
+// AssocToValues succeeds if values is the list of the values in assoc in the order of the keys.
+func AssocToValues(vm *VM, assoc, values Term, k Cont, env *Env) *Promise {
+	var vs []Term
+	if err := walkAssoc(assoc, env, func(_, value Term) {
+		vs = append(vs, value)
+	}); err != nil {
+		return Error(assocError(assoc, env))
+	}
+	return Unify(vm, values, List(vs...), k, env)
+}
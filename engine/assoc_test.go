@@ -0,0 +1,274 @@
+package engine
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertAssoc checks if a is an AVL tree of which the keys are in order and returns the depth.
+func assertAssoc(t *testing.T, a Term, env *Env) int {
+	t.Helper()
+	n, ok, err := parseAssoc(a, env)
+	assert.NoError(t, err)
+	if !ok {
+		return 0
+	}
+	if l, ok, _ := parseAssoc(n.left, env); ok {
+		assert.Equal(t, -1, l.key.Compare(n.key, env))
+	}
+	if r, ok, _ := parseAssoc(n.right, env); ok {
+		assert.Equal(t, 1, r.key.Compare(n.key, env))
+	}
+	dl, dr := assertAssoc(t, n.left, env), assertAssoc(t, n.right, env)
+	assert.Equal(t, dr-dl, n.balance)
+	if dl > dr {
+		return dl + 1
+	}
+	return dr + 1
+}
+
+func TestEmptyAssoc(t *testing.T) {
+	ok, err := EmptyAssoc(nil, NewAtom("t"), Success, nil).Force(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = EmptyAssoc(nil, atomT.Apply(NewAtom("a"), Integer(1), atomEqual, atomT, atomT), Success, nil).Force(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestGetAssoc(t *testing.T) {
+	a := atomT.Apply(NewAtom("b"), Integer(2), atomEqual,
+		atomT.Apply(NewAtom("a"), Integer(1), atomEqual, atomT, atomT),
+		atomT.Apply(NewAtom("c"), Integer(3), atomEqual, atomT, atomT),
+	)
+	v := NewVariable()
+
+	tests := []struct {
+		title      string
+		key, assoc Term
+		ok         bool
+		err        error
+		value      Term
+	}{
+		{title: "root", key: NewAtom("b"), assoc: a, ok: true, value: Integer(2)},
+		{title: "left", key: NewAtom("a"), assoc: a, ok: true, value: Integer(1)},
+		{title: "right", key: NewAtom("c"), assoc: a, ok: true, value: Integer(3)},
+		{title: "not found", key: NewAtom("d"), assoc: a, ok: false},
+		{title: "empty", key: NewAtom("a"), assoc: atomT, ok: false},
+		{title: "assoc is a variable", key: NewAtom("a"), assoc: NewVariable(), err: InstantiationError(nil)},
+		{title: "assoc is not an assoc", key: NewAtom("a"), assoc: NewAtom("foo"), err: typeError(validTypeAssoc, NewAtom("foo"), nil)},
+		{title: "assoc is broken", key: NewAtom("a"), assoc: atomT.Apply(NewAtom("b"), Integer(2), NewAtom("?"), atomT, atomT), err: typeError(validTypeAssoc, atomT.Apply(NewAtom("b"), Integer(2), NewAtom("?"), atomT, atomT), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := GetAssoc(nil, tt.key, tt.assoc, v, func(env *Env) *Promise {
+				assert.Equal(t, tt.value, env.Resolve(v))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestPutAssoc(t *testing.T) {
+	put := func(t *testing.T, a Term, key, value Term) Term {
+		t.Helper()
+		r := NewVariable()
+		var ret Term
+		ok, err := PutAssoc(nil, key, a, value, r, func(env *Env) *Promise {
+			ret = env.Resolve(r)
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		return ret
+	}
+
+	for _, order := range []struct {
+		title string
+		keys  []int
+	}{
+		{title: "ascending", keys: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}},
+		{title: "descending", keys: []int{15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}},
+		{title: "zigzag", keys: []int{0, 15, 1, 14, 2, 13, 3, 12, 4, 11, 5, 10, 6, 9, 7, 8}},
+		{title: "random", keys: rand.New(rand.NewSource(1)).Perm(100)},
+	} {
+		t.Run(order.title, func(t *testing.T) {
+			a := Term(atomT)
+			for _, k := range order.keys {
+				a = put(t, a, Integer(k), Integer(k*k))
+				assertAssoc(t, a, nil)
+			}
+
+			var want []Term
+			for i := range order.keys {
+				want = append(want, atomMinus.Apply(Integer(i), Integer(i*i)))
+			}
+			ok, err := AssocToList(nil, a, List(want...), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+
+	t.Run("replace", func(t *testing.T) {
+		a := put(t, put(t, atomT, NewAtom("a"), Integer(1)), NewAtom("a"), Integer(2))
+		assert.Equal(t, atomT.Apply(NewAtom("a"), Integer(2), atomEqual, atomT, atomT), a)
+	})
+
+	t.Run("original is intact", func(t *testing.T) {
+		a := put(t, atomT, NewAtom("a"), Integer(1))
+		_ = put(t, a, NewAtom("b"), Integer(2))
+		assert.Equal(t, atomT.Apply(NewAtom("a"), Integer(1), atomEqual, atomT, atomT), a)
+	})
+
+	t.Run("assoc is a variable", func(t *testing.T) {
+		_, err := PutAssoc(nil, NewAtom("a"), NewVariable(), Integer(1), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+	})
+
+	t.Run("assoc is not an assoc", func(t *testing.T) {
+		_, err := PutAssoc(nil, NewAtom("a"), NewAtom("foo"), Integer(1), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeAssoc, NewAtom("foo"), nil), err)
+	})
+}
+
+func TestDelAssoc(t *testing.T) {
+	del := func(t *testing.T, a Term, key Term) (Term, Term, bool) {
+		t.Helper()
+		v, r := NewVariable(), NewVariable()
+		var value, ret Term
+		ok, err := DelAssoc(nil, key, a, v, r, func(env *Env) *Promise {
+			value, ret = env.Resolve(v), env.Resolve(r)
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		return ret, value, ok
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 2, 3, 10, 100} {
+		pairs := make([]Term, n)
+		for i := range pairs {
+			pairs[i] = atomMinus.Apply(Integer(i), Integer(i*i))
+		}
+		a := NewVariable()
+		var assoc Term
+		ok, err := ListToAssoc(nil, List(pairs...), a, func(env *Env) *Promise {
+			assoc = env.Resolve(a)
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		for _, k := range rnd.Perm(n) {
+			var (
+				value Term
+				ok    bool
+			)
+			assoc, value, ok = del(t, assoc, Integer(k))
+			assert.True(t, ok)
+			assert.Equal(t, Integer(k*k), value)
+			assertAssoc(t, assoc, nil)
+
+			_, _, ok = del(t, assoc, Integer(k))
+			assert.False(t, ok)
+		}
+		assert.Equal(t, atomT, assoc)
+	}
+
+	t.Run("assoc is not an assoc", func(t *testing.T) {
+		_, err := DelAssoc(nil, NewAtom("a"), NewAtom("foo"), NewVariable(), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeAssoc, NewAtom("foo"), nil), err)
+	})
+}
+
+func TestListToAssoc(t *testing.T) {
+	a := NewVariable()
+
+	tests := []struct {
+		title string
+		pairs Term
+		ok    bool
+		err   error
+		list  Term
+	}{
+		{title: "empty", pairs: List(), ok: true, list: List()},
+		{title: "unsorted", pairs: List(
+			atomMinus.Apply(NewAtom("c"), Integer(3)),
+			atomMinus.Apply(NewAtom("a"), Integer(1)),
+			atomMinus.Apply(NewAtom("d"), Integer(4)),
+			atomMinus.Apply(NewAtom("b"), Integer(2)),
+		), ok: true, list: List(
+			atomMinus.Apply(NewAtom("a"), Integer(1)),
+			atomMinus.Apply(NewAtom("b"), Integer(2)),
+			atomMinus.Apply(NewAtom("c"), Integer(3)),
+			atomMinus.Apply(NewAtom("d"), Integer(4)),
+		)},
+		{title: "duplicate keys", pairs: List(
+			atomMinus.Apply(NewAtom("a"), Integer(1)),
+			atomMinus.Apply(NewAtom("a"), Integer(2)),
+		), err: domainError(validDomainUniqueKeyPairs, List(
+			atomMinus.Apply(NewAtom("a"), Integer(1)),
+			atomMinus.Apply(NewAtom("a"), Integer(2)),
+		), nil)},
+		{title: "partial list", pairs: PartialList(NewVariable(), atomMinus.Apply(NewAtom("a"), Integer(1))), err: InstantiationError(nil)},
+		{title: "element is a variable", pairs: List(NewVariable()), err: InstantiationError(nil)},
+		{title: "element is not a pair", pairs: List(NewAtom("a")), err: typeError(validTypePair, NewAtom("a"), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := ListToAssoc(nil, tt.pairs, a, func(env *Env) *Promise {
+				assertAssoc(t, a, env)
+				return AssocToList(nil, a, tt.list, Success, env)
+			}, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestAssocToList(t *testing.T) {
+	a := atomT.Apply(NewAtom("b"), Integer(2), atomLessThan,
+		atomT.Apply(NewAtom("a"), Integer(1), atomEqual, atomT, atomT),
+		atomT.Apply(NewAtom("c"), Integer(3), atomLessThan, atomT,
+			atomT.Apply(NewAtom("d"), Integer(4), atomEqual, atomT, atomT),
+		),
+	)
+
+	tests := []struct {
+		title string
+		f     Predicate2
+		list  Term
+	}{
+		{title: "assoc_to_list", f: AssocToList, list: List(
+			atomMinus.Apply(NewAtom("a"), Integer(1)),
+			atomMinus.Apply(NewAtom("b"), Integer(2)),
+			atomMinus.Apply(NewAtom("c"), Integer(3)),
+			atomMinus.Apply(NewAtom("d"), Integer(4)),
+		)},
+		{title: "assoc_to_keys", f: AssocToKeys, list: List(NewAtom("a"), NewAtom("b"), NewAtom("c"), NewAtom("d"))},
+		{title: "assoc_to_values", f: AssocToValues, list: List(Integer(1), Integer(2), Integer(3), Integer(4))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := tt.f(nil, a, tt.list, Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+
+			ok, err = tt.f(nil, atomT, List(), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+
+			_, err = tt.f(nil, NewAtom("foo"), NewVariable(), Success, nil).Force(context.Background())
+			assert.Equal(t, typeError(validTypeAssoc, NewAtom("foo"), nil), err)
+		})
+	}
+}
@@ -38,8 +38,13 @@ var (
 	atomLessThan          = NewAtom("<")
 	atomEqual             = NewAtom("=")
 	atomGreaterThan       = NewAtom(">")
+	atomAtLessThan        = NewAtom("@<")
+	atomAtLessOrEqual     = NewAtom("@=<")
+	atomAtGreaterThan     = NewAtom("@>")
+	atomAtGreaterOrEqual  = NewAtom("@>=")
 	atomDot               = NewAtom(".")
 	atomComma             = NewAtom(",")
+	atomColon             = NewAtom(":")
 	atomBar               = NewAtom("|")
 	atomCut               = NewAtom("!")
 	atomSemiColon         = NewAtom(";")
@@ -54,17 +59,24 @@ var (
 	atomBitwiseOr         = NewAtom(`\/`)
 	atomElipsis           = NewAtom(`...`)
 
+	atomASCII                   = NewAtom("ascii")
 	atomAbs                     = NewAtom("abs")
 	atomAccess                  = NewAtom("access")
 	atomAcos                    = NewAtom("acos")
+	atomAggregateSpec           = NewAtom("aggregate_spec")
 	atomAlias                   = NewAtom("alias")
+	atomAlnum                   = NewAtom("alnum")
+	atomAlpha                   = NewAtom("alpha")
 	atomAppend                  = NewAtom("append")
 	atomAsin                    = NewAtom("asin")
+	atomAssoc                   = NewAtom("assoc")
 	atomAt                      = NewAtom("at")
 	atomAtan                    = NewAtom("atan")
 	atomAtan2                   = NewAtom("atan2")
 	atomAtom                    = NewAtom("atom")
 	atomAtomic                  = NewAtom("atomic")
+	atomAutoFlush               = NewAtom("auto_flush")
+	atomBag                     = NewAtom("bag")
 	atomBinary                  = NewAtom("binary")
 	atomBinaryStream            = NewAtom("binary_stream")
 	atomBounded                 = NewAtom("bounded")
@@ -73,16 +85,25 @@ var (
 	atomCallable                = NewAtom("callable")
 	atomCeiling                 = NewAtom("ceiling")
 	atomCharConversion          = NewAtom("char_conversion")
+	atomCharType                = NewAtom("char_type")
 	atomCharacter               = NewAtom("character")
 	atomCharacterCode           = NewAtom("character_code")
 	atomCharacterCodeList       = NewAtom("character_code_list")
 	atomChars                   = NewAtom("chars")
 	atomCloseOption             = NewAtom("close_option")
+	atomCntrl                   = NewAtom("cntrl")
 	atomCodes                   = NewAtom("codes")
 	atomCompound                = NewAtom("compound")
+	atomConsultOption           = NewAtom("consult_option")
+	atomContinue                = NewAtom("continue")
+	atomCopysign                = NewAtom("copysign")
 	atomCos                     = NewAtom("cos")
+	atomCount                   = NewAtom("count")
 	atomCreate                  = NewAtom("create")
+	atomCsym                    = NewAtom("csym")
+	atomCsymf                   = NewAtom("csymf")
 	atomDebug                   = NewAtom("debug")
+	atomDigit                   = NewAtom("digit")
 	atomDiscontiguous           = NewAtom("discontiguous")
 	atomDiv                     = NewAtom("div")
 	atomDomainError             = NewAtom("domain_error")
@@ -92,8 +113,10 @@ var (
 	atomEOFAction               = NewAtom("eof_action")
 	atomEOFCode                 = NewAtom("eof_code")
 	atomEndOfFile               = NewAtom("end_of_file")
+	atomEndOfLine               = NewAtom("end_of_line")
 	atomEndOfStream             = NewAtom("end_of_stream")
 	atomEnsureLoaded            = NewAtom("ensure_loaded")
+	atomEpsilon                 = NewAtom("epsilon")
 	atomError                   = NewAtom("error")
 	atomEvaluable               = NewAtom("evaluable")
 	atomEvaluationError         = NewAtom("evaluation_error")
@@ -113,12 +136,20 @@ var (
 	atomFloatOverflow           = NewAtom("float_overflow")
 	atomFloor                   = NewAtom("floor")
 	atomForce                   = NewAtom("force")
+	atomFormat                  = NewAtom("format")
+	atomGcd                     = NewAtom("gcd")
+	atomGraph                   = NewAtom("graph")
+	atomHalt                    = NewAtom("halt")
 	atomIOMode                  = NewAtom("io_mode")
 	atomIgnoreOps               = NewAtom("ignore_ops")
 	atomInByte                  = NewAtom("in_byte")
 	atomInCharacter             = NewAtom("in_character")
 	atomInCharacterCode         = NewAtom("in_character_code")
 	atomInclude                 = NewAtom("include")
+	atomInf                     = NewAtom("inf")
+	atomInferenceLimitExceeded  = NewAtom("inference_limit_exceeded")
+	atomInferences              = NewAtom("inferences")
+	atomInfinite                = NewAtom("infinite")
 	atomInitialization          = NewAtom("initialization")
 	atomInput                   = NewAtom("input")
 	atomInstantiationError      = NewAtom("instantiation_error")
@@ -127,17 +158,24 @@ var (
 	atomIntegerRoundingFunction = NewAtom("integer_rounding_function")
 	atomList                    = NewAtom("list")
 	atomLog                     = NewAtom("log")
+	atomLower                   = NewAtom("lower")
+	atomLsb                     = NewAtom("lsb")
 	atomMax                     = NewAtom("max")
 	atomMaxArity                = NewAtom("max_arity")
 	atomMaxDepth                = NewAtom("max_depth")
 	atomMaxInteger              = NewAtom("max_integer")
+	atomMaxTaggedInteger        = NewAtom("max_tagged_integer")
 	atomMemory                  = NewAtom("memory")
+	atomMessageHook             = NewAtom("message_hook")
 	atomMin                     = NewAtom("min")
 	atomMinInteger              = NewAtom("min_integer")
+	atomMinTaggedInteger        = NewAtom("min_tagged_integer")
 	atomMod                     = NewAtom("mod")
 	atomMode                    = NewAtom("mode")
 	atomModify                  = NewAtom("modify")
+	atomMsb                     = NewAtom("msb")
 	atomMultifile               = NewAtom("multifile")
+	atomNaN                     = NewAtom("nan")
 	atomNonEmptyList            = NewAtom("non_empty_list")
 	atomNot                     = NewAtom("not")
 	atomNotLessThanZero         = NewAtom("not_less_than_zero")
@@ -145,24 +183,34 @@ var (
 	atomNumberVars              = NewAtom("numbervars")
 	atomOff                     = NewAtom("off")
 	atomOn                      = NewAtom("on")
+	atomOnError                 = NewAtom("on_error")
 	atomOpen                    = NewAtom("open")
 	atomOperator                = NewAtom("operator")
 	atomOperatorPriority        = NewAtom("operator_priority")
 	atomOperatorSpecifier       = NewAtom("operator_specifier")
 	atomOrder                   = NewAtom("order")
 	atomOutput                  = NewAtom("output")
+	atomOutputSink              = NewAtom("output_sink")
 	atomPair                    = NewAtom("pair")
+	atomParen                   = NewAtom("paren")
 	atomPast                    = NewAtom("past")
 	atomPastEndOfStream         = NewAtom("past_enf_of_stream")
+	atomPeriod                  = NewAtom("period")
 	atomPermissionError         = NewAtom("permission_error")
 	atomPhrase                  = NewAtom("phrase")
 	atomPi                      = NewAtom("pi")
+	atomPopcount                = NewAtom("popcount")
 	atomPosition                = NewAtom("position")
 	atomPredicateIndicator      = NewAtom("predicate_indicator")
+	atomPrint                   = NewAtom("print")
 	atomPrivateProcedure        = NewAtom("private_procedure")
 	atomProcedure               = NewAtom("procedure")
 	atomPrologFlag              = NewAtom("prolog_flag")
+	atomPunct                   = NewAtom("punct")
+	atomQuiet                   = NewAtom("quiet")
+	atomQuote                   = NewAtom("quote")
 	atomQuoted                  = NewAtom("quoted")
+	atomRandom                  = NewAtom("random")
 	atomRead                    = NewAtom("read")
 	atomReadOption              = NewAtom("read_option")
 	atomRem                     = NewAtom("rem")
@@ -171,11 +219,14 @@ var (
 	atomReset                   = NewAtom("reset")
 	atomResourceError           = NewAtom("resource_error")
 	atomRound                   = NewAtom("round")
+	atomSet                     = NewAtom("set")
 	atomSign                    = NewAtom("sign")
+	atomSilent                  = NewAtom("silent")
 	atomSin                     = NewAtom("sin")
 	atomSingletons              = NewAtom("singletons")
 	atomSmallE                  = NewAtom("e")
 	atomSourceSink              = NewAtom("source_sink")
+	atomSpace                   = NewAtom("space")
 	atomSqrt                    = NewAtom("sqrt")
 	atomStaticProcedure         = NewAtom("static_procedure")
 	atomStream                  = NewAtom("stream")
@@ -183,11 +234,20 @@ var (
 	atomStreamOrAlias           = NewAtom("stream_or_alias")
 	atomStreamPosition          = NewAtom("stream_position")
 	atomStreamProperty          = NewAtom("stream_property")
+	atomString                  = NewAtom("string")
+	atomSum                     = NewAtom("sum")
+	atomSync                    = NewAtom("sync")
 	atomSyntaxError             = NewAtom("syntax_error")
+	atomSyntaxErrors            = NewAtom("syntax_errors")
+	atomSystem                  = NewAtom("system")
+	atomT                       = NewAtom("t")
 	atomTan                     = NewAtom("tan")
 	atomTermExpansion           = NewAtom("term_expansion")
 	atomText                    = NewAtom("text")
 	atomTextStream              = NewAtom("text_stream")
+	atomTimeLimitExceeded       = NewAtom("time_limit_exceeded")
+	atomToLower                 = NewAtom("to_lower")
+	atomToUpper                 = NewAtom("to_upper")
 	atomTowardZero              = NewAtom("toward_zero")
 	atomTrue                    = NewAtom("true")
 	atomTruncate                = NewAtom("truncate")
@@ -196,18 +256,25 @@ var (
 	atomUnbounded               = NewAtom("unbounded")
 	atomUndefined               = NewAtom("undefined")
 	atomUnderflow               = NewAtom("underflow")
+	atomUniqueKeyPairs          = NewAtom("unique_key_pairs")
 	atomUnknown                 = NewAtom("unknown")
+	atomUpper                   = NewAtom("upper")
+	atomUser                    = NewAtom("user")
+	atomUserError               = NewAtom("user_error")
 	atomUserInput               = NewAtom("user_input")
 	atomUserOutput              = NewAtom("user_output")
 	atomVar                     = NewAtom("$VAR")
 	atomVariableNames           = NewAtom("variable_names")
 	atomVariables               = NewAtom("variables")
 	atomWarning                 = NewAtom("warning")
+	atomWhite                   = NewAtom("white")
+	atomWrap                    = NewAtom("wrap")
 	atomWrite                   = NewAtom("write")
 	atomWriteOption             = NewAtom("write_option")
 	atomXF                      = NewAtom("xf")
 	atomXFX                     = NewAtom("xfx")
 	atomXFY                     = NewAtom("xfy")
+	atomXdigit                  = NewAtom("xdigit")
 	atomXor                     = NewAtom("xor")
 	atomYF                      = NewAtom("yf")
 	atomYFX                     = NewAtom("yfx")
@@ -3,6 +3,7 @@ package engine
 import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 )
 
@@ -16,12 +17,17 @@ func TestAtom_WriteTerm(t *testing.T) {
 		{name: `a`, opts: WriteOptions{quoted: true}, output: `a`},
 		{name: "\a\b\f\n\r\t\v\x00\\'\"`", opts: WriteOptions{quoted: false}, output: "\a\b\f\n\r\t\v\x00\\'\"`"},
 		{name: "\a\b\f\n\r\t\v\x00\\'\"`", opts: WriteOptions{quoted: true}, output: "'\\a\\b\\f\\n\\r\\t\\v\\x0\\\\\\\\'\"`'"},
+		{name: ``, opts: WriteOptions{quoted: false}, output: ``},
+		{name: ``, opts: WriteOptions{quoted: true}, output: `''`},
 		{name: `,`, opts: WriteOptions{quoted: false}, output: `,`},
 		{name: `,`, opts: WriteOptions{quoted: true}, output: `','`},
 		{name: `[]`, opts: WriteOptions{quoted: false}, output: `[]`},
 		{name: `[]`, opts: WriteOptions{quoted: true}, output: `[]`},
 		{name: `{}`, opts: WriteOptions{quoted: false}, output: `{}`},
 		{name: `{}`, opts: WriteOptions{quoted: true}, output: `{}`},
+		{name: `!`, opts: WriteOptions{quoted: true}, output: `!`},
+		{name: `;`, opts: WriteOptions{quoted: true}, output: `;`},
+		{name: `|`, opts: WriteOptions{quoted: true}, output: `'|'`},
 		{name: `-`, output: `-`},
 		{name: `-`, opts: WriteOptions{ops: operators{atomPlus: {}, atomMinus: {}}, left: operator{specifier: operatorSpecifierFY, name: atomPlus}}, output: ` (-)`},
 		{name: `-`, opts: WriteOptions{ops: operators{atomPlus: {}, atomMinus: {}}, right: operator{name: atomPlus}}, output: `(-)`},
@@ -41,6 +47,21 @@ func TestAtom_WriteTerm(t *testing.T) {
 	}
 }
 
+func TestAtom_WriteTerm_roundTrip(t *testing.T) {
+	var vm VM
+	vm.InstallDefaultOperators()
+	for _, name := range []string{``, `[]`, `{}`, `!`, `;`, `,`, `|`, `foo`, `Foo`, `foo bar`} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.NoError(t, NewAtom(name).WriteTerm(&buf, &WriteOptions{quoted: true, ops: vm.operators}, nil))
+			p := NewParser(&vm, strings.NewReader(buf.String()+" ."))
+			a, err := p.Term()
+			assert.NoError(t, err)
+			assert.Equal(t, NewAtom(name), a)
+		})
+	}
+}
+
 func TestAtom_Compare(t *testing.T) {
 	x := NewVariable()
 
@@ -2,26 +2,6 @@
  *  bootstrap script
  */
 
-% Operators
-
-:-(op(1200, xfx, [:-, -->])).
-:-(op(1200, fx, [:-, ?-])).
-:-(op(1105, xfy, '|')).
-:-(op(1100, xfy, ;)).
-:-(op(1050, xfy, ->)).
-:-(op(1000, xfy, ',')).
-:-(op(900, fy, \+)).
-:-(op(700, xfx, [=, \=])).
-:-(op(700, xfx, [==, \==, @<, @=<, @>, @>=])).
-:-(op(700, xfx, =..)).
-:-(op(700, xfx, [is, =:=, =\=, <, =<, >, >=])).
-:-(op(600, xfy, :)).
-:-(op(500, yfx, [+, -, /\, \/])).
-:-(op(400, yfx, [*, /, //, div, rem, mod, <<, >>])).
-:-(op(200, xfx, **)).
-:-(op(200, xfy, ^)).
-:-(op(200, fy, [+, -, \])).
-
 % Control constructs
 
 true.
@@ -146,6 +126,10 @@ nl :-
 nl(S) :-
   put_char(S, '\n').
 
+tab(N) :-
+  current_output(S),
+  tab(S, N).
+
 % Byte input/output
 
 get_byte(Byte) :-
@@ -194,6 +178,10 @@ write_canonical(Term) :-
 
 write_canonical(Stream, Term) :- write_term(Stream, Term, [quoted(true), ignore_ops(true)]).
 
+format(Format, Args) :-
+  current_output(S),
+  format(S, Format, Args).
+
 % Logic and control
 
 once(P) :- P, !.
@@ -6,9 +6,12 @@ import (
 	"errors"
 	"io"
 	"io/fs"
+	"math"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -20,6 +23,32 @@ func Repeat(_ *VM, k Cont, env *Env) *Promise {
 	})
 }
 
+// RepeatN repeats the continuation at most n times until it succeeds.
+func RepeatN(_ *VM, n Term, k Cont, env *Env) *Promise {
+	switch n := env.Resolve(n).(type) {
+	case Variable:
+		return Error(InstantiationError(env))
+	case Integer:
+		if n < 0 {
+			return Error(domainError(validDomainNotLessThanZero, n, env))
+		}
+		return repeatN(n, k, env)
+	default:
+		return Error(typeError(validTypeInteger, n, env))
+	}
+}
+
+func repeatN(n Integer, k Cont, env *Env) *Promise {
+	if n == 0 {
+		return Bool(false)
+	}
+	return Delay(func(context.Context) *Promise {
+		return k(env)
+	}, func(context.Context) *Promise {
+		return repeatN(n-1, k, env)
+	})
+}
+
 // Negate calls goal and returns false if it succeeds. Otherwise, invokes the continuation.
 func Negate(vm *VM, goal Term, k Cont, env *Env) *Promise {
 	return Delay(func(ctx context.Context) *Promise {
@@ -34,6 +63,22 @@ func Negate(vm *VM, goal Term, k Cont, env *Env) *Promise {
 	})
 }
 
+// Forall succeeds iff action succeeds for every solution of cond. It leaves no bindings from cond nor action.
+func Forall(vm *VM, cond, action Term, k Cont, env *Env) *Promise {
+	return Delay(func(ctx context.Context) *Promise {
+		ok, err := Call(vm, cond, func(env *Env) *Promise {
+			return Negate(vm, action, Success, env)
+		}, env).Force(ctx)
+		if err != nil {
+			return Error(err)
+		}
+		if ok {
+			return Bool(false)
+		}
+		return k(env)
+	})
+}
+
 // Call executes goal. it succeeds if goal followed by k succeeds. A cut inside goal doesn't affect outside of Call.
 func Call(vm *VM, goal Term, k Cont, env *Env) (promise *Promise) {
 	defer ensurePromise(&promise)
@@ -143,7 +188,7 @@ func CallNth(vm *VM, goal, nth Term, k Cont, env *Env) *Promise {
 
 		u := Unify(vm, n, nth, k, env)
 		if nth, ok := nth.(Integer); ok && nth <= n {
-			return cut(p, func(context.Context) *Promise {
+			return Cut(p, func(context.Context) *Promise {
 				return u
 			})
 		}
@@ -152,6 +197,144 @@ func CallNth(vm *VM, goal, nth Term, k Cont, env *Env) *Promise {
 	return p
 }
 
+// CallWithInferenceLimit calls goal as once/1 with at most limit inferences. It unifies result with ! if goal succeeds
+// or inference_limit_exceeded if goal doesn't complete within the limit. See WithInferenceLimit for inferences.
+func CallWithInferenceLimit(vm *VM, goal, limit, result Term, k Cont, env *Env) *Promise {
+	var n Integer
+	switch l := env.Resolve(limit).(type) {
+	case Variable:
+		return Error(InstantiationError(env))
+	case Integer:
+		if l < 0 {
+			return Error(domainError(validDomainNotLessThanZero, l, env))
+		}
+		n = l
+	default:
+		return Error(typeError(validTypeInteger, limit, env))
+	}
+
+	return Delay(func(ctx context.Context) *Promise {
+		limited := WithInferenceLimit(ctx, uint64(n))
+		l := limited.Value(inferenceLimitKey{}).(*inferenceLimit)
+
+		var solution *Env
+		ok, err := Call(vm, goal, func(env *Env) *Promise {
+			solution = env
+			return Bool(true)
+		}, env).Force(limited)
+		switch {
+		case err != nil && l.exceeded():
+			return Unify(vm, result, atomInferenceLimitExceeded, k, env)
+		case err != nil:
+			return Error(err)
+		case !ok:
+			return Bool(false)
+		default:
+			return Unify(vm, result, atomCut, k, solution)
+		}
+	})
+}
+
+// CallWithTimeLimit calls goal as once/1 but throws time_limit_exceeded if goal doesn't complete in the given seconds.
+func CallWithTimeLimit(vm *VM, seconds, goal Term, k Cont, env *Env) *Promise {
+	var d time.Duration
+	switch t := env.Resolve(seconds).(type) {
+	case Variable:
+		return Error(InstantiationError(env))
+	case Integer:
+		d = time.Duration(t) * time.Second
+	case Float:
+		d = time.Duration(float64(t) * float64(time.Second))
+	default:
+		return Error(typeError(validTypeNumber, seconds, env))
+	}
+
+	return Delay(func(ctx context.Context) *Promise {
+		limited, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+
+		var solution *Env
+		ok, err := Call(vm, goal, func(env *Env) *Promise {
+			solution = env
+			return Bool(true)
+		}, env).Force(limited)
+		switch {
+		case err != nil && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded):
+			return Error(NewException(atomTimeLimitExceeded, env))
+		case err != nil:
+			return Error(err)
+		case !ok:
+			return Bool(false)
+		default:
+			return k(solution)
+		}
+	})
+}
+
+// WithOutputTo calls goal as once/1 with the current output redirected to sink, which is one of atom(A), string(S),
+// codes(Cs), or chars(Cs). Since there is no string type, S is represented in the same way as a double-quoted string,
+// i.e. as codes, chars, or an atom depending on the double_quotes flag.
+// The current output is restored whether goal succeeds, fails, or throws an exception.
+func WithOutputTo(vm *VM, sink, goal Term, k Cont, env *Env) *Promise {
+	text, err := outputSink(vm, sink, env)
+	if err != nil {
+		return Error(err)
+	}
+
+	return Delay(func(ctx context.Context) *Promise {
+		var sb strings.Builder
+		s := NewOutputTextStream(&sb)
+		s.vm = vm
+
+		var solution *Env
+		ok, err := func() (bool, error) {
+			output := vm.output
+			vm.output = s
+			defer func() {
+				vm.output = output
+			}()
+			return Call(vm, goal, func(env *Env) *Promise {
+				solution = env
+				return Bool(true)
+			}, env).Force(ctx)
+		}()
+		if err != nil {
+			return Error(err)
+		}
+		if !ok {
+			return Bool(false)
+		}
+
+		return Unify(vm, env.Resolve(sink).(Compound).Arg(0), text(sb.String()), k, solution)
+	})
+}
+
+// outputSink returns a function which converts the text written to sink into the term unified with the argument of sink.
+func outputSink(vm *VM, sink Term, env *Env) (func(string) Term, error) {
+	switch s := env.Resolve(sink).(type) {
+	case Variable:
+		return nil, InstantiationError(env)
+	case Compound:
+		if s.Arity() != 1 {
+			return nil, domainError(validDomainOutputSink, sink, env)
+		}
+		switch s.Functor() {
+		case atomAtom:
+			return func(s string) Term { return NewAtom(s) }, nil
+		case atomString:
+			return vm.doubleQuotes.term, nil
+		case atomCodes:
+			return func(s string) Term { return CodeList(s) }, nil
+		case atomChars:
+			return func(s string) Term { return CharList(s) }, nil
+		default:
+			return nil, domainError(validDomainOutputSink, sink, env)
+		}
+	default:
+		return nil, domainError(validDomainOutputSink, sink, env)
+	}
+}
+
 // Unify unifies x and y without occurs check (i.e., X = f(X) is allowed).
 func Unify(_ *VM, x, y Term, k Cont, env *Env) *Promise {
 	env, ok := env.Unify(x, y)
@@ -284,6 +467,10 @@ func Functor(vm *VM, t, name, arity Term, k Cont, env *Env) *Promise {
 				return Error(typeError(validTypeAtom, name, env))
 			}
 
+			if vm.MaxArity > 0 && arity > Integer(vm.MaxArity) {
+				return Error(representationError(flagMaxArity, env))
+			}
+
 			vs, err := makeSlice(int(arity))
 			if err != nil {
 				return Error(resourceError(resourceMemory, env))
@@ -411,12 +598,19 @@ func renamedCopy(t Term, copied map[termID]Term, env *Env) (Term, error) {
 		}
 		l := list(s)
 		copied[id(t)] = l
+		shared := true
 		for i := range t {
 			c, err := renamedCopy(t[i], copied, env)
 			if err != nil {
 				return nil, err
 			}
 			l[i] = c
+			shared = shared && id(c) == id(t[i])
+		}
+		// The list is shared if no element differs from its copy.
+		if shared {
+			copied[id(t)] = t
+			return t, nil
 		}
 		return l, nil
 	case *partial:
@@ -435,28 +629,112 @@ func renamedCopy(t Term, copied map[termID]Term, env *Env) (Term, error) {
 		p.tail = &tail
 		return &p, nil
 	case Compound:
-		args, err := makeSlice(t.Arity())
-		if err != nil {
-			return nil, resourceError(resourceMemory, env)
-		}
+		// The compound is shared unless an argument differs from its copy.
+		// Arguments are allocated lazily so that ground subterms cost nothing.
 		c := compound{
 			functor: t.Functor(),
-			args:    args,
 		}
 		copied[id(t)] = &c
 		for i := 0; i < t.Arity(); i++ {
-			cp, err := renamedCopy(t.Arg(i), copied, env)
+			arg := t.Arg(i)
+			cp, err := renamedCopy(arg, copied, env)
 			if err != nil {
 				return nil, err
 			}
+			if c.args == nil {
+				if id(cp) == id(arg) {
+					continue
+				}
+				args, err := makeSlice(t.Arity())
+				if err != nil {
+					return nil, resourceError(resourceMemory, env)
+				}
+				c.args = args
+				for j := 0; j < i; j++ {
+					c.args[j] = t.Arg(j)
+				}
+			}
 			c.args[i] = cp
 		}
+		if c.args == nil {
+			copied[id(t)] = t
+			return t, nil
+		}
 		return &c, nil
 	default:
 		return t, nil
 	}
 }
 
+// UnnumberVars succeeds iff out unifies with in with each '$VAR'(N) replaced by a fresh variable.
+// Occurrences of '$VAR'(N) with the same N are replaced by the same variable. Variables in in are kept as they are.
+func UnnumberVars(vm *VM, in, out Term, k Cont, env *Env) *Promise {
+	c, err := unnumberVars(in, map[Integer]Variable{}, nil, env)
+	if err != nil {
+		return Error(err)
+	}
+	return Unify(vm, c, out, k, env)
+}
+
+func unnumberVars(t Term, vars map[Integer]Variable, copied map[termID]Term, env *Env) (Term, error) {
+	if copied == nil {
+		copied = map[termID]Term{}
+	}
+	t = env.Resolve(t)
+	if c, ok := copied[id(t)]; ok {
+		return c, nil
+	}
+	var c Compound
+	switch t := t.(type) {
+	case charList, codeList:
+		return t, nil
+	case Compound:
+		c = t
+	default:
+		return t, nil
+	}
+	if n, ok := env.Resolve(c.Arg(0)).(Integer); ok && c.Functor() == atomVar && c.Arity() == 1 && n >= 0 {
+		v, ok := vars[n]
+		if !ok {
+			v = NewVariable()
+			vars[n] = v
+		}
+		return v, nil
+	}
+
+	// The compound is shared unless an argument differs from its copy.
+	cp := compound{
+		functor: c.Functor(),
+	}
+	copied[id(t)] = &cp
+	for i := 0; i < c.Arity(); i++ {
+		arg := c.Arg(i)
+		a, err := unnumberVars(arg, vars, copied, env)
+		if err != nil {
+			return nil, err
+		}
+		if cp.args == nil {
+			if id(a) == id(arg) {
+				continue
+			}
+			args, err := makeSlice(c.Arity())
+			if err != nil {
+				return nil, resourceError(resourceMemory, env)
+			}
+			cp.args = args
+			for j := 0; j < i; j++ {
+				cp.args[j] = c.Arg(j)
+			}
+		}
+		cp.args[i] = a
+	}
+	if cp.args == nil {
+		copied[id(t)] = t
+		return t, nil
+	}
+	return &cp, nil
+}
+
 // TermVariables succeeds if vars unifies with a list of variables in term.
 func TermVariables(vm *VM, term, vars Term, k Cont, env *Env) *Promise {
 	var (
@@ -669,8 +947,8 @@ func CurrentOp(vm *VM, priority, specifier, op Term, k Cont, env *Env) *Promise
 
 // Assertz appends t to the database.
 func Assertz(vm *VM, t Term, k Cont, env *Env) *Promise {
-	if err := assertMerge(vm, t, func(existing, new []clause) []clause {
-		return append(existing, new...)
+	if err := assertMerge(vm, t, func(u *userDefined, added clauses) {
+		u.append(added)
 	}, env); err != nil {
 		return Error(err)
 	}
@@ -679,15 +957,44 @@ func Assertz(vm *VM, t Term, k Cont, env *Env) *Promise {
 
 // Asserta prepends t to the database.
 func Asserta(vm *VM, t Term, k Cont, env *Env) *Promise {
-	if err := assertMerge(vm, t, func(existing, new []clause) []clause {
-		return append(new, existing...)
+	if err := assertMerge(vm, t, func(u *userDefined, added clauses) {
+		u.prepend(added)
 	}, env); err != nil {
 		return Error(err)
 	}
 	return k(env)
 }
 
-func assertMerge(vm *VM, t Term, merge func([]clause, []clause) []clause, env *Env) error {
+// unqualify strips user: and system: module qualifiers off the clause t and its head.
+// Since there's no module system, other qualifiers are left as they are.
+func unqualify(t Term, env *Env) Term {
+	t, _ = stripModule(t, env)
+	if c, ok := env.Resolve(t).(Compound); ok && c.Functor() == atomIf && c.Arity() == 2 {
+		if head, ok := stripModule(c.Arg(0), env); ok {
+			return atomIf.Apply(head, c.Arg(1))
+		}
+	}
+	return t
+}
+
+func stripModule(t Term, env *Env) (Term, bool) {
+	var stripped bool
+	for {
+		c, ok := env.Resolve(t).(Compound)
+		if !ok || c.Functor() != atomColon || c.Arity() != 2 {
+			return t, stripped
+		}
+		switch env.Resolve(c.Arg(0)) {
+		case atomUser, atomSystem:
+			t, stripped = c.Arg(1), true
+		default:
+			return t, stripped
+		}
+	}
+}
+
+func assertMerge(vm *VM, t Term, merge func(*userDefined, clauses), env *Env) error {
+	t = unqualify(t, env)
 	pi, arg, err := piArg(t, env)
 	if err != nil {
 		return err
@@ -719,7 +1026,7 @@ func assertMerge(vm *VM, t Term, merge func([]clause, []clause) []clause, env *E
 		return permissionError(operationModify, permissionTypeStaticProcedure, pi.Term(), env)
 	}
 
-	u.clauses = merge(u.clauses, added)
+	merge(u, added)
 	return nil
 }
 
@@ -857,21 +1164,116 @@ func FindAll(vm *VM, template, goal, instances Term, k Cont, env *Env) *Promise
 		return Error(err)
 	}
 	return Delay(func(ctx context.Context) *Promise {
-		var answers []Term
-		if _, err := Call(vm, goal, func(env *Env) *Promise {
-			c, err := renamedCopy(template, nil, env)
+		answers, err := findAll(ctx, vm, template, goal, env)
+		if err != nil {
+			return Error(err)
+		}
+		return Unify(vm, instances, List(answers...), k, env)
+	})
+}
+
+// FindAll4 is like FindAll but collects the solutions as a partial list instances which ends with tail.
+func FindAll4(vm *VM, template, goal, instances, tail Term, k Cont, env *Env) *Promise {
+	iter := ListIterator{List: instances, Env: env, AllowPartial: true}
+	for iter.Next() {
+	}
+	if err := iter.Err(); err != nil {
+		return Error(err)
+	}
+	return Delay(func(ctx context.Context) *Promise {
+		answers, err := findAll(ctx, vm, template, goal, env)
+		if err != nil {
+			return Error(err)
+		}
+		return Unify(vm, instances, PartialList(tail, answers...), k, env)
+	})
+}
+
+// AggregateAll aggregates the solutions of goal as specified by aggregate and unifies the result with result.
+// aggregate is one of count, count(Template), sum(Expr), max(Expr), min(Expr), bag(Template), and set(Template).
+// max(Expr) and min(Expr) fail if goal has no solutions.
+func AggregateAll(vm *VM, aggregate, goal, result Term, k Cont, env *Env) *Promise {
+	var (
+		name     Atom
+		template Term
+	)
+	switch a := env.Resolve(aggregate).(type) {
+	case Variable:
+		return Error(InstantiationError(env))
+	case Atom:
+		if a != atomCount {
+			return Error(domainError(validDomainAggregateSpec, a, env))
+		}
+		name, template = a, a
+	case Compound:
+		switch a.Functor() {
+		case atomCount, atomSum, atomMax, atomMin, atomBag, atomSet:
+			if a.Arity() != 1 {
+				return Error(domainError(validDomainAggregateSpec, a, env))
+			}
+			name, template = a.Functor(), a.Arg(0)
+		default:
+			return Error(domainError(validDomainAggregateSpec, a, env))
+		}
+	default:
+		return Error(domainError(validDomainAggregateSpec, a, env))
+	}
+
+	return Delay(func(ctx context.Context) *Promise {
+		answers, err := findAll(ctx, vm, template, goal, env)
+		if err != nil {
+			return Error(err)
+		}
+
+		var r Term
+		switch name {
+		case atomCount:
+			r = Integer(len(answers))
+		case atomBag:
+			r = List(answers...)
+		case atomSet:
+			r = env.set(answers...)
+		default:
+			if len(answers) == 0 {
+				if name != atomSum {
+					return Bool(false)
+				}
+				answers = []Term{Integer(0)}
+			}
+			op := name // max/2 or min/2
+			if name == atomSum {
+				op = atomPlus
+			}
+			acc, err := eval(vm, answers[0], env)
 			if err != nil {
 				return Error(err)
 			}
-			answers = append(answers, c)
-			return Bool(false) // ask for more solutions
-		}, env).Force(ctx); err != nil {
-			return Error(err)
+			for _, a := range answers[1:] {
+				acc, err = eval(vm, op.Apply(acc, a), env)
+				if err != nil {
+					return Error(err)
+				}
+			}
+			r = acc
 		}
-		return Unify(vm, instances, List(answers...), k, env)
+		return Unify(vm, result, r, k, env)
 	})
 }
 
+// findAll returns renamed copies of template for all the solutions of goal.
+func findAll(ctx context.Context, vm *VM, template, goal Term, env *Env) ([]Term, error) {
+	var answers []Term
+	_, err := Call(vm, goal, func(env *Env) *Promise {
+		c, err := renamedCopy(template, nil, env)
+		if err != nil {
+			return Error(err)
+		}
+		answers = append(answers, c)
+		return Bool(false) // ask for more solutions
+	}, env).Force(ctx)
+	return answers, err
+}
+
 // Compare compares term1 and term2 and unifies order with <, =, or >.
 func Compare(vm *VM, order, term1, term2 Term, k Cont, env *Env) *Promise {
 	switch o := env.Resolve(order).(type) {
@@ -900,6 +1302,7 @@ func Compare(vm *VM, order, term1, term2 Term, k Cont, env *Env) *Promise {
 
 // Between succeeds when lower, upper, and value are all integers, and lower <= value <= upper.
 // If value is a variable, it is unified with successive integers from lower to upper.
+// upper can also be inf or infinite, in which case there's no upper bound.
 func Between(vm *VM, lower, upper, value Term, k Cont, env *Env) *Promise {
 	var low, high Integer
 
@@ -915,6 +1318,11 @@ func Between(vm *VM, lower, upper, value Term, k Cont, env *Env) *Promise {
 	switch upper := env.Resolve(upper).(type) {
 	case Integer:
 		high = upper
+	case Atom:
+		if upper != atomInf && upper != atomInfinite {
+			return Error(typeError(validTypeInteger, upper, env))
+		}
+		high = math.MaxInt64
 	case Variable:
 		return Error(InstantiationError(env))
 	default:
@@ -968,72 +1376,203 @@ func Sort(vm *VM, list, sorted Term, k Cont, env *Env) *Promise {
 	return Unify(vm, sorted, env.set(elems...), k, env)
 }
 
-// KeySort succeeds if sorted is a sorted list of pairs based on their keys.
-func KeySort(vm *VM, pairs, sorted Term, k Cont, env *Env) *Promise {
+// MSort succeeds if sorted is a sorted list of list in the standard order of terms without removing duplicates.
+// Equal elements keep their original order.
+func MSort(vm *VM, list, sorted Term, k Cont, env *Env) *Promise {
 	var elems []Term
-	iter := ListIterator{List: pairs, Env: env}
+	iter := ListIterator{List: list, Env: env}
 	for iter.Next() {
-		switch e := env.Resolve(iter.Current()).(type) {
-		case Variable:
-			return Error(InstantiationError(env))
-		case Compound:
-			if e.Functor() != atomMinus || e.Arity() != 2 {
-				return Error(typeError(validTypePair, e, env))
-			}
-			elems = append(elems, e)
-		default:
-			return Error(typeError(validTypePair, e, env))
-		}
+		elems = append(elems, env.Resolve(iter.Current()))
 	}
 	if err := iter.Err(); err != nil {
 		return Error(err)
 	}
 
-	switch s := env.Resolve(sorted).(type) {
-	case Variable:
-		break
-	default:
-		iter := ListIterator{List: s, Env: env}
-		for iter.Next() {
-			switch e := env.Resolve(iter.Current()).(type) {
-			case Variable:
-				continue
-			case Compound:
-				if e.Functor() != atomMinus || e.Arity() != 2 {
-					return Error(typeError(validTypePair, e, env))
-				}
-			default:
-				return Error(typeError(validTypePair, e, env))
-			}
-		}
-		if err := iter.Err(); err != nil {
-			return Error(err)
-		}
+	iter = ListIterator{List: sorted, Env: env, AllowPartial: true}
+	for iter.Next() {
+	}
+	if err := iter.Err(); err != nil {
+		return Error(err)
 	}
 
 	sort.SliceStable(elems, func(i, j int) bool {
-		return elems[i].(Compound).Arg(0).Compare(elems[j].(Compound).Arg(0), env) == -1
+		return elems[i].Compare(elems[j], env) == -1
 	})
 
 	return Unify(vm, sorted, List(elems...), k, env)
 }
 
-// Throw throws ball as an exception.
-func Throw(_ *VM, ball Term, _ Cont, env *Env) *Promise {
-	switch b := env.Resolve(ball).(type) {
+// Sort4 succeeds if sorted is a sorted list of list by the key-th arguments of the elements in order, which is one of
+// @<, @=<, @>, and @>=. If key is 0, the elements are compared as a whole. @< and @> remove duplicates while @=< and
+// @>= keep them. Elements with equal keys keep their original order.
+func Sort4(vm *VM, key, order, list, sorted Term, k Cont, env *Env) *Promise {
+	var n Integer
+	switch key := env.Resolve(key).(type) {
 	case Variable:
 		return Error(InstantiationError(env))
-	default:
+	case Integer:
+		if key < 0 {
+			return Error(domainError(validDomainNotLessThanZero, key, env))
+		}
+		n = key
+	default:
+		return Error(typeError(validTypeInteger, key, env))
+	}
+
+	var desc, dedup bool
+	switch o := env.Resolve(order).(type) {
+	case Variable:
+		return Error(InstantiationError(env))
+	case Atom:
+		switch o {
+		case atomAtLessThan:
+			dedup = true
+		case atomAtLessOrEqual:
+			break
+		case atomAtGreaterThan:
+			desc, dedup = true, true
+		case atomAtGreaterOrEqual:
+			desc = true
+		default:
+			return Error(domainError(validDomainOrder, o, env))
+		}
+	default:
+		return Error(typeError(validTypeAtom, o, env))
+	}
+
+	var elems, keys []Term
+	iter := ListIterator{List: list, Env: env}
+	for iter.Next() {
+		e := env.Resolve(iter.Current())
+		elems = append(elems, e)
+		if n == 0 {
+			keys = append(keys, e)
+			continue
+		}
+		switch c := e.(type) {
+		case Variable:
+			return Error(InstantiationError(env))
+		case Compound:
+			if Integer(c.Arity()) < n {
+				return Error(typeError(validTypeCompound, c, env))
+			}
+			keys = append(keys, c.Arg(int(n)-1))
+		default:
+			return Error(typeError(validTypeCompound, c, env))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return Error(err)
+	}
+
+	iter = ListIterator{List: sorted, Env: env, AllowPartial: true}
+	for iter.Next() {
+	}
+	if err := iter.Err(); err != nil {
+		return Error(err)
+	}
+
+	idx := make([]int, len(elems))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		o := keys[idx[i]].Compare(keys[idx[j]], env)
+		if desc {
+			return o == 1
+		}
+		return o == -1
+	})
+
+	ret := make([]Term, 0, len(elems))
+	for i, j := range idx {
+		if dedup && i > 0 && keys[idx[i-1]].Compare(keys[j], env) == 0 {
+			continue
+		}
+		ret = append(ret, elems[j])
+	}
+
+	return Unify(vm, sorted, List(ret...), k, env)
+}
+
+// KeySort succeeds if sorted is a sorted list of pairs based on their keys.
+func KeySort(vm *VM, pairs, sorted Term, k Cont, env *Env) *Promise {
+	var elems []Term
+	iter := ListIterator{List: pairs, Env: env}
+	for iter.Next() {
+		switch e := env.Resolve(iter.Current()).(type) {
+		case Variable:
+			return Error(InstantiationError(env))
+		case Compound:
+			if e.Functor() != atomMinus || e.Arity() != 2 {
+				return Error(typeError(validTypePair, e, env))
+			}
+			elems = append(elems, e)
+		default:
+			return Error(typeError(validTypePair, e, env))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return Error(err)
+	}
+
+	switch s := env.Resolve(sorted).(type) {
+	case Variable:
+		break
+	default:
+		iter := ListIterator{List: s, Env: env}
+		for iter.Next() {
+			switch e := env.Resolve(iter.Current()).(type) {
+			case Variable:
+				continue
+			case Compound:
+				if e.Functor() != atomMinus || e.Arity() != 2 {
+					return Error(typeError(validTypePair, e, env))
+				}
+			default:
+				return Error(typeError(validTypePair, e, env))
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return Error(err)
+		}
+	}
+
+	sort.SliceStable(elems, func(i, j int) bool {
+		return elems[i].(Compound).Arg(0).Compare(elems[j].(Compound).Arg(0), env) == -1
+	})
+
+	return Unify(vm, sorted, List(elems...), k, env)
+}
+
+// Throw throws ball as an exception.
+func Throw(_ *VM, ball Term, _ Cont, env *Env) *Promise {
+	switch b := env.Resolve(ball).(type) {
+	case Variable:
+		return Error(InstantiationError(env))
+	default:
 		return Error(NewException(b, env))
 	}
 }
 
 // Catch calls goal. If an exception is thrown and unifies with catcher, it calls recover.
+// Only exceptions are subject to catcher. Other errors including Go panics propagate as they are.
+// Like SWI-Prolog, it doesn't restore the current input/output changed by goal before the exception. Both recover and
+// the continuation see the streams as goal left them.
 func Catch(vm *VM, goal, catcher, recover Term, k Cont, env *Env) *Promise {
 	return catch(func(err error) *Promise {
-		e, ok := err.(Exception)
-		if !ok {
-			e = Exception{term: atomError.Apply(NewAtom("system_error"), NewAtom(err.Error()))}
+		var e Exception
+		switch err := err.(type) {
+		case Exception:
+			e = err
+		case *panicError:
+			if vm.Panic != nil && !err.reported {
+				err.reported = true
+				vm.Panic(err.value)
+			}
+			return nil
+		default:
+			return nil
 		}
 
 		env, ok := env.Unify(catcher, e.term)
@@ -1101,16 +1640,17 @@ func Retract(vm *VM, t Term, k Cont, env *Env) *Promise {
 		return Error(permissionError(operationModify, permissionTypeStaticProcedure, pi.Term(), env))
 	}
 
-	deleted := 0
 	ks := make([]func(context.Context) *Promise, len(u.clauses))
 	for i, c := range u.clauses {
-		i := i
+		c := c
 		raw := rulify(c.raw, env)
 		ks[i] = func(_ context.Context) *Promise {
 			return Unify(vm, t, raw, func(env *Env) *Promise {
-				j := i - deleted
-				u.clauses, u.clauses[len(u.clauses)-1] = append(u.clauses[:j], u.clauses[j+1:]...), clause{}
-				deleted++
+				// The continuation of a previous alternative may have added or removed clauses.
+				// So we look for the clause instead of relying on its original position.
+				if !u.remove(c) {
+					return Bool(false)
+				}
 				return k(env)
 			}, env)
 		}
@@ -1142,7 +1682,12 @@ func Abolish(vm *VM, pi Term, k Cont, env *Env) *Promise {
 					return Error(domainError(validDomainNotLessThanZero, arity, env))
 				}
 				key := procedureIndicator{name: name, arity: arity}
-				if u, ok := vm.procedures[key].(*userDefined); !ok || !u.dynamic {
+				p, ok := vm.procedures[key]
+				if !ok {
+					// Abolishing an undefined procedure is a no-op.
+					return k(env)
+				}
+				if u, ok := p.(*userDefined); !ok || !u.dynamic {
 					return Error(permissionError(operationModify, permissionTypeStaticProcedure, key.Term(), env))
 				}
 				delete(vm.procedures, key)
@@ -1159,9 +1704,13 @@ func Abolish(vm *VM, pi Term, k Cont, env *Env) *Promise {
 }
 
 // CurrentInput unifies stream with the current input stream.
+// If there's no current input stream, e.g. in the zero value VM, it raises an existence error of user_input.
 func CurrentInput(vm *VM, stream Term, k Cont, env *Env) *Promise {
 	switch env.Resolve(stream).(type) {
 	case Variable, *Stream:
+		if vm.input == nil {
+			return Error(existenceError(objectTypeStream, atomUserInput, env))
+		}
 		return Unify(vm, stream, vm.input, k, env)
 	default:
 		return Error(domainError(validDomainStream, stream, env))
@@ -1169,9 +1718,13 @@ func CurrentInput(vm *VM, stream Term, k Cont, env *Env) *Promise {
 }
 
 // CurrentOutput unifies stream with the current output stream.
+// If there's no current output stream, e.g. in the zero value VM, it raises an existence error of user_output.
 func CurrentOutput(vm *VM, stream Term, k Cont, env *Env) *Promise {
 	switch env.Resolve(stream).(type) {
 	case Variable, *Stream:
+		if vm.output == nil {
+			return Error(existenceError(objectTypeStream, atomUserOutput, env))
+		}
 		return Unify(vm, stream, vm.output, k, env)
 	default:
 		return Error(domainError(validDomainStream, stream, env))
@@ -1228,6 +1781,8 @@ func stream(vm *VM, streamOrAlias Term, env *Env) (*Stream, error) {
 var openFile = os.OpenFile
 
 // Open opens SourceSink in mode and unifies with stream.
+// user_input, user_output, user_error, and user refer to the standard streams which are already open. In that case,
+// stream unifies with the existing stream and options are ignored.
 func Open(vm *VM, sourceSink, mode, stream, options Term, k Cont, env *Env) *Promise {
 	var name string
 	switch s := env.Resolve(sourceSink).(type) {
@@ -1261,6 +1816,24 @@ func Open(vm *VM, sourceSink, mode, stream, options Term, k Cont, env *Env) *Pro
 		return Error(InstantiationError(env))
 	}
 
+	switch a := env.Resolve(sourceSink).(Atom); a {
+	case atomUser, atomUserInput, atomUserOutput, atomUserError:
+		if a == atomUser {
+			a = atomUserOutput
+			if streamMode == ioModeRead {
+				a = atomUserInput
+			}
+		}
+		s, ok := vm.streams.lookup(a)
+		if !ok {
+			return Error(existenceError(objectTypeSourceSink, sourceSink, env))
+		}
+		if (s.mode == ioModeRead) != (streamMode == ioModeRead) {
+			return Error(permissionError(operationOpen, permissionTypeSourceSink, sourceSink, env))
+		}
+		return Unify(vm, stream, s, k, env)
+	}
+
 	s := Stream{vm: vm, mode: streamMode}
 	switch f, err := openFile(name, int(s.mode), 0644); {
 	case err == nil:
@@ -1317,6 +1890,10 @@ func handleStreamOption(vm *VM, s *Stream, option Term, env *Env) error {
 			return handleStreamOptionReposition(vm, s, o, env)
 		case atomEOFAction:
 			return handleStreamOptionEOFAction(vm, s, o, env)
+		case atomAutoFlush:
+			return handleStreamOptionAutoFlush(vm, s, o, env)
+		case atomSync:
+			return handleStreamOptionSync(vm, s, o, env)
 		}
 	}
 	return domainError(validDomainStreamOption, option, env)
@@ -1392,7 +1969,41 @@ func handleStreamOptionEOFAction(_ *VM, s *Stream, o Compound, env *Env) error {
 	return domainError(validDomainStreamOption, o, env)
 }
 
-// Close closes a stream specified by streamOrAlias.
+func handleStreamOptionAutoFlush(_ *VM, s *Stream, o Compound, env *Env) error {
+	switch f := env.Resolve(o.Arg(0)).(type) {
+	case Variable:
+		return InstantiationError(env)
+	case Atom:
+		switch f {
+		case atomTrue:
+			s.autoFlush = true
+			return nil
+		case atomFalse:
+			s.autoFlush = false
+			return nil
+		}
+	}
+	return domainError(validDomainStreamOption, o, env)
+}
+
+func handleStreamOptionSync(_ *VM, s *Stream, o Compound, env *Env) error {
+	switch f := env.Resolve(o.Arg(0)).(type) {
+	case Variable:
+		return InstantiationError(env)
+	case Atom:
+		switch f {
+		case atomTrue:
+			s.sync = true
+			return nil
+		case atomFalse:
+			s.sync = false
+			return nil
+		}
+	}
+	return domainError(validDomainStreamOption, o, env)
+}
+
+// Close closes a stream specified by streamOrAlias. Closing user_input, user_output, or user_error has no effect.
 func Close(vm *VM, streamOrAlias, options Term, k Cont, env *Env) *Promise {
 	s, err := stream(vm, streamOrAlias, env)
 	if err != nil {
@@ -1434,6 +2045,11 @@ func Close(vm *VM, streamOrAlias, options Term, k Cont, env *Env) *Promise {
 		return Error(err)
 	}
 
+	switch s.alias {
+	case atomUserInput, atomUserOutput, atomUserError:
+		return k(env)
+	}
+
 	if err := s.Close(); err != nil && !force {
 		return Error(err)
 	}
@@ -1493,6 +2109,12 @@ func WriteTerm(vm *VM, streamOrAlias, t, options Term, k Cont, env *Env) *Promis
 		return Error(err)
 	}
 
+	if s.autoFlush {
+		if err := s.Flush(); err != nil {
+			return Error(err)
+		}
+	}
+
 	return k(env)
 }
 
@@ -1647,6 +2269,146 @@ func CharCode(vm *VM, char, code Term, k Cont, env *Env) *Promise {
 	}
 }
 
+// CharType succeeds iff char is a character of type typ.
+// typ is one of alnum, alpha, csym, csymf, ascii, white, cntrl, digit(Weight), xdigit(Weight), space, end_of_line,
+// graph, print, punct, period, quote, paren, lower, lower(Upper), upper, upper(Lower), to_lower(Lower), or to_upper(Upper).
+// Like SWI-Prolog, alpha includes digits and the underscore.
+func CharType(vm *VM, char, typ Term, k Cont, env *Env) *Promise {
+	switch c := env.Resolve(char).(type) {
+	case Variable:
+		return Error(InstantiationError(env))
+	case Atom:
+		if c > utf8.MaxRune {
+			return Error(typeError(validTypeCharacter, c, env))
+		}
+		return charType(vm, rune(c), func(r rune) Term {
+			return Atom(r)
+		}, typ, k, env)
+	default:
+		return Error(typeError(validTypeCharacter, c, env))
+	}
+}
+
+// CodeType succeeds iff code is a character code of type typ.
+// typ is the same as CharType except that the characters in typ, e.g. Lower in upper(Lower), are character codes.
+func CodeType(vm *VM, code, typ Term, k Cont, env *Env) *Promise {
+	switch c := env.Resolve(code).(type) {
+	case Variable:
+		return Error(InstantiationError(env))
+	case Integer:
+		r := rune(c)
+		if Integer(r) != c || !utf8.ValidRune(r) {
+			return Error(representationError(flagCharacterCode, env))
+		}
+		return charType(vm, r, func(r rune) Term {
+			return Integer(r)
+		}, typ, k, env)
+	default:
+		return Error(typeError(validTypeInteger, c, env))
+	}
+}
+
+// charType succeeds iff r is of type typ. The characters in typ, e.g. Lower in upper(Lower), are converted by conv.
+func charType(vm *VM, r rune, conv func(rune) Term, typ Term, k Cont, env *Env) *Promise {
+	var ok bool
+	switch t := env.Resolve(typ).(type) {
+	case Variable:
+		return Error(InstantiationError(env))
+	case Atom:
+		switch t {
+		case atomAlnum:
+			ok = unicode.IsLetter(r) || unicode.IsDigit(r)
+		case atomAlpha, atomCsym:
+			ok = unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+		case atomCsymf:
+			ok = unicode.IsLetter(r) || r == '_'
+		case atomASCII:
+			ok = r <= unicode.MaxASCII
+		case atomWhite:
+			ok = r == ' ' || r == '\t'
+		case atomCntrl:
+			ok = unicode.IsControl(r)
+		case atomDigit:
+			ok = unicode.IsDigit(r)
+		case atomSpace:
+			ok = unicode.IsSpace(r)
+		case atomEndOfLine:
+			ok = r == '\n' || r == '\r'
+		case atomGraph:
+			ok = unicode.IsGraphic(r) && !unicode.IsSpace(r)
+		case atomPrint:
+			ok = unicode.IsPrint(r)
+		case atomPunct:
+			ok = unicode.IsPunct(r) || unicode.IsSymbol(r)
+		case atomPeriod:
+			ok = r == '.' || r == '!' || r == '?'
+		case atomQuote:
+			ok = r == '\'' || r == '"' || r == '`'
+		case atomParen:
+			ok = r == '(' || r == ')'
+		case atomLower:
+			ok = unicode.IsLower(r)
+		case atomUpper:
+			ok = unicode.IsUpper(r)
+		default:
+			return Error(domainError(validDomainCharType, t, env))
+		}
+	case Compound:
+		if t.Arity() != 1 {
+			return Error(domainError(validDomainCharType, t, env))
+		}
+		var v Term
+		switch t.Functor() {
+		case atomDigit:
+			var w Integer
+			w, ok = digitWeight(r)
+			v = w
+		case atomXdigit:
+			var w Integer
+			w, ok = digitWeight(r)
+			switch {
+			case 'a' <= r && r <= 'f':
+				w, ok = Integer(r-'a'+10), true
+			case 'A' <= r && r <= 'F':
+				w, ok = Integer(r-'A'+10), true
+			}
+			v = w
+		case atomLower:
+			ok, v = unicode.IsLower(r), conv(unicode.ToUpper(r))
+		case atomUpper:
+			ok, v = unicode.IsUpper(r), conv(unicode.ToLower(r))
+		case atomToLower:
+			ok, v = true, conv(unicode.ToLower(r))
+		case atomToUpper:
+			ok, v = true, conv(unicode.ToUpper(r))
+		default:
+			return Error(domainError(validDomainCharType, t, env))
+		}
+		if ok {
+			return Unify(vm, t.Arg(0), v, k, env)
+		}
+	default:
+		return Error(domainError(validDomainCharType, t, env))
+	}
+	if !ok {
+		return Bool(false)
+	}
+	return k(env)
+}
+
+// digitWeight returns the weight of the decimal digit r.
+// Unicode decimal digits come in contiguous runs starting from zero, so the weight is the offset in the run modulo 10.
+func digitWeight(r rune) (Integer, bool) {
+	if !unicode.IsDigit(r) {
+		return 0, false
+	}
+	zero := r
+	for unicode.IsDigit(zero - 1) {
+		zero--
+	}
+	return Integer(r-zero) % 10, true
+}
+
 // PutByte outputs an integer byte to a stream represented by streamOrAlias.
 func PutByte(vm *VM, streamOrAlias, byt Term, k Cont, env *Env) *Promise {
 	s, err := stream(vm, streamOrAlias, env)
@@ -1709,12 +2471,66 @@ func PutChar(vm *VM, streamOrAlias, char Term, k Cont, env *Env) *Promise {
 	}
 }
 
+// Tab outputs n spaces to the stream represented by streamOrAlias.
+// n is evaluated as an arithmetic expression. If n is not positive, it outputs nothing.
+func Tab(vm *VM, streamOrAlias, n Term, k Cont, env *Env) *Promise {
+	s, err := stream(vm, streamOrAlias, env)
+	if err != nil {
+		return Error(err)
+	}
+
+	v, err := eval(vm, n, env)
+	if err != nil {
+		return Error(err)
+	}
+	i, ok := v.(Integer)
+	if !ok {
+		return Error(typeError(validTypeInteger, v, env))
+	}
+
+	w, err := s.textWriter()
+	switch {
+	case errors.Is(err, errWrongIOMode):
+		return Error(permissionError(operationOutput, permissionTypeStream, streamOrAlias, env))
+	case errors.Is(err, errWrongStreamType):
+		return Error(permissionError(operationOutput, permissionTypeBinaryStream, streamOrAlias, env))
+	case err != nil:
+		return Error(err)
+	}
+
+	cw := newColumnWriter(w, s.column)
+	if err := cw.columnStop(cw.Column() + int(i)); err != nil {
+		return Error(err)
+	}
+	if s.autoFlush {
+		if err := s.Flush(); err != nil {
+			return Error(err)
+		}
+	}
+
+	return k(env)
+}
+
 type readTermOptions struct {
 	singletons    Term
 	variables     Term
 	variableNames Term
+	syntaxErrors  syntaxErrors
+	doubleQuotes  *doubleQuotes // Overrides the double_quotes flag unless nil.
 }
 
+// syntaxErrors is what read_term/3 does on a syntax error.
+type syntaxErrors uint8
+
+const (
+	// syntaxErrorsError means a syntax error is raised.
+	syntaxErrorsError syntaxErrors = iota
+	// syntaxErrorsFail means read_term/3 fails after skipping the malformed term.
+	syntaxErrorsFail
+	// syntaxErrorsQuiet means the malformed term is skipped and the next term is read instead.
+	syntaxErrorsQuiet
+)
+
 // ReadTerm reads from the stream represented by streamOrAlias and unifies with stream.
 func ReadTerm(vm *VM, streamOrAlias, out, options Term, k Cont, env *Env) *Promise {
 	s, err := stream(vm, streamOrAlias, env)
@@ -1722,32 +2538,27 @@ func ReadTerm(vm *VM, streamOrAlias, out, options Term, k Cont, env *Env) *Promi
 		return Error(err)
 	}
 
-	opts := readTermOptions{
-		singletons:    NewVariable(),
-		variables:     NewVariable(),
-		variableNames: NewVariable(),
-	}
-	iter := ListIterator{List: options, Env: env}
-	for iter.Next() {
-		if err := readTermOption(&opts, iter.Current(), env); err != nil {
-			return Error(err)
-		}
-	}
-	if err := iter.Err(); err != nil {
+	opts, err := newReadTermOptions(options, env)
+	if err != nil {
 		return Error(err)
 	}
 
-	p := NewParser(vm, s)
+	p := opts.parser(vm, s)
 	defer func() {
 		_ = s.UnreadRune()
 	}()
 
 	t, err := p.Term()
+	for opts.syntaxErrors == syntaxErrorsQuiet && isSyntaxError(err) {
+		if err = p.resync(err); err == nil {
+			t, err = p.Term()
+		}
+	}
 	switch err {
 	case nil:
 		break
 	case io.EOF:
-		return Unify(vm, out, atomEndOfFile, k, env)
+		t = atomEndOfFile
 	case errWrongIOMode:
 		return Error(permissionError(operationInput, permissionTypeStream, streamOrAlias, env))
 	case errWrongStreamType:
@@ -1755,16 +2566,84 @@ func ReadTerm(vm *VM, streamOrAlias, out, options Term, k Cont, env *Env) *Promi
 	case errPastEndOfStream:
 		return Error(permissionError(operationInput, permissionTypePastEndOfStream, streamOrAlias, env))
 	default:
+		if opts.syntaxErrors == syntaxErrorsFail {
+			if err := p.resync(err); err != nil && err != io.EOF {
+				return Error(err)
+			}
+			return Bool(false)
+		}
 		return Error(syntaxError(err, env))
 	}
 
-	var singletons, variables, variableNames []Term
-	for _, v := range p.Vars {
-		if v.Count == 1 {
-			singletons = append(singletons, v.Variable)
+	// The read may have blocked until the query was cancelled, e.g. on user_input. Delay lets Force notice it before
+	// the continuation.
+	return Delay(func(context.Context) *Promise {
+		if err == io.EOF {
+			return Unify(vm, out, t, k, env)
 		}
-		variables = append(variables, v.Variable)
-		variableNames = append(variableNames, atomEqual.Apply(v.Name, v.Variable))
+		return opts.unify(vm, out, t, p.Vars, k, env)
+	})
+}
+
+// ReadTermFromAtom parses atom as a term with options and unifies it with term.
+// The options are the same as ReadTerm. atom doesn't have to end with a full stop.
+// If atom has no term, e.g. the empty atom, term unifies with end_of_file.
+func ReadTermFromAtom(vm *VM, atom, term, options Term, k Cont, env *Env) *Promise {
+	var a Atom
+	switch t := env.Resolve(atom).(type) {
+	case Variable:
+		return Error(InstantiationError(env))
+	case Atom:
+		a = t
+	default:
+		return Error(typeError(validTypeAtom, atom, env))
+	}
+
+	opts, err := newReadTermOptions(options, env)
+	if err != nil {
+		return Error(err)
+	}
+
+	if p := NewParser(vm, strings.NewReader(a.String())); !p.More() {
+		return Unify(vm, term, atomEndOfFile, k, env)
+	}
+
+	p := opts.parser(vm, strings.NewReader(a.String()+" ."))
+	t, err := p.Term()
+	if err != nil {
+		if opts.syntaxErrors != syntaxErrorsError {
+			return Bool(false)
+		}
+		return Error(syntaxError(err, env))
+	}
+
+	return opts.unify(vm, term, t, p.Vars, k, env)
+}
+
+func newReadTermOptions(options Term, env *Env) (readTermOptions, error) {
+	opts := readTermOptions{
+		singletons:    NewVariable(),
+		variables:     NewVariable(),
+		variableNames: NewVariable(),
+	}
+	iter := ListIterator{List: options, Env: env}
+	for iter.Next() {
+		if err := readTermOption(&opts, iter.Current(), env); err != nil {
+			return opts, err
+		}
+	}
+	return opts, iter.Err()
+}
+
+// unify unifies out with t, and the singletons, variables, and variable_names options with vars.
+func (opts *readTermOptions) unify(vm *VM, out, t Term, vars []ParsedVariable, k Cont, env *Env) *Promise {
+	var singletons, variables, variableNames []Term
+	for _, v := range vars {
+		if v.Count == 1 {
+			singletons = append(singletons, v.Variable)
+		}
+		variables = append(variables, v.Variable)
+		variableNames = append(variableNames, atomEqual.Apply(v.Name, v.Variable))
 	}
 
 	return Unify(vm, tuple(
@@ -1780,6 +2659,25 @@ func ReadTerm(vm *VM, streamOrAlias, out, options Term, k Cont, env *Env) *Promi
 	), k, env)
 }
 
+// parser returns a parser of r which reads double-quoted lists as the double_quotes option or flag specifies.
+func (opts *readTermOptions) parser(vm *VM, r io.RuneReader) *Parser {
+	p := NewParser(vm, r)
+	if opts.doubleQuotes != nil {
+		p.doubleQuotes = *opts.doubleQuotes
+	}
+	return p
+}
+
+// isSyntaxError reports whether err from Parser.Term is caused by a malformed term rather than the stream.
+func isSyntaxError(err error) bool {
+	switch err {
+	case nil, io.EOF, errWrongIOMode, errWrongStreamType, errPastEndOfStream:
+		return false
+	default:
+		return true
+	}
+}
+
 func readTermOption(opts *readTermOptions, option Term, env *Env) error {
 	switch option := env.Resolve(option).(type) {
 	case Variable:
@@ -1797,6 +2695,36 @@ func readTermOption(opts *readTermOptions, option Term, env *Env) error {
 			opts.variables = v
 		case atomVariableNames:
 			opts.variableNames = v
+		case atomSyntaxErrors:
+			switch v {
+			case atomError:
+				opts.syntaxErrors = syntaxErrorsError
+			case atomFail:
+				opts.syntaxErrors = syntaxErrorsFail
+			case atomQuiet:
+				opts.syntaxErrors = syntaxErrorsQuiet
+			default:
+				if _, ok := v.(Variable); ok {
+					return InstantiationError(env)
+				}
+				return domainError(validDomainReadOption, option, env)
+			}
+		case atomDoubleQuotes:
+			var d doubleQuotes
+			switch v {
+			case atomCodes:
+				d = doubleQuotesCodes
+			case atomChars:
+				d = doubleQuotesChars
+			case atomAtom:
+				d = doubleQuotesAtom
+			default:
+				if _, ok := v.(Variable); ok {
+					return InstantiationError(env)
+				}
+				return domainError(validDomainReadOption, option, env)
+			}
+			opts.doubleQuotes = &d
 		default:
 			return domainError(validDomainReadOption, option, env)
 		}
@@ -1817,7 +2745,7 @@ func GetByte(vm *VM, streamOrAlias, inByte Term, k Cont, env *Env) *Promise {
 	case Variable:
 		break
 	case Integer:
-		if b < 0 || b > 255 {
+		if b < -1 || b > 255 { // -1 stands for the end of the stream.
 			return Error(typeError(validTypeInByte, inByte, env))
 		}
 	default:
@@ -1879,6 +2807,8 @@ func GetChar(vm *VM, streamOrAlias, char Term, k Cont, env *Env) *Promise {
 }
 
 // PeekByte peeks a byte from the stream represented by streamOrAlias and unifies it with inByte.
+// Peeking at the end of stream doesn't move the stream past the end of stream. So eof_action is never triggered by
+// peeking but by the following read.
 func PeekByte(vm *VM, streamOrAlias, inByte Term, k Cont, env *Env) *Promise {
 	s, err := stream(vm, streamOrAlias, env)
 	if err != nil {
@@ -1889,7 +2819,7 @@ func PeekByte(vm *VM, streamOrAlias, inByte Term, k Cont, env *Env) *Promise {
 	case Variable:
 		break
 	case Integer:
-		if b < 0 || b > 255 {
+		if b < -1 || b > 255 { // -1 stands for the end of the stream.
 			return Error(typeError(validTypeInByte, inByte, env))
 		}
 	default:
@@ -1897,13 +2827,15 @@ func PeekByte(vm *VM, streamOrAlias, inByte Term, k Cont, env *Env) *Promise {
 	}
 
 	b, err := s.ReadByte()
-	defer func() {
-		_ = s.UnreadByte()
-	}()
 	switch err {
 	case nil:
+		// Unread before the continuation, which might read from the stream.
+		if err := s.UnreadByte(); err != nil {
+			return Error(err)
+		}
 		return Unify(vm, inByte, Integer(b), k, env)
 	case io.EOF:
+		s.endOfStream = endOfStreamAt
 		return Unify(vm, inByte, Integer(-1), k, env)
 	case errWrongIOMode:
 		return Error(permissionError(operationInput, permissionTypeStream, streamOrAlias, env))
@@ -1917,6 +2849,8 @@ func PeekByte(vm *VM, streamOrAlias, inByte Term, k Cont, env *Env) *Promise {
 }
 
 // PeekChar peeks a rune from the stream represented by streamOrAlias and unifies it with char.
+// Peeking at the end of stream doesn't move the stream past the end of stream. So eof_action is never triggered by
+// peeking but by the following read.
 func PeekChar(vm *VM, streamOrAlias, char Term, k Cont, env *Env) *Promise {
 	s, err := stream(vm, streamOrAlias, env)
 	if err != nil {
@@ -1935,17 +2869,19 @@ func PeekChar(vm *VM, streamOrAlias, char Term, k Cont, env *Env) *Promise {
 	}
 
 	r, _, err := s.ReadRune()
-	defer func() {
-		_ = s.UnreadRune()
-	}()
 	switch err {
 	case nil:
+		// Unread before the continuation, which might read from the stream.
+		if err := s.UnreadRune(); err != nil {
+			return Error(err)
+		}
 		if r == unicode.ReplacementChar {
 			return Error(representationError(flagCharacter, env))
 		}
 
 		return Unify(vm, char, Atom(r), k, env)
 	case io.EOF:
+		s.endOfStream = endOfStreamAt
 		return Unify(vm, char, atomEndOfFile, k, env)
 	case errWrongIOMode:
 		return Error(permissionError(operationInput, permissionTypeStream, streamOrAlias, env))
@@ -1961,11 +2897,14 @@ func PeekChar(vm *VM, streamOrAlias, char Term, k Cont, env *Env) *Promise {
 var osExit = os.Exit
 
 // Halt exits the process with exit code of n.
-func Halt(_ *VM, n Term, k Cont, env *Env) *Promise {
+func Halt(vm *VM, n Term, k Cont, env *Env) *Promise {
 	switch code := env.Resolve(n).(type) {
 	case Variable:
 		return Error(InstantiationError(env))
 	case Integer:
+		for i := len(vm.BeforeHalt) - 1; i >= 0; i-- {
+			vm.BeforeHalt[i](int(code))
+		}
 		osExit(int(code))
 		return k(env)
 	default:
@@ -2119,15 +3058,33 @@ func SubAtom(vm *VM, atom, before, length, after, subAtom Term, k Cont, env *Env
 			return Error(err)
 		}
 
-		switch env.Resolve(subAtom).(type) {
-		case Variable, Atom:
+		var sub []rune
+		switch s := env.Resolve(subAtom).(type) {
+		case Variable:
 			break
+		case Atom:
+			sub = []rune(s.String())
 		default:
 			return Error(typeError(validTypeAtom, subAtom, env))
 		}
 
 		pattern := tuple(before, length, after, subAtom)
 		var ks []func(context.Context) *Promise
+
+		// If subAtom is known, look for its occurrences instead of trying every sub atom.
+		if sub != nil {
+			for i := 0; i+len(sub) <= len(rs); i++ {
+				if !runesHasPrefix(rs[i:], sub) {
+					continue
+				}
+				before, length, after := Integer(i), Integer(len(sub)), Integer(len(rs)-i-len(sub))
+				ks = append(ks, func(context.Context) *Promise {
+					return Unify(vm, pattern, tuple(before, length, after, subAtom), k, env)
+				})
+			}
+			return Delay(ks...)
+		}
+
 		for i := 0; i <= len(rs); i++ {
 			for j := i; j <= len(rs); j++ {
 				before, length, after, subAtom := Integer(i), Integer(j-i), Integer(len(rs)-j), NewAtom(string(rs[i:j]))
@@ -2142,6 +3099,18 @@ func SubAtom(vm *VM, atom, before, length, after, subAtom Term, k Cont, env *Env
 	}
 }
 
+func runesHasPrefix(s, prefix []rune) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i, r := range prefix {
+		if s[i] != r {
+			return false
+		}
+	}
+	return true
+}
+
 func checkPositiveInteger(n Term, env *Env) error {
 	switch b := env.Resolve(n).(type) {
 	case Variable:
@@ -2425,6 +3394,129 @@ func numberCodesWrite(vm *VM, num, codes Term, k Cont, env *Env) *Promise {
 	return Unify(vm, codes, List(cs...), k, env)
 }
 
+// WriteToCodes succeeds iff codes is a list of the character codes which represent the output of write/1 for term.
+func WriteToCodes(vm *VM, term, codes Term, k Cont, env *Env) *Promise {
+	var sb strings.Builder
+	opts := WriteOptions{
+		ops:        vm.operators,
+		priority:   1200,
+		numberVars: true,
+	}
+	if err := env.Resolve(term).WriteTerm(&sb, &opts, env); err != nil {
+		return Error(err)
+	}
+	return Unify(vm, codes, CodeList(sb.String()), k, env)
+}
+
+// TermString succeeds iff str is a list of the character codes which represent term in the quoted form.
+// If str is an atom, a list of character codes, or a list of characters, it's parsed as a term and unified with term.
+// Otherwise, term is written in the quoted form and the list of character codes is unified with str.
+func TermString(vm *VM, term, str Term, k Cont, env *Env) *Promise {
+	if _, ok := env.Resolve(str).(Variable); ok {
+		var sb strings.Builder
+		opts := WriteOptions{
+			ops:        vm.operators,
+			priority:   1200,
+			quoted:     true,
+			numberVars: true,
+		}
+		if err := env.Resolve(term).WriteTerm(&sb, &opts, env); err != nil {
+			return Error(err)
+		}
+		return Unify(vm, str, CodeList(sb.String()), k, env)
+	}
+
+	text, err := textOf(str, env)
+	if err != nil {
+		return Error(err)
+	}
+
+	t, _, err := parseText(vm, text, env)
+	if err != nil {
+		return Error(err)
+	}
+	return Unify(vm, term, t, k, env)
+}
+
+// parseText parses text as a term. text doesn't have to end with a full stop but nothing can follow the term.
+func parseText(vm *VM, text string, env *Env) (Term, []ParsedVariable, error) {
+	p := NewParser(vm, strings.NewReader(text+" ."))
+	t, err := p.Term()
+	if err != nil {
+		return nil, nil, syntaxError(err, env)
+	}
+
+	// If text ends with a full stop, the one we added follows.
+	tok, err := p.next()
+	if err == nil && tok.kind == tokenEnd {
+		tok, err = p.next()
+	}
+	switch err {
+	case nil:
+		return nil, nil, syntaxError(unexpectedTokenError{actual: tok}, env)
+	case io.EOF:
+		return t, p.Vars, nil
+	default:
+		return nil, nil, syntaxError(err, env)
+	}
+}
+
+// TermToAtom succeeds iff atom is the quoted text of term.
+// If atom is a variable, term is written. Otherwise, atom is parsed with the operators currently defined.
+func TermToAtom(vm *VM, term, atom Term, k Cont, env *Env) *Promise {
+	var a Atom
+	switch t := env.Resolve(atom).(type) {
+	case Variable:
+		var sb strings.Builder
+		opts := WriteOptions{
+			ops:        vm.operators,
+			priority:   1200,
+			quoted:     true,
+			numberVars: true,
+		}
+		if err := env.Resolve(term).WriteTerm(&sb, &opts, env); err != nil {
+			return Error(err)
+		}
+		return Unify(vm, atom, NewAtom(sb.String()), k, env)
+	case Atom:
+		a = t
+	default:
+		return Error(typeError(validTypeAtom, atom, env))
+	}
+
+	t, _, err := parseText(vm, a.String(), env)
+	if err != nil {
+		return Error(err)
+	}
+	return Unify(vm, term, t, k, env)
+}
+
+// AtomToTerm parses atom as a term and unifies it with term. bindings is unified with a list of Name = Variable pairs
+// for the variables in the term.
+func AtomToTerm(vm *VM, atom, term, bindings Term, k Cont, env *Env) *Promise {
+	var a Atom
+	switch t := env.Resolve(atom).(type) {
+	case Variable:
+		return Error(InstantiationError(env))
+	case Atom:
+		a = t
+	default:
+		return Error(typeError(validTypeAtom, atom, env))
+	}
+
+	t, vars, err := parseText(vm, a.String(), env)
+	if err != nil {
+		return Error(err)
+	}
+
+	variableNames := make([]Term, len(vars))
+	for i, v := range vars {
+		variableNames[i] = atomEqual.Apply(v.Name, v.Variable)
+	}
+
+	return Unify(vm, tuple(term, bindings), tuple(t, List(variableNames...)), k, env)
+}
+
 // StreamProperty succeeds iff the stream represented by stream has the stream property.
 func StreamProperty(vm *VM, stream, property Term, k Cont, env *Env) *Promise {
 	streams := make([]*Stream, 0, len(vm.streams.elems))
@@ -2443,10 +3535,16 @@ func StreamProperty(vm *VM, stream, property Term, k Cont, env *Env) *Promise {
 		return Error(domainError(validDomainStreamProperty, property, env))
 	}
 
+	// If property is bound, we only look into the properties of the same name and arity.
+	var want procedureIndicator
+	if _, ok := env.Resolve(property).(Variable); !ok {
+		want, _, _ = piArg(property, env)
+	}
+
 	var ks []func(context.Context) *Promise
 	for _, s := range streams {
 		s := s
-		for _, p := range s.properties() {
+		for _, p := range s.properties(want) {
 			p := p
 			ks = append(ks, func(context.Context) *Promise {
 				return Unify(vm, atomEmpty.Apply(stream, property), atomEmpty.Apply(s, p), k, env)
@@ -2468,7 +3566,7 @@ func isStreamProperty(property Term, env *Env) bool {
 		}
 		arg := p.Arg(0)
 		switch p.Functor() {
-		case atomFileName, atomMode, atomAlias, atomEndOfStream, atomEOFAction, atomReposition:
+		case atomFileName, atomMode, atomAlias, atomEndOfStream, atomEOFAction, atomReposition, atomAutoFlush, atomSync:
 			return isAtom(arg, env)
 		case atomPosition:
 			return isInteger(arg, env)
@@ -2626,6 +3724,8 @@ func SetPrologFlag(vm *VM, flag, value Term, k Cont, env *Env) *Promise {
 			modify = modifyUnknown
 		case atomDoubleQuotes:
 			modify = modifyDoubleQuotes
+		case atomIntOverflow:
+			modify = modifyIntOverflow
 		default:
 			return Error(domainError(validDomainPrologFlag, f, env))
 		}
@@ -2698,6 +3798,18 @@ func modifyDoubleQuotes(vm *VM, value Atom) error {
 	return nil
 }
 
+func modifyIntOverflow(vm *VM, value Atom) error {
+	switch value {
+	case atomError:
+		vm.wrapIntOverflow = false
+	case atomWrap:
+		vm.wrapIntOverflow = true
+	default:
+		return domainError(validDomainFlagValue, atomPlus.Apply(atomIntOverflow, value), nil)
+	}
+	return nil
+}
+
 // CurrentPrologFlag succeeds iff flag is set to value.
 func CurrentPrologFlag(vm *VM, flag, value Term, k Cont, env *Env) *Promise {
 	switch f := env.Resolve(flag).(type) {
@@ -2705,7 +3817,7 @@ func CurrentPrologFlag(vm *VM, flag, value Term, k Cont, env *Env) *Promise {
 		break
 	case Atom:
 		switch f {
-		case atomBounded, atomMaxInteger, atomMinInteger, atomIntegerRoundingFunction, atomCharConversion, atomDebug, atomMaxArity, atomUnknown, atomDoubleQuotes:
+		case atomBounded, atomMaxInteger, atomMinInteger, atomIntegerRoundingFunction, atomCharConversion, atomDebug, atomMaxArity, atomUnknown, atomDoubleQuotes, atomIntOverflow:
 			break
 		default:
 			return Error(domainError(validDomainPrologFlag, f, env))
@@ -2714,18 +3826,8 @@ func CurrentPrologFlag(vm *VM, flag, value Term, k Cont, env *Env) *Promise {
 		return Error(typeError(validTypeAtom, f, env))
 	}
 
-	pattern := tuple(flag, value)
-	flags := []Term{
-		tuple(atomBounded, atomTrue),
-		tuple(atomMaxInteger, maxInt),
-		tuple(atomMinInteger, minInt),
-		tuple(atomIntegerRoundingFunction, atomTowardZero),
-		tuple(atomCharConversion, onOff(vm.charConvEnabled)),
-		tuple(atomDebug, onOff(vm.debug)),
-		tuple(atomMaxArity, atomUnbounded),
-		tuple(atomUnknown, NewAtom(vm.unknown.String())),
-		tuple(atomDoubleQuotes, NewAtom(vm.doubleQuotes.String())),
-	}
+	pattern := pair(flag, value)
+	flags := prologFlags(vm)
 	ks := make([]func(context.Context) *Promise, len(flags))
 	for i := range flags {
 		f := flags[i]
@@ -2736,6 +3838,41 @@ func CurrentPrologFlag(vm *VM, flag, value Term, k Cont, env *Env) *Promise {
 	return Delay(ks...)
 }
 
+// CurrentFlagValues unifies values with a list of Flag-Value pairs of all the Prolog flags.
+func CurrentFlagValues(vm *VM, values Term, k Cont, env *Env) *Promise {
+	return Unify(vm, values, List(prologFlags(vm)...), k, env)
+}
+
+// prologFlags returns Flag-Value pairs of all the Prolog flags.
+func prologFlags(vm *VM) []Term {
+	return []Term{
+		pair(atomBounded, atomTrue),
+		pair(atomMaxInteger, maxInt),
+		pair(atomMinInteger, minInt),
+		pair(atomIntegerRoundingFunction, atomTowardZero),
+		pair(atomCharConversion, onOff(vm.charConvEnabled)),
+		pair(atomDebug, onOff(vm.debug)),
+		pair(atomMaxArity, maxArity(vm)),
+		pair(atomUnknown, NewAtom(vm.unknown.String())),
+		pair(atomDoubleQuotes, NewAtom(vm.doubleQuotes.String())),
+		pair(atomIntOverflow, intOverflow(vm)),
+	}
+}
+
+func intOverflow(vm *VM) Term {
+	if vm.wrapIntOverflow {
+		return atomWrap
+	}
+	return atomError
+}
+
+func maxArity(vm *VM) Term {
+	if vm.MaxArity > 0 {
+		return Integer(vm.MaxArity)
+	}
+	return atomUnbounded
+}
+
 func onOff(b bool) Atom {
 	if b {
 		return atomOn
@@ -2789,18 +3926,8 @@ func Nth1(vm *VM, n, list, elem Term, k Cont, env *Env) *Promise {
 func nth(vm *VM, base Integer, n, list, elem Term, k Cont, env *Env) *Promise {
 	switch n := env.Resolve(n).(type) {
 	case Variable:
-		var ks []func(context.Context) *Promise
 		iter := ListIterator{List: list, Env: env}
-		for i := base; iter.Next(); i++ {
-			i, e := i, iter.Current()
-			ks = append(ks, func(context.Context) *Promise {
-				return Unify(vm, tuple(n, elem), tuple(i, e), k, env)
-			})
-		}
-		if err := iter.Err(); err != nil {
-			return Error(err)
-		}
-		return Delay(ks...)
+		return nthEnum(vm, base, n, &iter, elem, k, env)
 	case Integer:
 		if n < base {
 			return Bool(false)
@@ -2820,22 +3947,35 @@ func nth(vm *VM, base Integer, n, list, elem Term, k Cont, env *Env) *Promise {
 	}
 }
 
+// nthEnum unifies n and elem with the i-th element and the following ones on backtracking.
+// If the list turns out to be partial or cyclic, it raises an error after the elements so far.
+func nthEnum(vm *VM, i Integer, n Variable, iter *ListIterator, elem Term, k Cont, env *Env) *Promise {
+	if !iter.Next() {
+		if err := iter.Err(); err != nil {
+			return Error(err)
+		}
+		return Bool(false)
+	}
+	e := iter.Current()
+	return Delay(func(context.Context) *Promise {
+		return Unify(vm, tuple(n, elem), tuple(i, e), k, env)
+	}, func(context.Context) *Promise {
+		return nthEnum(vm, i+1, n, iter, elem, k, env)
+	})
+}
+
 // Succ succeeds if s is the successor of non-negative integer x.
 func Succ(vm *VM, x, s Term, k Cont, env *Env) *Promise {
-	switch x := x.(type) {
+	switch x := env.Resolve(x).(type) {
 	case Variable:
-		switch s := s.(type) {
+		switch s := env.Resolve(s).(type) {
 		case Variable:
 			return Error(InstantiationError(env))
 		case Integer:
-			switch {
-			case s < Integer(0):
-				return Error(domainError(validDomainNotLessThanZero, s, env))
-			case s == Integer(0):
+			if s <= Integer(0) { // No non-negative predecessor.
 				return Bool(false)
-			default:
-				return Unify(vm, x, s-Integer(1), k, env)
 			}
+			return Unify(vm, x, s-Integer(1), k, env)
 		default:
 			return Error(typeError(validTypeInteger, s, env))
 		}
@@ -2853,13 +3993,10 @@ func Succ(vm *VM, x, s Term, k Cont, env *Env) *Promise {
 			return Error(err)
 		}
 
-		switch s := s.(type) {
+		switch s := env.Resolve(s).(type) {
 		case Variable:
 			return Unify(vm, s, r, k, env)
 		case Integer:
-			if s < Integer(0) {
-				return Error(domainError(validDomainNotLessThanZero, s, env))
-			}
 			return Unify(vm, s, r, k, env)
 		default:
 			return Error(typeError(validTypeInteger, s, env))
@@ -2869,6 +4006,49 @@ func Succ(vm *VM, x, s Term, k Cont, env *Env) *Promise {
 	}
 }
 
+// Plus succeeds if z is the sum of integers x and y. At least two of them must be instantiated.
+func Plus(vm *VM, x, y, z Term, k Cont, env *Env) *Promise {
+	var ns [3]Integer
+	var bound [3]bool
+	for i, t := range []Term{x, y, z} {
+		switch t := env.Resolve(t).(type) {
+		case Variable:
+			break
+		case Integer:
+			ns[i], bound[i] = t, true
+		default:
+			return Error(typeError(validTypeInteger, t, env))
+		}
+	}
+
+	var (
+		r   Integer
+		v   Term
+		err error
+	)
+	switch {
+	case bound[0] && bound[1]:
+		r, err = addI(ns[0], ns[1])
+		v = z
+	case bound[0] && bound[2]:
+		r, err = subI(ns[2], ns[0])
+		v = y
+	case bound[1] && bound[2]:
+		r, err = subI(ns[2], ns[1])
+		v = x
+	default:
+		return Error(InstantiationError(env))
+	}
+	if err != nil {
+		var ev exceptionalValue
+		if errors.As(err, &ev) {
+			return Error(evaluationError(ev, env))
+		}
+		return Error(err)
+	}
+	return Unify(vm, v, r, k, env)
+}
+
 // Length succeeds iff list is a list of length.
 func Length(vm *VM, list, length Term, k Cont, env *Env) *Promise {
 	// https://github.com/mthom/scryer-prolog/issues/1325#issue-1160713156
@@ -3011,3 +4191,449 @@ func appendLists(vm *VM, xs, ys, zs Term, k Cont, env *Env) *Promise {
 		}, env)
 	})
 }
+
+// PrintMessage prints message of kind to user_error one line at a time.
+// The lines are rendered by VM.MessageHook if it's set and returns true, or by the default rendering otherwise.
+// If message_hook(Message, Kind, Lines) succeeds, where Lines is a list of the lines as atoms, nothing is printed.
+// Messages of kind silent are never printed.
+func PrintMessage(vm *VM, kind, message Term, k Cont, env *Env) *Promise {
+	var (
+		kd     Atom
+		prefix string
+	)
+	switch a := env.Resolve(kind).(type) {
+	case Variable:
+		return Error(InstantiationError(env))
+	case Atom:
+		kd = a
+		switch a {
+		case atomSilent:
+			return k(env)
+		case atomError:
+			prefix = "Error: "
+		case atomWarning:
+			prefix = "Warning: "
+		}
+	default:
+		return Error(typeError(validTypeAtom, kind, env))
+	}
+
+	lines, err := messageLines(vm, kd, message, env)
+	if err != nil {
+		return Error(err)
+	}
+
+	return Delay(func(ctx context.Context) *Promise {
+		if _, ok := vm.procedures[procedureIndicator{name: atomMessageHook, arity: 3}]; ok {
+			ls := make([]Term, len(lines))
+			for i, l := range lines {
+				ls[i] = NewAtom(l)
+			}
+			ok, err := Call(vm, atomMessageHook.Apply(message, kind, List(ls...)), Success, env).Force(ctx)
+			if err != nil {
+				return Error(err)
+			}
+			if ok {
+				return k(env)
+			}
+		}
+
+		s, ok := vm.streams.lookup(atomUserError)
+		if !ok {
+			return k(env)
+		}
+		w, err := s.textWriter()
+		if err != nil {
+			return Error(err)
+		}
+		for _, l := range lines {
+			if _, err := w.Write([]byte(prefix + l + "\n")); err != nil {
+				return Error(err)
+			}
+		}
+		if err := s.Flush(); err != nil {
+			return Error(err)
+		}
+		return k(env)
+	})
+}
+
+// messageLines renders message of kind as lines of plain text.
+func messageLines(vm *VM, kind Atom, message Term, env *Env) ([]string, error) {
+	if vm.MessageHook == nil {
+		return strings.Split(messageText(vm, message, env), "\n"), nil
+	}
+
+	m, err := renamedCopy(message, nil, env)
+	if err != nil {
+		return nil, err
+	}
+	ls, ok := vm.MessageHook(kind.String(), m)
+	if !ok {
+		return strings.Split(messageText(vm, message, env), "\n"), nil
+	}
+	lines := make([]string, 0, len(ls))
+	for _, l := range ls {
+		if a, ok := l.(Atom); ok {
+			lines = append(lines, a.String())
+			continue
+		}
+		lines = append(lines, strings.Split(messageText(vm, l, nil), "\n")...)
+	}
+	return lines, nil
+}
+
+// messageText renders message in plain text.
+// format(Format, Args) is formatted as format/2 does.
+// singletons(Names) is rendered as a warning of singleton variables.
+// Any other message, or a message which fails to render, is written in the quoted form.
+func messageText(vm *VM, message Term, env *Env) string {
+	var sb strings.Builder
+	if m, ok := env.Resolve(message).(Compound); ok {
+		switch {
+		case m.Functor() == atomFormat && m.Arity() == 2:
+			if err := formatText(&sb, 0, vm, m.Arg(0), m.Arg(1), env); err == nil {
+				return sb.String()
+			}
+			sb.Reset()
+		case m.Functor() == atomSingletons && m.Arity() == 1:
+			_, _ = sb.WriteString("Singleton variables: ")
+			opts := WriteOptions{ops: vm.operators, priority: 1200}
+			if err := env.Resolve(m.Arg(0)).WriteTerm(&sb, &opts, env); err == nil {
+				return sb.String()
+			}
+			sb.Reset()
+		}
+	}
+
+	opts := WriteOptions{ops: vm.operators, priority: 1200, quoted: true, numberVars: true}
+	_ = env.Resolve(message).WriteTerm(&sb, &opts, env)
+	return sb.String()
+}
+
+// Format writes args to streamOrSink according to format.
+// streamOrSink is either a stream, an alias, or a sink atom(A), string(S), codes(Cs), or chars(Cs) as with_output_to/2.
+func Format(vm *VM, streamOrSink, format, args Term, k Cont, env *Env) *Promise {
+	if _, ok := env.Resolve(streamOrSink).(Compound); ok {
+		text, err := outputSink(vm, streamOrSink, env)
+		if err != nil {
+			return Error(err)
+		}
+		var sb strings.Builder
+		if err := formatText(&sb, 0, vm, format, args, env); err != nil {
+			return Error(err)
+		}
+		return Unify(vm, env.Resolve(streamOrSink).(Compound).Arg(0), text(sb.String()), k, env)
+	}
+
+	s, err := stream(vm, streamOrSink, env)
+	if err != nil {
+		return Error(err)
+	}
+
+	w, err := s.textWriter()
+	switch {
+	case errors.Is(err, errWrongIOMode):
+		return Error(permissionError(operationOutput, permissionTypeStream, streamOrSink, env))
+	case errors.Is(err, errWrongStreamType):
+		return Error(permissionError(operationOutput, permissionTypeBinaryStream, streamOrSink, env))
+	case err != nil:
+		return Error(err)
+	}
+
+	if err := formatText(w, s.column, vm, format, args, env); err != nil {
+		return Error(err)
+	}
+	if s.autoFlush {
+		if err := s.Flush(); err != nil {
+			return Error(err)
+		}
+	}
+
+	return k(env)
+}
+
+// formatText writes args to w according to format, assuming the output of w starts at column.
+// format is an atom, a code list, or a character list.
+// If args is not a list, it's treated as the only argument.
+func formatText(w io.Writer, column int, vm *VM, format, args Term, env *Env) error {
+	f, err := textOf(format, env)
+	if err != nil {
+		return err
+	}
+
+	var as []Term
+	iter := ListIterator{List: args, Env: env}
+	for iter.Next() {
+		as = append(as, iter.Current())
+	}
+	if err := iter.Err(); err != nil {
+		as = []Term{args}
+	}
+
+	next := func() (Term, error) {
+		if len(as) == 0 {
+			return nil, formatError("not enough arguments", env)
+		}
+		var a Term
+		a, as = as[0], as[1:]
+		return env.Resolve(a), nil
+	}
+
+	cw := newColumnWriter(w, column)
+	rs := []rune(f)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] != '~' {
+			if _, err := cw.Write([]byte(string(rs[i]))); err != nil {
+				return err
+			}
+			continue
+		}
+		i++
+
+		// A directive may take a numeric argument, e.g. ~20|, a character argument, e.g. ~`-t, or * which takes the next argument.
+		arg, hasArg := 0, false
+		switch {
+		case i < len(rs) && rs[i] == '`':
+			if i+1 >= len(rs) {
+				return formatError("incomplete directive", env)
+			}
+			arg, hasArg = int(rs[i+1]), true
+			i += 2
+		case i < len(rs) && rs[i] == '*':
+			a, err := next()
+			if err != nil {
+				return err
+			}
+			n, ok := a.(Integer)
+			if !ok {
+				return typeError(validTypeInteger, a, env)
+			}
+			if n < 0 {
+				return domainError(validDomainNotLessThanZero, a, env)
+			}
+			arg, hasArg = int(n), true
+			i++
+		default:
+			for ; i < len(rs) && '0' <= rs[i] && rs[i] <= '9'; i++ {
+				arg, hasArg = 10*arg+int(rs[i]-'0'), true
+			}
+		}
+
+		if i == len(rs) {
+			return formatError("incomplete directive", env)
+		}
+		if hasArg && strings.ContainsRune("~wpqasi", rs[i]) {
+			return formatError("unexpected argument of ~"+string(rs[i]), env)
+		}
+		opts := WriteOptions{ops: vm.operators, priority: 1200, numberVars: true}
+		switch d := rs[i]; d {
+		case '~':
+			_, err = cw.Write([]byte("~"))
+		case 'n':
+			if !hasArg {
+				arg = 1
+			}
+			err = writeRepeat(cw, '\n', arg)
+		case 't':
+			if !hasArg {
+				arg = ' '
+			}
+			cw.fill(rune(arg))
+		case '|':
+			if !hasArg {
+				arg = cw.Column()
+			}
+			err = cw.columnStop(arg)
+		case '+':
+			if !hasArg {
+				arg = 8
+			}
+			err = cw.columnStop(cw.stop + arg)
+		case 'w', 'p', 'q':
+			var a Term
+			a, err = next()
+			if err != nil {
+				return err
+			}
+			opts.quoted = d == 'q'
+			err = a.WriteTerm(cw, &opts, env)
+		case 'a':
+			var a Term
+			a, err = next()
+			if err != nil {
+				return err
+			}
+			at, ok := a.(Atom)
+			if !ok {
+				return typeError(validTypeAtom, a, env)
+			}
+			_, err = cw.Write([]byte(at.String()))
+		case 'c':
+			var a Term
+			a, err = next()
+			if err != nil {
+				return err
+			}
+			c, ok := a.(Integer)
+			if !ok {
+				return typeError(validTypeInteger, a, env)
+			}
+			if c < 0 || c > unicode.MaxRune {
+				return representationError(flagCharacterCode, env)
+			}
+			if !hasArg {
+				arg = 1
+			}
+			err = writeRepeat(cw, rune(c), arg)
+		case 'd', 'D':
+			var a Term
+			a, err = next()
+			if err != nil {
+				return err
+			}
+			n, ok := a.(Integer)
+			if !ok {
+				return typeError(validTypeInteger, a, env)
+			}
+			_, err = cw.Write([]byte(formatInteger(n, arg, d == 'D')))
+		case 'r', 'R':
+			var a Term
+			a, err = next()
+			if err != nil {
+				return err
+			}
+			n, ok := a.(Integer)
+			if !ok {
+				return typeError(validTypeInteger, a, env)
+			}
+			if !hasArg {
+				return formatError("no radix", env)
+			}
+			if arg < 2 || arg > 36 {
+				return formatError("radix not in 2..36", env)
+			}
+			s := strconv.FormatInt(int64(n), arg)
+			if d == 'R' {
+				s = strings.ToUpper(s)
+			}
+			_, err = cw.Write([]byte(s))
+		case 'e', 'f', 'g':
+			var a Term
+			a, err = next()
+			if err != nil {
+				return err
+			}
+			var f float64
+			switch a := a.(type) {
+			case Integer:
+				f = float64(a)
+			case Float:
+				f = float64(a)
+			default:
+				return typeError(validTypeNumber, a, env)
+			}
+			if !hasArg {
+				arg = 6
+			}
+			_, err = cw.Write([]byte(strconv.FormatFloat(f, byte(d), arg, 64)))
+		case 's':
+			var a Term
+			a, err = next()
+			if err != nil {
+				return err
+			}
+			var s string
+			s, err = textOf(a, env)
+			if err != nil {
+				return err
+			}
+			_, err = cw.Write([]byte(s))
+		case 'i':
+			_, err = next()
+		default:
+			return formatError("unknown directive ~"+string(d), env)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := cw.Flush(); err != nil {
+		return err
+	}
+	if len(as) > 0 {
+		return formatError("too many arguments", env)
+	}
+	return nil
+}
+
+// formatError returns a syntax error which describes a malformed format or a mismatch of the arguments.
+func formatError(msg string, env *Env) Exception {
+	return syntaxError(errors.New("format: "+msg), env)
+}
+
+// textOf returns the text of t which is either an atom, a code list, or a character list.
+func textOf(t Term, env *Env) (string, error) {
+	switch t := env.Resolve(t).(type) {
+	case Variable:
+		return "", InstantiationError(env)
+	case Atom:
+		if t == atomEmptyList {
+			return "", nil
+		}
+		return t.String(), nil
+	default:
+		var sb strings.Builder
+		iter := ListIterator{List: t, Env: env}
+		for iter.Next() {
+			switch e := env.Resolve(iter.Current()).(type) {
+			case Variable:
+				return "", InstantiationError(env)
+			case Integer:
+				if e < 0 || e > unicode.MaxRune {
+					return "", representationError(flagCharacterCode, env)
+				}
+				_, _ = sb.WriteRune(rune(e))
+			case Atom:
+				if utf8.RuneCountInString(e.String()) != 1 {
+					return "", typeError(validTypeCharacter, e, env)
+				}
+				_, _ = sb.WriteString(e.String())
+			default:
+				return "", typeError(validTypeCharacter, e, env)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return "", err
+		}
+		return sb.String(), nil
+	}
+}
+
+// formatInteger formats n in decimal with a decimal point inserted point digits from the right.
+// If group is true, the digits of the integer part are grouped by three with commas.
+func formatInteger(n Integer, point int, group bool) string {
+	var sign string
+	digits := strconv.FormatInt(int64(n), 10)
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	if len(digits) <= point {
+		digits = strings.Repeat("0", point-len(digits)+1) + digits
+	}
+	i, f := digits[:len(digits)-point], digits[len(digits)-point:]
+	if group {
+		var sb strings.Builder
+		for j, c := range i {
+			if j > 0 && (len(i)-j)%3 == 0 {
+				_ = sb.WriteByte(',')
+			}
+			_, _ = sb.WriteRune(c)
+		}
+		i = sb.String()
+	}
+	if f == "" {
+		return sign + i
+	}
+	return sign + i + "." + f
+}
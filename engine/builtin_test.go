@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
@@ -14,6 +15,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 	"unicode/utf8"
 )
 
@@ -57,8 +59,8 @@ f(g([a, [b, c|X]])).
 
 		{title: `cover all`, goal: atomComma.Apply(atomCut, NewAtom("f").Apply(NewAtom("g").Apply(List(NewAtom("a"), PartialList(NewVariable(), NewAtom("b"), NewAtom("c")))))), ok: true},
 		{title: `out of memory`, goal: NewAtom("foo").Apply(NewVariable(), NewVariable(), NewVariable(), NewVariable(), NewVariable(), NewVariable(), NewVariable(), NewVariable(), NewVariable()), err: resourceError(resourceMemory, nil), mem: 1},
-		{title: `panic`, goal: NewAtom("do_not_call"), err: errors.New("panic: told you")},
-		{title: `panic (lazy)`, goal: NewAtom("lazy_do_not_call"), err: errors.New("panic: told you")},
+		{title: `panic`, goal: NewAtom("do_not_call"), err: &panicError{value: "told you"}},
+		{title: `panic (lazy)`, goal: NewAtom("lazy_do_not_call"), err: &panicError{value: "told you"}},
 	}
 
 	for _, tt := range tests {
@@ -371,6 +373,241 @@ func TestCallNth(t *testing.T) {
 	})
 }
 
+func TestCallWithInferenceLimit(t *testing.T) {
+	vm := VM{
+		procedures: map[procedureIndicator]procedure{
+			{name: NewAtom("foo"), arity: 1}: Predicate1(func(vm *VM, x Term, k Cont, env *Env) *Promise {
+				return Delay(func(context.Context) *Promise {
+					return Unify(vm, x, NewAtom("a"), k, env)
+				}, func(context.Context) *Promise {
+					return Unify(vm, x, NewAtom("b"), k, env)
+				})
+			}),
+			{name: NewAtom("loop"), arity: 0}: Predicate0(func(_ *VM, k Cont, env *Env) *Promise {
+				return Repeat(nil, Failure, env)
+			}),
+		},
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		x, r := NewVariable(), NewVariable()
+
+		var xs []Term
+		ok, err := CallWithInferenceLimit(&vm, NewAtom("foo").Apply(x), Integer(100), r, func(env *Env) *Promise {
+			xs = append(xs, env.Resolve(x))
+			assert.Equal(t, atomCut, env.Resolve(r))
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, []Term{NewAtom("a")}, xs)
+	})
+
+	t.Run("goal fails", func(t *testing.T) {
+		ok, err := CallWithInferenceLimit(&vm, NewAtom("foo").Apply(NewAtom("c")), Integer(100), NewVariable(), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("inference limit exceeded", func(t *testing.T) {
+		r := NewVariable()
+		ok, err := CallWithInferenceLimit(&vm, NewAtom("loop"), Integer(100), r, func(env *Env) *Promise {
+			assert.Equal(t, atomInferenceLimitExceeded, env.Resolve(r))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("outer inference limit exceeded", func(t *testing.T) {
+		ok, err := CallWithInferenceLimit(&vm, NewAtom("loop"), Integer(100), NewVariable(), Success, nil).Force(WithInferenceLimit(context.Background(), 10))
+		assert.Equal(t, resourceError(resourceInferences, nil), err)
+		assert.False(t, ok)
+	})
+
+	t.Run("outer context is done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		ok, err := CallWithInferenceLimit(&vm, NewAtom("loop"), Integer(math.MaxInt64), NewVariable(), Success, nil).Force(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("limit is a variable", func(t *testing.T) {
+		_, err := CallWithInferenceLimit(&vm, NewAtom("loop"), NewVariable(), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+	})
+
+	t.Run("limit is neither a variable nor an integer", func(t *testing.T) {
+		_, err := CallWithInferenceLimit(&vm, NewAtom("loop"), NewAtom("foo"), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeInteger, NewAtom("foo"), nil), err)
+	})
+
+	t.Run("limit is negative", func(t *testing.T) {
+		_, err := CallWithInferenceLimit(&vm, NewAtom("loop"), Integer(-1), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, domainError(validDomainNotLessThanZero, Integer(-1), nil), err)
+	})
+}
+
+func TestCallWithTimeLimit(t *testing.T) {
+	vm := VM{
+		procedures: map[procedureIndicator]procedure{
+			{name: NewAtom("foo"), arity: 1}: Predicate1(func(vm *VM, x Term, k Cont, env *Env) *Promise {
+				return Delay(func(context.Context) *Promise {
+					return Unify(vm, x, NewAtom("a"), k, env)
+				}, func(context.Context) *Promise {
+					return Unify(vm, x, NewAtom("b"), k, env)
+				})
+			}),
+			{name: NewAtom("loop"), arity: 0}: Predicate0(func(_ *VM, k Cont, env *Env) *Promise {
+				return Repeat(nil, Failure, env)
+			}),
+		},
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		x := NewVariable()
+
+		var xs []Term
+		ok, err := CallWithTimeLimit(&vm, Integer(1), NewAtom("foo").Apply(x), func(env *Env) *Promise {
+			xs = append(xs, env.Resolve(x))
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, []Term{NewAtom("a")}, xs)
+	})
+
+	t.Run("goal fails", func(t *testing.T) {
+		ok, err := CallWithTimeLimit(&vm, Float(0.5), NewAtom("foo").Apply(NewAtom("c")), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("time limit exceeded", func(t *testing.T) {
+		ok, err := CallWithTimeLimit(&vm, Float(0.01), NewAtom("loop"), Success, nil).Force(context.Background())
+		assert.Equal(t, NewException(atomTimeLimitExceeded, nil), err)
+		assert.False(t, ok)
+	})
+
+	t.Run("outer context is done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		ok, err := CallWithTimeLimit(&vm, Integer(1), NewAtom("loop"), Success, nil).Force(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("time is a variable", func(t *testing.T) {
+		_, err := CallWithTimeLimit(&vm, NewVariable(), NewAtom("loop"), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+	})
+
+	t.Run("time is neither a variable nor a number", func(t *testing.T) {
+		_, err := CallWithTimeLimit(&vm, NewAtom("foo"), NewAtom("loop"), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeNumber, NewAtom("foo"), nil), err)
+	})
+}
+
+func TestWithOutputTo(t *testing.T) {
+	var vm VM
+	vm.SetUserOutput(NewOutputTextStream(nil))
+	vm.Register1(NewAtom("foo"), func(vm *VM, x Term, k Cont, env *Env) *Promise {
+		return Delay(func(context.Context) *Promise {
+			_, _ = vm.output.WriteRune('a')
+			return Unify(vm, x, NewAtom("a"), k, env)
+		}, func(context.Context) *Promise {
+			_, _ = vm.output.WriteRune('b')
+			return Unify(vm, x, NewAtom("b"), k, env)
+		})
+	})
+	vm.Register0(NewAtom("bar"), func(vm *VM, k Cont, env *Env) *Promise {
+		_, _ = vm.output.WriteRune('c')
+		return Error(NewException(NewAtom("ball"), env))
+	})
+	output := vm.output
+
+	t.Run("ok", func(t *testing.T) {
+		for _, tt := range []struct {
+			sink   Atom
+			result Term
+		}{
+			{sink: atomAtom, result: NewAtom("a")},
+			{sink: atomCodes, result: CodeList("a")},
+			{sink: atomChars, result: CharList("a")},
+			{sink: atomString, result: CharList("a")},
+		} {
+			t.Run(tt.sink.String(), func(t *testing.T) {
+				x, s := NewVariable(), NewVariable()
+				var c int
+				ok, err := WithOutputTo(&vm, tt.sink.Apply(s), NewAtom("foo").Apply(x), func(env *Env) *Promise {
+					assert.Equal(t, NewAtom("a"), env.Resolve(x))
+					assert.Equal(t, tt.result, env.Resolve(s))
+					assert.Equal(t, output, vm.output)
+					c++
+					return Bool(false)
+				}, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.False(t, ok)
+				assert.Equal(t, 1, c)
+			})
+		}
+	})
+
+	t.Run("string with double_quotes flag", func(t *testing.T) {
+		defer func(d doubleQuotes) {
+			vm.doubleQuotes = d
+		}(vm.doubleQuotes)
+		for _, tt := range []struct {
+			doubleQuotes doubleQuotes
+			result       Term
+		}{
+			{doubleQuotes: doubleQuotesCodes, result: CodeList("a")},
+			{doubleQuotes: doubleQuotesChars, result: CharList("a")},
+			{doubleQuotes: doubleQuotesAtom, result: NewAtom("a")},
+		} {
+			t.Run(tt.doubleQuotes.String(), func(t *testing.T) {
+				vm.doubleQuotes = tt.doubleQuotes
+				s := NewVariable()
+				ok, err := WithOutputTo(&vm, atomString.Apply(s), NewAtom("foo").Apply(NewVariable()), func(env *Env) *Promise {
+					assert.Equal(t, tt.result, env.Resolve(s))
+					return Bool(true)
+				}, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.True(t, ok)
+			})
+		}
+	})
+
+	t.Run("goal fails", func(t *testing.T) {
+		ok, err := WithOutputTo(&vm, atomAtom.Apply(NewVariable()), NewAtom("foo").Apply(NewAtom("c")), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, output, vm.output)
+	})
+
+	t.Run("goal throws", func(t *testing.T) {
+		ok, err := WithOutputTo(&vm, atomAtom.Apply(NewVariable()), NewAtom("bar"), Success, nil).Force(context.Background())
+		assert.Equal(t, NewException(NewAtom("ball"), nil), err)
+		assert.False(t, ok)
+		assert.Equal(t, output, vm.output)
+	})
+
+	t.Run("sink is a variable", func(t *testing.T) {
+		ok, err := WithOutputTo(&vm, NewVariable(), NewAtom("foo").Apply(NewVariable()), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+		assert.False(t, ok)
+	})
+
+	t.Run("sink is not an output sink", func(t *testing.T) {
+		ok, err := WithOutputTo(&vm, NewAtom("foo").Apply(NewAtom("a")), NewAtom("foo").Apply(NewVariable()), Success, nil).Force(context.Background())
+		assert.Equal(t, domainError(validDomainOutputSink, NewAtom("foo").Apply(NewAtom("a")), nil), err)
+		assert.False(t, ok)
+	})
+}
+
 func TestUnify(t *testing.T) {
 	x, y := NewVariable(), NewVariable()
 	tests := []struct {
@@ -609,6 +846,7 @@ func TestFunctor(t *testing.T) {
 		ok                bool
 		err               error
 		env               map[Variable]Term
+		maxArity          int
 	}{
 		// 8.5.1.4 Examples
 		{title: `functor(foo(a, b, c), foo, 3).`, term: NewAtom("foo").Apply(NewAtom("a"), NewAtom("b"), NewAtom("c")), name: NewAtom("foo"), arity: Integer(3), ok: true},
@@ -642,7 +880,7 @@ func TestFunctor(t *testing.T) {
 		{title: `functor(X, foo, a).`, term: x, name: NewAtom("foo"), arity: NewAtom("a"), err: typeError(validTypeInteger, NewAtom("a"), nil)},
 		{title: `functor(F, 1.5, 1).`, term: f, name: Float(1.5), arity: Integer(1), err: typeError(validTypeAtom, Float(1.5), nil)},
 		{title: `functor(F, foo(a), 1).`, term: f, name: NewAtom("foo").Apply(NewAtom("a")), arity: Integer(1), err: typeError(validTypeAtomic, NewAtom("foo").Apply(NewAtom("a")), nil)},
-		// {title: `current_prolog_flag(max_arity, A), X is A + 1, functor(T, foo, X).`}
+		{title: `current_prolog_flag(max_arity, A), X is A + 1, functor(T, foo, X).`, term: NewVariable(), name: NewAtom("foo"), arity: Integer(256), maxArity: 255, err: representationError(flagMaxArity, nil)},
 		{title: `Minus_1 is 0 - 1, functor(F, foo, Minus_1).`, term: f, name: NewAtom("foo"), arity: Integer(-1), err: domainError(validDomainNotLessThanZero, Integer(-1), nil)},
 
 		// https://github.com/ichiban/prolog/issues/247
@@ -650,11 +888,15 @@ func TestFunctor(t *testing.T) {
 
 		// https://github.com/ichiban/prolog/issues/226
 		{title: `functor(F, f, max_int).`, term: f, name: NewAtom("f"), arity: maxInt, err: resourceError(resourceMemory, nil)},
+
+		{title: `functor(F, f, 9999999999).`, term: f, name: NewAtom("f"), arity: Integer(9999999999), maxArity: 255, err: representationError(flagMaxArity, nil)},
+		{title: `functor(F, f, 255).`, term: f, name: NewAtom("f"), arity: Integer(255), maxArity: 255, ok: true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.title, func(t *testing.T) {
-			ok, err := Functor(nil, tt.term, tt.name, tt.arity, func(env *Env) *Promise {
+			vm := VM{MaxArity: tt.maxArity}
+			ok, err := Functor(&vm, tt.term, tt.name, tt.arity, func(env *Env) *Promise {
 				for k, v := range tt.env {
 					_, ok := env.Unify(k, v)
 					assert.True(t, ok)
@@ -799,6 +1041,13 @@ func TestUniv(t *testing.T) {
 		{title: "term is a variable, list has exactly one member which is an atomic", term: x, list: List(Integer(1)), ok: true, env: map[Variable]Term{
 			x: Integer(1),
 		}},
+		{title: "term is a variable, list has exactly one member which is a float", term: x, list: List(Float(1.5)), ok: true, env: map[Variable]Term{
+			x: Float(1.5),
+		}},
+		{title: "term is a variable, list has exactly one member which is an atom", term: x, list: List(NewAtom("foo")), ok: true, env: map[Variable]Term{
+			x: NewAtom("foo"),
+		}},
+		{title: "term is a variable, list has a compound head and a tail", term: x, list: PartialList(List(NewAtom("a")), NewAtom("f").Apply(NewAtom("a"))), err: typeError(validTypeAtom, NewAtom("f").Apply(NewAtom("a")), nil)},
 		{title: "term is an atomic, the length of list is not 1", term: Integer(1), list: List(), ok: false},
 
 		// https://github.com/ichiban/prolog/issues/244
@@ -874,6 +1123,76 @@ func TestCopyTerm(t *testing.T) {
 	}
 }
 
+func TestRenamedCopy(t *testing.T) {
+	t.Run("ground subterms are shared", func(t *testing.T) {
+		g := NewAtom("g").Apply(NewAtom("a"), List(Integer(1), Integer(2)))
+		x := NewVariable()
+		in := NewAtom("f").Apply(x, g)
+
+		c, err := renamedCopy(in, nil, nil)
+		assert.NoError(t, err)
+		cp := c.(Compound)
+		assert.NotEqual(t, x, cp.Arg(0))
+		assert.True(t, g == cp.Arg(1))
+	})
+
+	t.Run("ground term is shared", func(t *testing.T) {
+		in := NewAtom("f").Apply(NewAtom("a"), NewAtom("b"))
+		c, err := renamedCopy(in, nil, nil)
+		assert.NoError(t, err)
+		assert.True(t, in == c)
+	})
+
+	t.Run("bound variables are resolved", func(t *testing.T) {
+		x := NewVariable()
+		in := NewAtom("f").Apply(x, NewAtom("b"))
+		env := NewEnv().bind(x, NewAtom("a"))
+		c, err := renamedCopy(in, nil, env)
+		assert.NoError(t, err)
+		assert.Equal(t, NewAtom("f").Apply(NewAtom("a"), NewAtom("b")), c)
+	})
+}
+
+func TestUnnumberVars(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		x, out := NewVariable(), NewVariable()
+		in := NewAtom("f").Apply(
+			atomVar.Apply(Integer(0)),
+			NewAtom("g").Apply(atomVar.Apply(Integer(1)), atomVar.Apply(Integer(0))),
+			x,
+			atomVar.Apply(Integer(-1)),
+			atomVar.Apply(NewAtom("a")),
+			NewAtom("h").Apply(NewAtom("b")),
+		)
+		ok, err := UnnumberVars(nil, in, out, func(env *Env) *Promise {
+			c, ok := env.Resolve(out).(Compound)
+			assert.True(t, ok)
+
+			a, ok := env.Resolve(c.Arg(0)).(Variable)
+			assert.True(t, ok)
+			g := env.Resolve(c.Arg(1)).(Compound)
+			b, ok := env.Resolve(g.Arg(0)).(Variable)
+			assert.True(t, ok)
+			assert.NotEqual(t, a, b)
+			assert.Equal(t, a, env.Resolve(g.Arg(1)))
+			assert.Equal(t, x, env.Resolve(c.Arg(2)))
+			assert.Equal(t, atomVar.Apply(Integer(-1)), env.Resolve(c.Arg(3)))
+			assert.Equal(t, atomVar.Apply(NewAtom("a")), env.Resolve(c.Arg(4)))
+			assert.Equal(t, NewAtom("h").Apply(NewAtom("b")), env.Resolve(c.Arg(5)))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("ground", func(t *testing.T) {
+		in := NewAtom("f").Apply(NewAtom("a"), List(Integer(1), Integer(2)))
+		ok, err := UnnumberVars(nil, in, in, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
+
 func TestTermVariables(t *testing.T) {
 	vars := NewVariable()
 	vs, vt := NewVariable(), NewVariable()
@@ -1069,6 +1388,42 @@ func TestOp(t *testing.T) {
 		}, vm.operators)
 	})
 
+	t.Run("remove by class", func(t *testing.T) {
+		for _, spec := range []Atom{atomXFX, atomXFY, atomYFX} {
+			t.Run(spec.String(), func(t *testing.T) {
+				vm := VM{
+					operators: operators{
+						NewAtom(`foo`): {
+							operatorClassPrefix: {
+								priority:  200,
+								specifier: operatorSpecifierFY,
+								name:      NewAtom("foo"),
+							},
+							operatorClassInfix: {
+								priority:  500,
+								specifier: operatorSpecifierYFX,
+								name:      NewAtom("foo"),
+							},
+						},
+					},
+				}
+				ok, err := Op(&vm, Integer(0), spec, NewAtom("foo"), Success, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.True(t, ok)
+
+				assert.Equal(t, operators{
+					NewAtom(`foo`): {
+						operatorClassPrefix: {
+							priority:  200,
+							specifier: operatorSpecifierFY,
+							name:      NewAtom("foo"),
+						},
+					},
+				}, vm.operators)
+			})
+		}
+	})
+
 	t.Run("priority is a variable", func(t *testing.T) {
 		var vm VM
 		ok, err := Op(&vm, NewVariable(), atomXFX, atomPlus, Success, nil).Force(context.Background())
@@ -1473,6 +1828,14 @@ func TestBagOf(t *testing.T) {
 			err:       typeError(validTypeList, PartialList(Integer(1), NewAtom("t")), nil),
 		},
 
+		{
+			title:     "bagof(X, (X = 1; throw(ball)), L).",
+			template:  x,
+			goal:      atomSemiColon.Apply(atomEqual.Apply(x, Integer(1)), NewAtom("throw").Apply(NewAtom("ball"))),
+			instances: l,
+			err:       NewException(NewAtom("ball"), nil),
+		},
+
 		{
 			title:    "out of memory: goal",
 			template: x,
@@ -1532,6 +1895,7 @@ func TestBagOf(t *testing.T) {
 	vm.Register0(atomFail, func(*VM, Cont, *Env) *Promise {
 		return Bool(false)
 	})
+	vm.Register1(NewAtom("throw"), Throw)
 	vm.Register2(NewAtom("a"), func(vm *VM, x, y Term, k Cont, env *Env) *Promise {
 		a, f := NewAtom("$a"), NewAtom("f")
 		return Delay(func(context.Context) *Promise {
@@ -2043,6 +2407,8 @@ func TestFindAll(t *testing.T) {
 		// 8.10.1.3 Errors
 		{title: "c", template: x, goal: atomSemiColon.Apply(atomEqual.Apply(x, Integer(1)), atomEqual.Apply(x, Integer(2))), instances: NewAtom("foo"), err: typeError(validTypeList, NewAtom("foo"), nil)},
 
+		{title: "exception on the second solution", template: x, goal: atomSemiColon.Apply(atomEqual.Apply(x, Integer(1)), NewAtom("throw").Apply(NewAtom("ball"))), instances: s, err: NewException(NewAtom("ball"), nil)},
+
 		{
 			title:     "out of memory",
 			template:  tuple(NewVariable(), NewVariable(), NewVariable(), NewVariable(), NewVariable(), NewVariable(), NewVariable(), NewVariable(), NewVariable()),
@@ -2065,6 +2431,7 @@ func TestFindAll(t *testing.T) {
 	vm.Register0(atomFail, func(*VM, Cont, *Env) *Promise {
 		return Bool(false)
 	})
+	vm.Register1(NewAtom("throw"), Throw)
 
 	for _, tt := range tests {
 		t.Run(tt.title, func(t *testing.T) {
@@ -2083,8 +2450,154 @@ func TestFindAll(t *testing.T) {
 	}
 }
 
+func TestFindAll4(t *testing.T) {
+	x := NewVariable()
+	s, tail := NewVariable(), NewVariable()
+
+	tests := []struct {
+		title                           string
+		template, goal, instances, tail Term
+		ok                              bool
+		err                             error
+		env                             map[Variable]Term
+	}{
+		{title: "ok", template: x, goal: atomSemiColon.Apply(atomEqual.Apply(x, Integer(1)), atomEqual.Apply(x, Integer(2))), instances: s, tail: tail, ok: true, env: map[Variable]Term{
+			s: PartialList(tail, Integer(1), Integer(2)),
+		}},
+		{title: "bound tail", template: x, goal: atomEqual.Apply(x, Integer(1)), instances: s, tail: List(Integer(3)), ok: true, env: map[Variable]Term{
+			s: List(Integer(1), Integer(3)),
+		}},
+		{title: "goal fails", template: x, goal: atomFail, instances: s, tail: tail, ok: true, env: map[Variable]Term{
+			s: tail,
+		}},
+		{title: "instances doesn't match", template: x, goal: atomEqual.Apply(x, Integer(1)), instances: List(Integer(2)), tail: List(), ok: false},
+		{title: "instances is not a list", template: x, goal: atomEqual.Apply(x, Integer(1)), instances: NewAtom("foo"), tail: tail, err: typeError(validTypeList, NewAtom("foo"), nil)},
+	}
+
+	var vm VM
+	vm.Register2(atomEqual, Unify)
+	vm.Register2(atomSemiColon, func(vm *VM, g1, g2 Term, k Cont, env *Env) *Promise {
+		return Delay(func(context.Context) *Promise {
+			return Call(vm, g1, k, env)
+		}, func(context.Context) *Promise {
+			return Call(vm, g2, k, env)
+		})
+	})
+	vm.Register0(atomFail, func(*VM, Cont, *Env) *Promise {
+		return Bool(false)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := FindAll4(&vm, tt.template, tt.goal, tt.instances, tt.tail, func(env *Env) *Promise {
+				for k, v := range tt.env {
+					assert.Equal(t, 0, v.Compare(k, env))
+				}
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestAggregateAll(t *testing.T) {
+	x, r := NewVariable(), NewVariable()
+	p := NewAtom("p")
+
+	var vm VM
+	vm.Register1(p, func(vm *VM, x Term, k Cont, env *Env) *Promise {
+		return Delay(func(context.Context) *Promise {
+			return Unify(vm, x, Integer(3), k, env)
+		}, func(context.Context) *Promise {
+			return Unify(vm, x, Float(1.5), k, env)
+		}, func(context.Context) *Promise {
+			return Unify(vm, x, Integer(3), k, env)
+		}, func(context.Context) *Promise {
+			return Unify(vm, x, Integer(-2), k, env)
+		})
+	})
+	vm.Register0(atomFail, func(*VM, Cont, *Env) *Promise {
+		return Bool(false)
+	})
+	vm.Register0(atomError, func(*VM, Cont, *Env) *Promise {
+		return Error(errors.New("failed"))
+	})
+
+	tests := []struct {
+		title                   string
+		aggregate, goal, result Term
+		ok                      bool
+		err                     error
+		want                    Term
+	}{
+		{title: "count", aggregate: atomCount, goal: p.Apply(x), result: r, ok: true, want: Integer(4)},
+		{title: "count(X)", aggregate: atomCount.Apply(x), goal: p.Apply(x), result: r, ok: true, want: Integer(4)},
+		{title: "sum(X)", aggregate: atomSum.Apply(x), goal: p.Apply(x), result: r, ok: true, want: Float(5.5)},
+		{title: "sum(X*2)", aggregate: atomSum.Apply(atomAsterisk.Apply(x, Integer(2))), goal: p.Apply(x), result: r, ok: true, want: Float(11)},
+		{title: "max(X)", aggregate: atomMax.Apply(x), goal: p.Apply(x), result: r, ok: true, want: Integer(3)},
+		{title: "min(X)", aggregate: atomMin.Apply(x), goal: p.Apply(x), result: r, ok: true, want: Integer(-2)},
+		{title: "bag(X)", aggregate: atomBag.Apply(x), goal: p.Apply(x), result: r, ok: true, want: List(Integer(3), Float(1.5), Integer(3), Integer(-2))},
+		{title: "set(X)", aggregate: atomSet.Apply(x), goal: p.Apply(x), result: r, ok: true, want: List(Integer(-2), Float(1.5), Integer(3))},
+
+		{title: "count of no solutions", aggregate: atomCount, goal: atomFail, result: r, ok: true, want: Integer(0)},
+		{title: "sum of no solutions", aggregate: atomSum.Apply(x), goal: atomFail, result: r, ok: true, want: Integer(0)},
+		{title: "max of no solutions", aggregate: atomMax.Apply(x), goal: atomFail, result: r, ok: false},
+		{title: "min of no solutions", aggregate: atomMin.Apply(x), goal: atomFail, result: r, ok: false},
+		{title: "bag of no solutions", aggregate: atomBag.Apply(x), goal: atomFail, result: r, ok: true, want: List()},
+		{title: "set of no solutions", aggregate: atomSet.Apply(x), goal: atomFail, result: r, ok: true, want: List()},
+
+		{title: "aggregate is a variable", aggregate: NewVariable(), goal: p.Apply(x), result: r, err: InstantiationError(nil)},
+		{title: "unknown aggregate", aggregate: NewAtom("foo").Apply(NewAtom("a")), goal: p.Apply(x), result: r, err: domainError(validDomainAggregateSpec, NewAtom("foo").Apply(NewAtom("a")), nil)},
+		{title: "aggregate is an atom other than count", aggregate: atomSum, goal: p.Apply(x), result: r, err: domainError(validDomainAggregateSpec, atomSum, nil)},
+		{title: "aggregate is not callable", aggregate: Integer(1), goal: p.Apply(x), result: r, err: domainError(validDomainAggregateSpec, Integer(1), nil)},
+		{title: "non-evaluable", aggregate: atomSum.Apply(NewAtom("foo")), goal: p.Apply(x), result: r, err: typeError(validTypeEvaluable, atomSlash.Apply(NewAtom("foo"), Integer(0)), nil)},
+		{title: "error in goal", aggregate: atomCount, goal: atomError, result: r, err: errors.New("failed")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := AggregateAll(&vm, tt.aggregate, tt.goal, tt.result, func(env *Env) *Promise {
+				assert.Equal(t, tt.want, env.Resolve(r))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func BenchmarkFindAll(b *testing.B) {
+	// A ground payload shared by all the solutions.
+	payload := make([]Term, 1000)
+	for i := range payload {
+		payload[i] = NewAtom("f").Apply(Integer(i), NewAtom("a"))
+	}
+	ground := NewAtom("payload").Apply(payload...)
+
+	var vm VM
+	vm.Register2(NewAtom("p"), func(vm *VM, n, p Term, k Cont, env *Env) *Promise {
+		ks := make([]func(context.Context) *Promise, 1000)
+		for i := range ks {
+			i := Integer(i)
+			ks[i] = func(context.Context) *Promise {
+				return Unify(vm, tuple(n, p), tuple(i, ground), k, env)
+			}
+		}
+		return Delay(ks...)
+	})
+
+	x, y := NewVariable(), NewVariable()
+	template := NewAtom("s").Apply(x, y)
+	goal := NewAtom("p").Apply(x, y)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = FindAll(&vm, template, goal, NewVariable(), Success, nil).Force(context.Background())
+	}
+}
+
 func TestCompare(t *testing.T) {
-	order := NewVariable()
+	order, x, y := NewVariable(), NewVariable(), NewVariable()
 
 	tests := []struct {
 		title       string
@@ -2108,6 +2621,9 @@ func TestCompare(t *testing.T) {
 		{title: `compare(>=, 3, 3.0).`, order: NewAtom(">="), x: Integer(3), y: Float(3.0), ok: false, err: domainError(validDomainOrder, NewAtom(">="), nil)},
 
 		{title: `missing case for >`, order: atomGreaterThan, x: Integer(2), y: Integer(1), ok: true},
+		{title: `compare(Order, X, X).`, order: order, x: x, y: x, ok: true, env: map[Variable]Term{
+			order: atomEqual,
+		}},
 	}
 
 	for _, tt := range tests {
@@ -2120,9 +2636,23 @@ func TestCompare(t *testing.T) {
 		assert.Equal(t, tt.ok, ok)
 		assert.Equal(t, tt.err, err)
 	}
-}
 
-func TestBetween(t *testing.T) {
+	t.Run("X = Y, compare(Order, X, Y).", func(t *testing.T) {
+		for _, env := range []*Env{
+			NewEnv().bind(x, y),
+			NewEnv().bind(y, x),
+		} {
+			ok, err := Compare(nil, order, x, y, func(env *Env) *Promise {
+				assert.Equal(t, atomEqual, env.Resolve(order))
+				return Bool(true)
+			}, env).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}
+	})
+}
+
+func TestBetween(t *testing.T) {
 	t.Run("value is an integer", func(t *testing.T) {
 		t.Run("between lower and upper", func(t *testing.T) {
 			ok, err := Between(nil, Integer(1), Integer(3), Integer(2), Success, nil).Force(context.Background())
@@ -2207,6 +2737,18 @@ func TestBetween(t *testing.T) {
 			assert.False(t, ok)
 		})
 
+		t.Run("large range", func(t *testing.T) {
+			var n int
+			value := NewVariable()
+			ok, err := Between(nil, Integer(1), Integer(1000000000), value, func(env *Env) *Promise {
+				n++
+				return Bool(env.Resolve(value).(Integer) >= 5)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, 5, n)
+		})
+
 		t.Run("lower > upper", func(t *testing.T) {
 			value := NewVariable()
 			ok, err := Between(nil, Integer(3), Integer(0), value, Success, nil).Force(context.Background())
@@ -2215,6 +2757,26 @@ func TestBetween(t *testing.T) {
 		})
 	})
 
+	t.Run("upper is inf", func(t *testing.T) {
+		for _, inf := range []Atom{atomInf, atomInfinite} {
+			t.Run(inf.String(), func(t *testing.T) {
+				var n int
+				value := NewVariable()
+				ok, err := Between(nil, Integer(1), inf, value, func(env *Env) *Promise {
+					n++
+					assert.Equal(t, Integer(n), env.Resolve(value))
+					return Bool(n == 100)
+				}, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.True(t, ok)
+
+				ok, err = Between(nil, Integer(1), inf, Integer(math.MaxInt64), Success, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.True(t, ok)
+			})
+		}
+	})
+
 	t.Run("lower is uninstantiated", func(t *testing.T) {
 		_, err := Between(nil, NewVariable(), Integer(2), Integer(1), Success, nil).Force(context.Background())
 		assert.Equal(t, InstantiationError(nil), err)
@@ -2231,8 +2793,8 @@ func TestBetween(t *testing.T) {
 	})
 
 	t.Run("upper is not an integer", func(t *testing.T) {
-		_, err := Between(nil, Integer(1), NewAtom("inf"), Integer(1), Success, nil).Force(context.Background())
-		assert.Equal(t, typeError(validTypeInteger, NewAtom("inf"), nil), err)
+		_, err := Between(nil, Integer(1), NewAtom("foo"), Integer(1), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeInteger, NewAtom("foo"), nil), err)
 	})
 
 	t.Run("value is not an integer or variable", func(t *testing.T) {
@@ -2260,6 +2822,35 @@ func TestSort(t *testing.T) {
 		})
 	})
 
+	t.Run("nan", func(t *testing.T) {
+		sorted := NewVariable()
+		ok, err := Sort(nil, List(Float(1), Float(math.NaN()), Integer(0), Float(math.Inf(-1)), Float(math.NaN())), sorted, func(env *Env) *Promise {
+			var elems []Term
+			iter := ListIterator{List: sorted, Env: env}
+			for iter.Next() {
+				elems = append(elems, env.Resolve(iter.Current()))
+			}
+			assert.NoError(t, iter.Err())
+			assert.Len(t, elems, 4)
+			assert.True(t, math.IsNaN(float64(elems[0].(Float))))
+			assert.Equal(t, []Term{Float(math.Inf(-1)), Integer(0), Float(1)}, elems[1:])
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("empty list", func(t *testing.T) {
+		ok, err := Sort(nil, List(), List(), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("list is a variable", func(t *testing.T) {
+		_, err := Sort(nil, NewVariable(), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+	})
+
 	t.Run("list is a partial list", func(t *testing.T) {
 		_, err := Sort(nil, PartialList(NewVariable(), NewAtom("a"), NewAtom("b")), NewVariable(), Success, nil).Force(context.Background())
 		assert.Equal(t, InstantiationError(nil), err)
@@ -2283,6 +2874,129 @@ func TestSort(t *testing.T) {
 	})
 }
 
+func TestMSort(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		t.Run("nan", func(t *testing.T) {
+			sorted := NewVariable()
+			ok, err := MSort(nil, List(Float(1), Float(math.NaN()), Integer(0), Float(math.NaN()), Float(1)), sorted, func(env *Env) *Promise {
+				var elems []Term
+				iter := ListIterator{List: sorted, Env: env}
+				for iter.Next() {
+					elems = append(elems, env.Resolve(iter.Current()))
+				}
+				assert.NoError(t, iter.Err())
+				assert.Len(t, elems, 5)
+				assert.True(t, math.IsNaN(float64(elems[0].(Float))))
+				assert.True(t, math.IsNaN(float64(elems[1].(Float))))
+				assert.Equal(t, []Term{Integer(0), Float(1), Float(1)}, elems[2:])
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+
+		t.Run("mixed types", func(t *testing.T) {
+			x, y := NewVariable(), NewVariable()
+			sorted := NewVariable()
+			ok, err := MSort(nil, List(
+				NewAtom("f").Apply(NewAtom("a")),
+				Integer(2),
+				NewAtom("b"),
+				y,
+				Float(1),
+				NewAtom("a"),
+				x,
+				Integer(1),
+				NewAtom("b"),
+				NewAtom("g").Apply(NewAtom("a"), NewAtom("b")),
+			), sorted, func(env *Env) *Promise {
+				assert.Equal(t, List(
+					x,
+					y,
+					Float(1),
+					Integer(1),
+					Integer(2),
+					NewAtom("a"),
+					NewAtom("b"),
+					NewAtom("b"),
+					NewAtom("f").Apply(NewAtom("a")),
+					NewAtom("g").Apply(NewAtom("a"), NewAtom("b")),
+				), env.Resolve(sorted))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+
+		t.Run("empty list", func(t *testing.T) {
+			ok, err := MSort(nil, List(), List(), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+	})
+
+	t.Run("list is a variable", func(t *testing.T) {
+		_, err := MSort(nil, NewVariable(), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+	})
+
+	t.Run("list is a partial list", func(t *testing.T) {
+		_, err := MSort(nil, PartialList(NewVariable(), NewAtom("a"), NewAtom("b")), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+	})
+
+	t.Run("list is neither a partial list nor a list", func(t *testing.T) {
+		_, err := MSort(nil, PartialList(NewAtom("c"), NewAtom("a"), NewAtom("b")), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeList, PartialList(NewAtom("c"), NewAtom("a"), NewAtom("b")), nil), err)
+	})
+
+	t.Run("sorted is neither a partial list nor a list", func(t *testing.T) {
+		_, err := MSort(nil, List(NewAtom("a")), NewAtom("a"), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeList, NewAtom("a"), nil), err)
+	})
+}
+
+func TestSort4(t *testing.T) {
+	f := NewAtom("f")
+	list := List(f.Apply(Integer(2), NewAtom("a")), f.Apply(Integer(1), NewAtom("b")), f.Apply(Integer(2), NewAtom("c")), f.Apply(Integer(1), NewAtom("d")))
+	sorted := NewVariable()
+
+	tests := []struct {
+		title            string
+		key, order, list Term
+		sorted           Term
+		ok               bool
+		err              error
+	}{
+		{title: "@< by argument", key: Integer(1), order: atomAtLessThan, list: list, sorted: List(f.Apply(Integer(1), NewAtom("b")), f.Apply(Integer(2), NewAtom("a"))), ok: true},
+		{title: "@=< by argument", key: Integer(1), order: atomAtLessOrEqual, list: list, sorted: List(f.Apply(Integer(1), NewAtom("b")), f.Apply(Integer(1), NewAtom("d")), f.Apply(Integer(2), NewAtom("a")), f.Apply(Integer(2), NewAtom("c"))), ok: true},
+		{title: "@> by argument", key: Integer(1), order: atomAtGreaterThan, list: list, sorted: List(f.Apply(Integer(2), NewAtom("a")), f.Apply(Integer(1), NewAtom("b"))), ok: true},
+		{title: "@>= by argument", key: Integer(1), order: atomAtGreaterOrEqual, list: list, sorted: List(f.Apply(Integer(2), NewAtom("a")), f.Apply(Integer(2), NewAtom("c")), f.Apply(Integer(1), NewAtom("b")), f.Apply(Integer(1), NewAtom("d"))), ok: true},
+		{title: "@< by whole term", key: Integer(0), order: atomAtLessThan, list: List(NewAtom("c"), NewAtom("a"), NewAtom("b"), NewAtom("a")), sorted: List(NewAtom("a"), NewAtom("b"), NewAtom("c")), ok: true},
+		{title: "@>= by whole term", key: Integer(0), order: atomAtGreaterOrEqual, list: List(NewAtom("c"), NewAtom("a"), NewAtom("b"), NewAtom("a")), sorted: List(NewAtom("c"), NewAtom("b"), NewAtom("a"), NewAtom("a")), ok: true},
+		{title: "empty list", key: Integer(1), order: atomAtLessThan, list: List(), sorted: List(), ok: true},
+
+		{title: "key is a variable", key: NewVariable(), order: atomAtLessThan, list: list, sorted: sorted, err: InstantiationError(nil)},
+		{title: "key is not an integer", key: NewAtom("a"), order: atomAtLessThan, list: list, sorted: sorted, err: typeError(validTypeInteger, NewAtom("a"), nil)},
+		{title: "key is negative", key: Integer(-1), order: atomAtLessThan, list: list, sorted: sorted, err: domainError(validDomainNotLessThanZero, Integer(-1), nil)},
+		{title: "order is a variable", key: Integer(1), order: NewVariable(), list: list, sorted: sorted, err: InstantiationError(nil)},
+		{title: "order is not an atom", key: Integer(1), order: Integer(1), list: list, sorted: sorted, err: typeError(validTypeAtom, Integer(1), nil)},
+		{title: "order is not an order", key: Integer(1), order: NewAtom("foo"), list: list, sorted: sorted, err: domainError(validDomainOrder, NewAtom("foo"), nil)},
+		{title: "list is a partial list", key: Integer(1), order: atomAtLessThan, list: PartialList(NewVariable(), f.Apply(Integer(1))), sorted: sorted, err: InstantiationError(nil)},
+		{title: "element is not a compound", key: Integer(1), order: atomAtLessThan, list: List(NewAtom("a")), sorted: sorted, err: typeError(validTypeCompound, NewAtom("a"), nil)},
+		{title: "element has fewer arguments than key", key: Integer(2), order: atomAtLessThan, list: List(f.Apply(Integer(1))), sorted: sorted, err: typeError(validTypeCompound, f.Apply(Integer(1)), nil)},
+		{title: "sorted is neither a partial list nor a list", key: Integer(1), order: atomAtLessThan, list: list, sorted: NewAtom("a"), err: typeError(validTypeList, NewAtom("a"), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := Sort4(nil, tt.key, tt.order, tt.list, tt.sorted, Success, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
 func TestKeySort(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		t.Run("variable", func(t *testing.T) {
@@ -2324,6 +3038,32 @@ func TestKeySort(t *testing.T) {
 			assert.NoError(t, err)
 			assert.True(t, ok)
 		})
+
+		t.Run("stable", func(t *testing.T) {
+			// Long enough not to be sorted by insertion sort alone.
+			var pairs, as, bs []Term
+			for i := 0; i < 50; i++ {
+				k := NewAtom("b")
+				if i%3 == 0 {
+					k = NewAtom("a")
+				}
+				p := pair(k, Integer(50-i))
+				pairs = append(pairs, p)
+				if k == NewAtom("a") {
+					as = append(as, p)
+				} else {
+					bs = append(bs, p)
+				}
+			}
+
+			sorted := NewVariable()
+			ok, err := KeySort(nil, List(pairs...), sorted, func(env *Env) *Promise {
+				assert.Equal(t, List(append(as, bs...)...), env.Resolve(sorted))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
 	})
 
 	t.Run("pairs is a partial list", func(t *testing.T) {
@@ -2389,6 +3129,7 @@ func TestCatch(t *testing.T) {
 	var vm VM
 	vm.Register2(atomEqual, Unify)
 	vm.Register1(NewAtom("throw"), Throw)
+	vm.Register3(NewAtom("catch"), Catch)
 	vm.Register0(atomTrue, func(_ *VM, k Cont, env *Env) *Promise {
 		return k(env)
 	})
@@ -2420,6 +3161,101 @@ func TestCatch(t *testing.T) {
 		assert.Equal(t, NewAtom("a"), ex.term)
 	})
 
+	t.Run("not match with a compound ball", func(t *testing.T) {
+		ball := atomError.Apply(NewAtom("foo").Apply(NewAtom("bar")), NewAtom("baz"))
+		ok, err := Catch(&vm, NewAtom("throw").Apply(ball), atomError.Apply(NewAtom("qux"), NewVariable()), atomTrue, Success, nil).Force(context.Background())
+		assert.False(t, ok)
+		assert.Equal(t, NewException(ball, nil), err)
+	})
+
+	t.Run("not match then caught outside", func(t *testing.T) {
+		ball := NewAtom("foo").Apply(NewAtom("bar"))
+		v := NewVariable()
+		ok, err := Catch(&vm, NewAtom("catch").Apply(NewAtom("throw").Apply(ball), NewAtom("b"), atomFail), v, atomTrue, func(env *Env) *Promise {
+			assert.Equal(t, ball, env.Resolve(v))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("recover runs with catcher unified", func(t *testing.T) {
+		v := NewVariable()
+		ok, err := Catch(&vm, NewAtom("throw").Apply(NewAtom("f").Apply(NewAtom("a"))), NewAtom("f").Apply(v), atomTrue, func(env *Env) *Promise {
+			assert.Equal(t, NewAtom("a"), env.Resolve(v))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("bindings by goal are undone", func(t *testing.T) {
+		x := NewVariable()
+		goal := atomComma.Apply(atomEqual.Apply(x, NewAtom("a")), NewAtom("throw").Apply(NewAtom("b")))
+		ok, err := Catch(&vm, goal, NewAtom("b"), atomTrue, func(env *Env) *Promise {
+			assert.Equal(t, x, env.Resolve(x))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("bindings by goal are undone but the ball keeps them", func(t *testing.T) {
+		x, y := NewVariable(), NewVariable()
+		goal := atomComma.Apply(atomEqual.Apply(x, NewAtom("a")), NewAtom("throw").Apply(NewAtom("f").Apply(x)))
+		ok, err := Catch(&vm, goal, NewAtom("f").Apply(y), atomTrue, func(env *Env) *Promise {
+			assert.Equal(t, x, env.Resolve(x))
+			assert.Equal(t, NewAtom("a"), env.Resolve(y))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("variable bound by goal then by catcher", func(t *testing.T) {
+		x := NewVariable()
+		goal := atomComma.Apply(atomEqual.Apply(x, NewAtom("a")), NewAtom("throw").Apply(NewAtom("b")))
+		ok, err := Catch(&vm, goal, x, atomTrue, func(env *Env) *Promise {
+			assert.Equal(t, NewAtom("b"), env.Resolve(x))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("recover throws", func(t *testing.T) {
+		ok, err := Catch(&vm, NewAtom("throw").Apply(NewAtom("a")), NewVariable(), NewAtom("throw").Apply(NewAtom("b")), Success, nil).Force(context.Background())
+		assert.False(t, ok)
+		assert.Equal(t, NewException(NewAtom("b"), nil), err)
+	})
+
+	t.Run("current input/output changed before throw", func(t *testing.T) {
+		var vm VM
+		vm.Register1(NewAtom("throw"), Throw)
+		vm.Register1(NewAtom("set_input"), SetInput)
+		vm.Register1(NewAtom("set_output"), SetOutput)
+		in, out := &Stream{mode: ioModeRead}, &Stream{mode: ioModeWrite}
+
+		goal := atomComma.Apply(
+			NewAtom("set_input").Apply(in),
+			atomComma.Apply(NewAtom("set_output").Apply(out), NewAtom("throw").Apply(NewAtom("a"))),
+		)
+		recover := NewAtom("check").Apply(NewAtom("recover"))
+		var calls []string
+		vm.Register1(NewAtom("check"), func(vm *VM, where Term, k Cont, env *Env) *Promise {
+			assert.True(t, vm.input == in)
+			assert.True(t, vm.output == out)
+			calls = append(calls, where.(Atom).String())
+			return k(env)
+		})
+		ok, err := Catch(&vm, goal, NewAtom("a"), recover, func(env *Env) *Promise {
+			return Call(&vm, NewAtom("check").Apply(NewAtom("continuation")), Success, env)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []string{"recover", "continuation"}, calls)
+	})
+
 	t.Run("true", func(t *testing.T) {
 		ok, err := Catch(&vm, atomTrue, NewAtom("b"), atomFail, Success, nil).Force(context.Background())
 		assert.NoError(t, err)
@@ -2439,6 +3275,52 @@ func TestCatch(t *testing.T) {
 		assert.Error(t, err)
 		assert.False(t, ok)
 	})
+
+	t.Run("panic", func(t *testing.T) {
+		var (
+			vm       VM
+			panicked []interface{}
+		)
+		vm.Register1(NewAtom("throw"), Throw)
+		vm.Register0(atomTrue, func(_ *VM, k Cont, env *Env) *Promise {
+			return k(env)
+		})
+		vm.Register0(NewAtom("do_not_call"), func(*VM, Cont, *Env) *Promise {
+			panic("told you")
+		})
+		vm.Register3(NewAtom("catch"), Catch)
+		vm.Panic = func(r interface{}) {
+			panicked = append(panicked, r)
+		}
+
+		t.Run("not caught", func(t *testing.T) {
+			panicked = nil
+			ok, err := Catch(&vm, NewAtom("do_not_call"), NewVariable(), atomTrue, Success, nil).Force(context.Background())
+			assert.Equal(t, &panicError{value: "told you", reported: true}, err)
+			assert.False(t, ok)
+			assert.Equal(t, []interface{}{"told you"}, panicked)
+		})
+
+		t.Run("nested", func(t *testing.T) {
+			panicked = nil
+			ok, err := Catch(&vm, NewAtom("catch").Apply(NewAtom("do_not_call"), NewVariable(), atomTrue), NewVariable(), atomTrue, Success, nil).Force(context.Background())
+			assert.Equal(t, &panicError{value: "told you", reported: true}, err)
+			assert.False(t, ok)
+			assert.Equal(t, []interface{}{"told you"}, panicked)
+		})
+
+		t.Run("thrown ball is caught", func(t *testing.T) {
+			panicked = nil
+			v := NewVariable()
+			ok, err := Catch(&vm, NewAtom("throw").Apply(NewAtom("a")), v, atomTrue, func(env *Env) *Promise {
+				assert.Equal(t, NewAtom("a"), env.Resolve(v))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Empty(t, panicked)
+		})
+	})
 }
 
 func TestCurrentPredicate(t *testing.T) {
@@ -2612,6 +3494,34 @@ func TestAssertz(t *testing.T) {
 		}])
 	})
 
+	t.Run("module qualified", func(t *testing.T) {
+		foo := NewAtom("foo")
+		var vm VM
+		for _, c := range []Term{
+			atomColon.Apply(atomUser, foo.Apply(NewAtom("a"))),
+			atomColon.Apply(atomSystem, atomColon.Apply(atomUser, foo.Apply(NewAtom("b")))),
+			atomColon.Apply(atomUser, atomIf.Apply(foo.Apply(NewAtom("c")), atomTrue)),
+			atomIf.Apply(atomColon.Apply(atomUser, foo.Apply(NewAtom("d"))), atomTrue),
+		} {
+			ok, err := Assertz(&vm, c, Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}
+
+		assert.Len(t, vm.procedures, 1)
+		u := vm.procedures[procedureIndicator{name: foo, arity: 1}].(*userDefined)
+		var raws []Term
+		for _, c := range u.clauses {
+			raws = append(raws, c.raw)
+		}
+		assert.Equal(t, []Term{
+			foo.Apply(NewAtom("a")),
+			foo.Apply(NewAtom("b")),
+			atomIf.Apply(foo.Apply(NewAtom("c")), atomTrue),
+			atomIf.Apply(foo.Apply(NewAtom("d")), atomTrue),
+		}, raws)
+	})
+
 	t.Run("clause is a variable", func(t *testing.T) {
 		var vm VM
 		ok, err := Assertz(&vm, NewVariable(), Success, nil).Force(context.Background())
@@ -2707,7 +3617,9 @@ func TestAsserta(t *testing.T) {
 		assert.NoError(t, err)
 		assert.True(t, ok)
 
-		assert.Equal(t, &userDefined{dynamic: true, clauses: []clause{
+		u := vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined)
+		assert.True(t, u.dynamic)
+		assert.Equal(t, clauses{
 			{
 				pi: procedureIndicator{name: NewAtom("foo"), arity: 1},
 				raw: &compound{
@@ -2730,7 +3642,7 @@ func TestAsserta(t *testing.T) {
 					{opcode: opExit},
 				},
 			},
-		}}, vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}])
+		}, u.clauses)
 	})
 
 	t.Run("rule", func(t *testing.T) {
@@ -2758,7 +3670,9 @@ func TestAsserta(t *testing.T) {
 		assert.NoError(t, err)
 		assert.True(t, ok)
 
-		assert.Equal(t, &userDefined{dynamic: true, clauses: []clause{
+		u := vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 0}].(*userDefined)
+		assert.True(t, u.dynamic)
+		assert.Equal(t, clauses{
 			{
 				pi: procedureIndicator{name: NewAtom("foo"), arity: 0},
 				raw: &compound{
@@ -2804,7 +3718,7 @@ func TestAsserta(t *testing.T) {
 					{opcode: opExit},
 				},
 			},
-		}}, vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 0}])
+		}, u.clauses)
 	})
 
 	t.Run("clause is a variable", func(t *testing.T) {
@@ -2904,6 +3818,72 @@ func TestAsserta(t *testing.T) {
 		assert.NoError(t, err)
 		assert.True(t, ok)
 	})
+
+	t.Run("many", func(t *testing.T) {
+		foo := NewAtom("foo")
+		pi := procedureIndicator{name: foo, arity: 1}
+		var vm VM
+		var want []Term
+		for i := 0; i < 100; i++ {
+			c := foo.Apply(Integer(i))
+			switch i % 3 {
+			case 0, 1:
+				_, err := Asserta(&vm, c, Success, nil).Force(context.Background())
+				assert.NoError(t, err)
+				want = append([]Term{c}, want...)
+			default:
+				_, err := Assertz(&vm, c, Success, nil).Force(context.Background())
+				assert.NoError(t, err)
+				want = append(want, c)
+			}
+			if i%10 == 9 {
+				_, err := Retract(&vm, want[0], Success, nil).Force(context.Background())
+				assert.NoError(t, err)
+				want = want[1:]
+			}
+		}
+
+		var got []Term
+		for _, c := range vm.procedures[pi].(*userDefined).clauses {
+			got = append(got, c.raw)
+		}
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("logical update view", func(t *testing.T) {
+		foo := NewAtom("foo")
+		var vm VM
+		_, err := Asserta(&vm, foo.Apply(Integer(0)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+
+		// Clauses added while foo/1 is running aren't seen by the running call.
+		x := NewVariable()
+		var got []Term
+		_, err = vm.Arrive(foo, []Term{x}, func(env *Env) *Promise {
+			got = append(got, env.Resolve(x))
+			return Asserta(&vm, foo.Apply(Integer(len(got))), Failure, env)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []Term{Integer(0)}, got)
+		assert.Len(t, vm.procedures[procedureIndicator{name: foo, arity: 1}].(*userDefined).clauses, 2)
+	})
+}
+
+func BenchmarkAsserta(b *testing.B) {
+	foo := NewAtom("foo")
+	clauses := make([]Term, 100000)
+	for i := range clauses {
+		clauses[i] = foo.Apply(Integer(i))
+	}
+
+	for i := 0; i < b.N; i++ {
+		var vm VM
+		for _, c := range clauses {
+			if _, err := Asserta(&vm, c, Success, nil).Force(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
 }
 
 func TestRetract(t *testing.T) {
@@ -3036,32 +4016,111 @@ func TestRetract(t *testing.T) {
 		// removed
 		assert.Empty(t, vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined).clauses)
 	})
-}
 
-func TestAbolish(t *testing.T) {
-	t.Run("ok", func(t *testing.T) {
-		vm := VM{
-			procedures: map[procedureIndicator]procedure{
-				{name: NewAtom("foo"), arity: 1}: &userDefined{dynamic: true, clauses: []clause{
-					{raw: &compound{functor: NewAtom("foo"), args: []Term{NewAtom("a")}}},
-					{raw: &compound{functor: NewAtom("foo"), args: []Term{NewAtom("b")}}},
-					{raw: &compound{functor: NewAtom("foo"), args: []Term{NewAtom("c")}}},
-				}},
-			},
+	t.Run("exception in continuation after some retractions", func(t *testing.T) {
+		var vm VM
+		for _, a := range []string{"a", "b", "c", "d"} {
+			_, err := Assertz(&vm, NewAtom("foo").Apply(NewAtom(a)), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
 		}
 
-		ok, err := Abolish(&vm, &compound{
-			functor: atomSlash,
-			args:    []Term{NewAtom("foo"), Integer(1)},
-		}, Success, nil).Force(context.Background())
-		assert.NoError(t, err)
-		assert.True(t, ok)
-
-		_, ok = vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}]
+		var n int
+		ok, err := Retract(&vm, NewAtom("foo").Apply(NewVariable()), func(*Env) *Promise {
+			n++
+			if n == 2 {
+				return Error(errors.New("failed"))
+			}
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.Error(t, err)
 		assert.False(t, ok)
+
+		u := vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined)
+		assert.Len(t, u.clauses, 2)
+		assert.Equal(t, NewAtom("foo").Apply(NewAtom("c")), u.clauses[0].raw)
+		assert.Equal(t, NewAtom("foo").Apply(NewAtom("d")), u.clauses[1].raw)
 	})
 
-	t.Run("pi is a variable", func(t *testing.T) {
+	t.Run("continuation modifies the procedure", func(t *testing.T) {
+		var vm VM
+		for _, a := range []string{"a", "b", "c"} {
+			_, err := Assertz(&vm, NewAtom("foo").Apply(NewAtom(a)), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+		}
+
+		ok, err := Retract(&vm, NewAtom("foo").Apply(NewVariable()), func(env *Env) *Promise {
+			return Asserta(&vm, NewAtom("foo").Apply(NewAtom("z")), Failure, env)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+
+		u := vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined)
+		assert.Len(t, u.clauses, 3)
+		for _, c := range u.clauses {
+			assert.Equal(t, NewAtom("foo").Apply(NewAtom("z")), c.raw)
+		}
+	})
+
+	t.Run("clause retracted by the continuation", func(t *testing.T) {
+		var vm VM
+		for _, a := range []string{"a", "b"} {
+			_, err := Assertz(&vm, NewAtom("foo").Apply(NewAtom(a)), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+		}
+
+		var n int
+		ok, err := Retract(&vm, NewAtom("foo").Apply(NewVariable()), func(env *Env) *Promise {
+			n++
+			return Retract(&vm, NewAtom("foo").Apply(NewAtom("b")), Failure, env)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, 1, n)
+		assert.Empty(t, vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined).clauses)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		var vm VM
+		for i := 0; i < 3; i++ {
+			_, err := Assertz(&vm, NewAtom("foo").Apply(Integer(i)), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ok, err := Retract(&vm, NewAtom("foo").Apply(NewVariable()), func(*Env) *Promise {
+			cancel()
+			return Bool(false)
+		}, nil).Force(ctx)
+		assert.Equal(t, context.Canceled, err)
+		assert.False(t, ok)
+		assert.Len(t, vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined).clauses, 2)
+	})
+}
+
+func TestAbolish(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		vm := VM{
+			procedures: map[procedureIndicator]procedure{
+				{name: NewAtom("foo"), arity: 1}: &userDefined{dynamic: true, clauses: []clause{
+					{raw: &compound{functor: NewAtom("foo"), args: []Term{NewAtom("a")}}},
+					{raw: &compound{functor: NewAtom("foo"), args: []Term{NewAtom("b")}}},
+					{raw: &compound{functor: NewAtom("foo"), args: []Term{NewAtom("c")}}},
+				}},
+			},
+		}
+
+		ok, err := Abolish(&vm, &compound{
+			functor: atomSlash,
+			args:    []Term{NewAtom("foo"), Integer(1)},
+		}, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		_, ok = vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}]
+		assert.False(t, ok)
+	})
+
+	t.Run("pi is a variable", func(t *testing.T) {
 		var vm VM
 		ok, err := Abolish(&vm, NewVariable(), Success, nil).Force(context.Background())
 		assert.Equal(t, InstantiationError(nil), err)
@@ -3152,6 +4211,38 @@ func TestAbolish(t *testing.T) {
 		}, nil), err)
 		assert.False(t, ok)
 	})
+
+	t.Run("The predicate indicator pi is that of a built-in predicate", func(t *testing.T) {
+		vm := VM{
+			procedures: map[procedureIndicator]procedure{
+				{name: NewAtom("foo"), arity: 1}: Predicate1(func(_ *VM, _ Term, k Cont, env *Env) *Promise {
+					return k(env)
+				}),
+			},
+		}
+		ok, err := Abolish(&vm, &compound{
+			functor: atomSlash,
+			args:    []Term{NewAtom("foo"), Integer(1)},
+		}, Success, nil).Force(context.Background())
+		assert.Equal(t, permissionError(operationModify, permissionTypeStaticProcedure, &compound{
+			functor: atomSlash,
+			args:    []Term{NewAtom("foo"), Integer(1)},
+		}, nil), err)
+		assert.False(t, ok)
+
+		_, ok = vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}]
+		assert.True(t, ok)
+	})
+
+	t.Run("The predicate indicator pi is that of an undefined procedure", func(t *testing.T) {
+		var vm VM
+		ok, err := Abolish(&vm, &compound{
+			functor: atomSlash,
+			args:    []Term{NewAtom("foo"), Integer(0)},
+		}, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
 }
 
 func TestCurrentInput(t *testing.T) {
@@ -3172,6 +4263,27 @@ func TestCurrentInput(t *testing.T) {
 		assert.Equal(t, domainError(validDomainStream, Integer(0), nil), err)
 		assert.False(t, ok)
 	})
+
+	t.Run("default", func(t *testing.T) {
+		vm := NewVM()
+		s := NewVariable()
+		ok, err := CurrentInput(vm, s, func(env *Env) *Promise {
+			s, ok := env.Resolve(s).(*Stream)
+			assert.True(t, ok)
+			assert.Equal(t, atomUserInput, s.alias)
+			assert.True(t, s.source == os.Stdin)
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("no current input stream", func(t *testing.T) {
+		var vm VM
+		ok, err := CurrentInput(&vm, NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, existenceError(objectTypeStream, atomUserInput, nil), err)
+		assert.False(t, ok)
+	})
 }
 
 func TestCurrentOutput(t *testing.T) {
@@ -3192,6 +4304,27 @@ func TestCurrentOutput(t *testing.T) {
 		assert.Equal(t, domainError(validDomainStream, Integer(0), nil), err)
 		assert.False(t, ok)
 	})
+
+	t.Run("default", func(t *testing.T) {
+		vm := NewVM()
+		s := NewVariable()
+		ok, err := CurrentOutput(vm, s, func(env *Env) *Promise {
+			s, ok := env.Resolve(s).(*Stream)
+			assert.True(t, ok)
+			assert.Equal(t, atomUserOutput, s.alias)
+			assert.True(t, s.sink == os.Stdout)
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("no current output stream", func(t *testing.T) {
+		var vm VM
+		ok, err := CurrentOutput(&vm, NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, existenceError(objectTypeStream, atomUserOutput, nil), err)
+		assert.False(t, ok)
+	})
 }
 
 func TestSetInput(t *testing.T) {
@@ -3339,6 +4472,22 @@ func TestOpen(t *testing.T) {
 			assert.True(t, ok)
 		})
 
+		t.Run("type binary then peek_byte and get_byte", func(t *testing.T) {
+			v := NewVariable()
+			ok, err := Open(&vm, NewAtom(f.Name()), atomRead, v, List(&compound{
+				functor: atomType,
+				args:    []Term{atomBinary},
+			}), func(env *Env) *Promise {
+				return PeekByte(&vm, v, Integer('t'), func(env *Env) *Promise {
+					return GetByte(&vm, v, Integer('t'), func(env *Env) *Promise {
+						return GetByte(&vm, v, Integer('e'), Success, env)
+					}, env)
+				}, env)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+
 		t.Run("reposition true", func(t *testing.T) {
 			v := NewVariable()
 			ok, err := Open(&vm, NewAtom(f.Name()), atomRead, v, List(&compound{
@@ -3424,6 +4573,52 @@ func TestOpen(t *testing.T) {
 			assert.True(t, ok)
 		})
 
+		t.Run("auto_flush true", func(t *testing.T) {
+			v := NewVariable()
+			ok, err := Open(&vm, NewAtom(f.Name()), atomRead, v, List(&compound{
+				functor: atomAutoFlush,
+				args:    []Term{atomTrue},
+			}), func(env *Env) *Promise {
+				ref, ok := env.lookup(v)
+				assert.True(t, ok)
+				s, ok := ref.(*Stream)
+				assert.True(t, ok)
+				assert.True(t, s.autoFlush)
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+
+		t.Run("sync", func(t *testing.T) {
+			for _, o := range []struct {
+				options Term
+				sync    bool
+			}{
+				{options: List(), sync: false},
+				{options: List(atomSync.Apply(atomTrue)), sync: true},
+				{options: List(atomSync.Apply(atomFalse)), sync: false},
+			} {
+				v := NewVariable()
+				ok, err := Open(&vm, NewAtom(f.Name()), atomAppend, v, o.options, func(env *Env) *Promise {
+					s := env.Resolve(v).(*Stream)
+					assert.Equal(t, o.sync, s.sync)
+					return StreamProperty(&vm, s, atomSync.Apply(map[bool]Atom{true: atomTrue, false: atomFalse}[o.sync]), func(*Env) *Promise {
+						return Close(&vm, s, List(), Success, env)
+					}, env)
+				}, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.True(t, ok)
+			}
+		})
+
+		t.Run("sync with a non-boolean", func(t *testing.T) {
+			o := atomSync.Apply(NewAtom("foo"))
+			ok, err := Open(&vm, NewAtom(f.Name()), atomAppend, NewVariable(), List(o), Success, nil).Force(context.Background())
+			assert.Equal(t, domainError(validDomainStreamOption, o, nil), err)
+			assert.False(t, ok)
+		})
+
 		t.Run("unknown option", func(t *testing.T) {
 			v := NewVariable()
 			ok, err := Open(&vm, NewAtom(f.Name()), atomRead, v, List(&compound{
@@ -3563,6 +4758,62 @@ func TestOpen(t *testing.T) {
 		assert.True(t, ok)
 	})
 
+	t.Run("standard streams", func(t *testing.T) {
+		var out, errOut bytes.Buffer
+		var vm VM
+		vm.SetUserInput(NewInputTextStream(strings.NewReader("")))
+		vm.SetUserOutput(NewOutputTextStream(&out))
+		vm.SetUserError(NewOutputTextStream(&errOut))
+
+		t.Run("user_output", func(t *testing.T) {
+			v := NewVariable()
+			ok, err := Open(&vm, atomUserOutput, atomWrite, v, List(), func(env *Env) *Promise {
+				return PutChar(&vm, v, NewAtom("a"), Success, env)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, "a", out.String())
+		})
+
+		tests := []struct {
+			sourceSink, mode Term
+			alias            Atom
+		}{
+			{sourceSink: atomUserInput, mode: atomRead, alias: atomUserInput},
+			{sourceSink: atomUserOutput, mode: atomAppend, alias: atomUserOutput},
+			{sourceSink: atomUserError, mode: atomWrite, alias: atomUserError},
+			{sourceSink: atomUser, mode: atomRead, alias: atomUserInput},
+			{sourceSink: atomUser, mode: atomWrite, alias: atomUserOutput},
+		}
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s %s", tt.sourceSink, tt.mode), func(t *testing.T) {
+				want, ok := vm.streams.lookup(tt.alias)
+				assert.True(t, ok)
+
+				v := NewVariable()
+				ok, err := Open(&vm, tt.sourceSink, tt.mode, v, List(), func(env *Env) *Promise {
+					assert.True(t, env.Resolve(v) == want)
+					return Bool(true)
+				}, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.True(t, ok)
+			})
+		}
+
+		t.Run("wrong mode", func(t *testing.T) {
+			ok, err := Open(&vm, atomUserInput, atomWrite, NewVariable(), List(), Success, nil).Force(context.Background())
+			assert.Equal(t, permissionError(operationOpen, permissionTypeSourceSink, atomUserInput, nil), err)
+			assert.False(t, ok)
+		})
+
+		t.Run("not registered", func(t *testing.T) {
+			var vm VM
+			ok, err := Open(&vm, atomUserError, atomWrite, NewVariable(), List(), Success, nil).Force(context.Background())
+			assert.Equal(t, existenceError(objectTypeSourceSink, atomUserError, nil), err)
+			assert.False(t, ok)
+		})
+	})
+
 	t.Run("sourceSink is a variable", func(t *testing.T) {
 		var vm VM
 		ok, err := Open(&vm, NewVariable(), atomRead, NewVariable(), List(), Success, nil).Force(context.Background())
@@ -3776,6 +5027,27 @@ func TestClose(t *testing.T) {
 		})
 	})
 
+	t.Run("standard stream", func(t *testing.T) {
+		var m struct {
+			mockWriter
+			mockCloser
+		}
+		defer m.mockCloser.AssertExpectations(t)
+
+		var vm VM
+		vm.SetUserOutput(&Stream{sink: &m, mode: ioModeWrite})
+		s := NewVariable()
+		ok, err := Open(&vm, atomUserOutput, atomWrite, s, List(), func(env *Env) *Promise {
+			return Close(&vm, s, List(), Success, env)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		u, ok := vm.streams.lookup(atomUserOutput)
+		assert.True(t, ok)
+		assert.Equal(t, vm.output, u)
+	})
+
 	t.Run("force false", func(t *testing.T) {
 		var m struct {
 			mockWriter
@@ -3925,7 +5197,7 @@ func TestFlushOutput(t *testing.T) {
 		m.mockSyncer.On("Sync").Return(errors.New("ng")).Once()
 		defer m.mockSyncer.AssertExpectations(t)
 
-		s := &Stream{sink: &m, mode: ioModeWrite}
+		s := &Stream{sink: &m, mode: ioModeWrite, sync: true}
 
 		var vm VM
 		_, err := FlushOutput(&vm, s, Success, nil).Force(context.Background())
@@ -3969,6 +5241,46 @@ func TestFlushOutput(t *testing.T) {
 		assert.Equal(t, permissionError(operationOutput, permissionTypeStream, s, nil), err)
 		assert.False(t, ok)
 	})
+
+	t.Run("file opened by open/4", func(t *testing.T) {
+		f, err := os.CreateTemp("", "flush_output_test")
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+		defer func() {
+			assert.NoError(t, os.Remove(f.Name()))
+		}()
+
+		var vm VM
+		v := NewVariable()
+		ok, err := Open(&vm, NewAtom(f.Name()), atomWrite, v, List(atomSync.Apply(atomTrue)), func(env *Env) *Promise {
+			s := env.Resolve(v).(*Stream)
+			assert.True(t, s.sync)
+			return PutChar(&vm, s, NewAtom("a"), func(env *Env) *Promise {
+				return FlushOutput(&vm, s, func(env *Env) *Promise {
+					b, err := os.ReadFile(f.Name())
+					assert.NoError(t, err)
+					assert.Equal(t, "a", string(b))
+					return Close(&vm, s, List(), Success, env)
+				}, env)
+			}, env)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("pipe", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		assert.NoError(t, err)
+		defer func() {
+			assert.NoError(t, r.Close())
+			assert.NoError(t, w.Close())
+		}()
+
+		var vm VM
+		ok, err := FlushOutput(&vm, NewOutputTextStream(w), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
 }
 
 func TestWriteTerm(t *testing.T) {
@@ -4077,6 +5389,29 @@ func TestWriteTerm(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("auto_flush", func(t *testing.T) {
+		t.Run("true", func(t *testing.T) {
+			var buf bytes.Buffer
+			s := NewOutputTextStream(bufio.NewWriter(&buf))
+			s.SetAutoFlush(true)
+
+			ok, err := WriteTerm(&vm, s, NewAtom("foo"), List(), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, "foo", buf.String())
+		})
+
+		t.Run("false", func(t *testing.T) {
+			var buf bytes.Buffer
+			s := NewOutputTextStream(bufio.NewWriter(&buf))
+
+			ok, err := WriteTerm(&vm, s, NewAtom("foo"), List(), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, "", buf.String())
+		})
+	})
 }
 
 type mockTerm struct {
@@ -4112,6 +5447,22 @@ func TestCharCode(t *testing.T) {
 		assert.True(t, ok)
 	})
 
+	t.Run("inconsistent", func(t *testing.T) {
+		ok, err := CharCode(nil, NewAtom("a"), Integer(98), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("query ascii code", func(t *testing.T) {
+		v := NewVariable()
+		ok, err := CharCode(nil, NewAtom("a"), v, func(env *Env) *Promise {
+			assert.Equal(t, Integer(97), env.Resolve(v))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
 	t.Run("query char", func(t *testing.T) {
 		v := NewVariable()
 
@@ -4176,23 +5527,134 @@ func TestCharCode(t *testing.T) {
 	})
 }
 
-func TestPutByte(t *testing.T) {
-	t.Run("ok", func(t *testing.T) {
-		var m mockWriter
-		m.On("Write", []byte{97}).Return(1, nil).Once()
-		defer m.AssertExpectations(t)
+func TestCharType(t *testing.T) {
+	v := NewVariable()
+	tests := []struct {
+		title     string
+		char, typ Term
+		ok        bool
+		err       error
+		mem       map[Variable]Term
+	}{
+		{title: "alnum", char: NewAtom("a"), typ: atomAlnum, ok: true},
+		{title: "alnum digit", char: NewAtom("1"), typ: atomAlnum, ok: true},
+		{title: "alnum underscore", char: NewAtom("_"), typ: atomAlnum, ok: false},
+		{title: "alpha", char: NewAtom("é"), typ: atomAlpha, ok: true},
+		{title: "alpha underscore", char: NewAtom("_"), typ: atomAlpha, ok: true},
+		{title: "alpha punct", char: NewAtom("-"), typ: atomAlpha, ok: false},
+		{title: "csym", char: NewAtom("9"), typ: atomCsym, ok: true},
+		{title: "csymf", char: NewAtom("9"), typ: atomCsymf, ok: false},
+		{title: "ascii", char: NewAtom("~"), typ: atomASCII, ok: true},
+		{title: "not ascii", char: NewAtom("日"), typ: atomASCII, ok: false},
+		{title: "white", char: NewAtom("\t"), typ: atomWhite, ok: true},
+		{title: "white newline", char: NewAtom("\n"), typ: atomWhite, ok: false},
+		{title: "space", char: NewAtom("\n"), typ: atomSpace, ok: true},
+		{title: "end_of_line", char: NewAtom("\r"), typ: atomEndOfLine, ok: true},
+		{title: "cntrl", char: NewAtom("\x7f"), typ: atomCntrl, ok: true},
+		{title: "digit", char: NewAtom("7"), typ: atomDigit, ok: true},
+		{title: "digit letter", char: NewAtom("a"), typ: atomDigit, ok: false},
+		{title: "digit weight", char: NewAtom("7"), typ: atomDigit.Apply(v), ok: true, mem: map[Variable]Term{v: Integer(7)}},
+		{title: "digit weight of a non-ascii digit", char: NewAtom("٣"), typ: atomDigit.Apply(v), ok: true, mem: map[Variable]Term{v: Integer(3)}},
+		{title: "digit weight mismatch", char: NewAtom("7"), typ: atomDigit.Apply(Integer(8)), ok: false},
+		{title: "digit weight of a letter", char: NewAtom("a"), typ: atomDigit.Apply(v), ok: false},
+		{title: "xdigit", char: NewAtom("F"), typ: atomXdigit.Apply(v), ok: true, mem: map[Variable]Term{v: Integer(15)}},
+		{title: "xdigit non hex", char: NewAtom("g"), typ: atomXdigit.Apply(v), ok: false},
+		{title: "graph", char: NewAtom("!"), typ: atomGraph, ok: true},
+		{title: "graph space", char: NewAtom(" "), typ: atomGraph, ok: false},
+		{title: "print space", char: NewAtom(" "), typ: atomPrint, ok: true},
+		{title: "punct", char: NewAtom("+"), typ: atomPunct, ok: true},
+		{title: "punct letter", char: NewAtom("a"), typ: atomPunct, ok: false},
+		{title: "period", char: NewAtom("?"), typ: atomPeriod, ok: true},
+		{title: "quote", char: NewAtom("`"), typ: atomQuote, ok: true},
+		{title: "paren", char: NewAtom(")"), typ: atomParen, ok: true},
+		{title: "lower", char: NewAtom("a"), typ: atomLower, ok: true},
+		{title: "lower upper", char: NewAtom("a"), typ: atomLower.Apply(v), ok: true, mem: map[Variable]Term{v: NewAtom("A")}},
+		{title: "lower of an uppercase letter", char: NewAtom("A"), typ: atomLower.Apply(v), ok: false},
+		{title: "upper", char: NewAtom("Ä"), typ: atomUpper.Apply(v), ok: true, mem: map[Variable]Term{v: NewAtom("ä")}},
+		{title: "upper of a digit", char: NewAtom("1"), typ: atomUpper, ok: false},
+		{title: "to_lower", char: NewAtom("A"), typ: atomToLower.Apply(v), ok: true, mem: map[Variable]Term{v: NewAtom("a")}},
+		{title: "to_lower of a non-letter", char: NewAtom("1"), typ: atomToLower.Apply(v), ok: true, mem: map[Variable]Term{v: NewAtom("1")}},
+		{title: "to_upper", char: NewAtom("a"), typ: atomToUpper.Apply(NewAtom("A")), ok: true},
+
+		{title: "char is a variable", char: NewVariable(), typ: atomAlpha, err: InstantiationError(nil)},
+		{title: "char is not a character", char: NewAtom("ab"), typ: atomAlpha, err: typeError(validTypeCharacter, NewAtom("ab"), nil)},
+		{title: "char is not an atom", char: Integer(1), typ: atomAlpha, err: typeError(validTypeCharacter, Integer(1), nil)},
+		{title: "type is a variable", char: NewAtom("a"), typ: NewVariable(), err: InstantiationError(nil)},
+		{title: "unknown type", char: NewAtom("a"), typ: NewAtom("foo"), err: domainError(validDomainCharType, NewAtom("foo"), nil)},
+		{title: "unknown compound type", char: NewAtom("a"), typ: NewAtom("foo").Apply(NewAtom("a")), err: domainError(validDomainCharType, NewAtom("foo").Apply(NewAtom("a")), nil)},
+		{title: "type of wrong arity", char: NewAtom("a"), typ: atomUpper.Apply(NewAtom("a"), NewAtom("b")), err: domainError(validDomainCharType, atomUpper.Apply(NewAtom("a"), NewAtom("b")), nil)},
+		{title: "type is neither an atom nor a compound", char: NewAtom("a"), typ: Integer(1), err: domainError(validDomainCharType, Integer(1), nil)},
+	}
 
-		s := &Stream{sink: &m, mode: ioModeWrite, streamType: streamTypeBinary}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := CharType(nil, tt.char, tt.typ, func(env *Env) *Promise {
+				for k, v := range tt.mem {
+					assert.Equal(t, v, env.Resolve(k))
+				}
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
 
-		var vm VM
-		ok, err := PutByte(&vm, s, Integer(97), Success, nil).Force(context.Background())
-		assert.NoError(t, err)
-		assert.True(t, ok)
-	})
+func TestCodeType(t *testing.T) {
+	v := NewVariable()
+	tests := []struct {
+		title     string
+		code, typ Term
+		ok        bool
+		err       error
+		mem       map[Variable]Term
+	}{
+		{title: "alpha", code: Integer('a'), typ: atomAlpha, ok: true},
+		{title: "space", code: Integer('a'), typ: atomSpace, ok: false},
+		{title: "digit weight", code: Integer('7'), typ: atomDigit.Apply(v), ok: true, mem: map[Variable]Term{v: Integer(7)}},
+		{title: "upper", code: Integer('A'), typ: atomUpper.Apply(v), ok: true, mem: map[Variable]Term{v: Integer('a')}},
+		{title: "to_upper", code: Integer('a'), typ: atomToUpper.Apply(Integer('A')), ok: true},
+		{title: "to_lower", code: Integer('日'), typ: atomToLower.Apply(v), ok: true, mem: map[Variable]Term{v: Integer('日')}},
+
+		{title: "code is a variable", code: NewVariable(), typ: atomAlpha, err: InstantiationError(nil)},
+		{title: "code is not an integer", code: NewAtom("a"), typ: atomAlpha, err: typeError(validTypeInteger, NewAtom("a"), nil)},
+		{title: "code is negative", code: Integer(-1), typ: atomAlpha, err: representationError(flagCharacterCode, nil)},
+		{title: "code is too large", code: Integer(utf8.MaxRune + 1), typ: atomAlpha, err: representationError(flagCharacterCode, nil)},
+		{title: "code overflows a rune", code: Integer(1<<32 + 'a'), typ: atomAlpha, err: representationError(flagCharacterCode, nil)},
+		{title: "unknown type", code: Integer('a'), typ: NewAtom("foo"), err: domainError(validDomainCharType, NewAtom("foo"), nil)},
+	}
 
-	t.Run("ng", func(t *testing.T) {
-		var m mockWriter
-		m.On("Write", []byte{97}).Return(0, errors.New("")).Once()
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := CodeType(nil, tt.code, tt.typ, func(env *Env) *Promise {
+				for k, v := range tt.mem {
+					assert.Equal(t, v, env.Resolve(k))
+				}
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestPutByte(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var m mockWriter
+		m.On("Write", []byte{97}).Return(1, nil).Once()
+		defer m.AssertExpectations(t)
+
+		s := &Stream{sink: &m, mode: ioModeWrite, streamType: streamTypeBinary}
+
+		var vm VM
+		ok, err := PutByte(&vm, s, Integer(97), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("ng", func(t *testing.T) {
+		var m mockWriter
+		m.On("Write", []byte{97}).Return(0, errors.New("")).Once()
 		defer m.AssertExpectations(t)
 
 		s := &Stream{sink: &m, mode: ioModeWrite, streamType: streamTypeBinary}
@@ -4306,6 +5768,13 @@ func TestPutChar(t *testing.T) {
 				assert.Equal(t, "qwerA", sb.String())
 			}
 		}, char: NewAtom("A"), ok: true},
+		{title: "multi-byte character", streamOrAlias: func() (Term, func(*testing.T)) {
+			var sb strings.Builder
+			sb.WriteString("qwer")
+			return NewOutputTextStream(&sb), func(t *testing.T) {
+				assert.Equal(t, "qwer日", sb.String())
+			}
+		}, char: NewAtom("日"), ok: true},
 
 		// 8.12.3.3 Errors
 		{title: "a", streamOrAlias: func() (Term, func(*testing.T)) {
@@ -4317,6 +5786,9 @@ func TestPutChar(t *testing.T) {
 		{title: "b: atom but not one-char", streamOrAlias: func() (Term, func(*testing.T)) {
 			return NewOutputTextStream(nil), nil
 		}, char: NewAtom("foo"), err: typeError(validTypeCharacter, NewAtom("foo"), nil)},
+		{title: "b: empty atom", streamOrAlias: func() (Term, func(*testing.T)) {
+			return NewOutputTextStream(nil), nil
+		}, char: NewAtom(""), err: typeError(validTypeCharacter, NewAtom(""), nil)},
 		{title: "b: not even atom", streamOrAlias: func() (Term, func(*testing.T)) {
 			return NewOutputTextStream(nil), nil
 		}, char: Integer(1), err: typeError(validTypeCharacter, Integer(1), nil)},
@@ -4355,6 +5827,72 @@ func TestPutChar(t *testing.T) {
 	}
 }
 
+func TestTab(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var sb strings.Builder
+		s := NewOutputTextStream(&sb)
+		_, _ = s.WriteRune('a')
+
+		var vm VM
+		ok, err := Tab(&vm, s, atomPlus.Apply(Integer(1), Integer(2)), func(env *Env) *Promise {
+			return PutChar(&vm, s, NewAtom("b"), Success, env)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "a   b", sb.String())
+		assert.Equal(t, 5, s.column)
+	})
+
+	t.Run("large n", func(t *testing.T) {
+		var w sizeWriter
+		var vm VM
+		ok, err := Tab(&vm, NewOutputTextStream(&w), Integer(1000000), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 1000000, w.total)
+		assert.LessOrEqual(t, w.max, 1024)
+	})
+
+	t.Run("not positive", func(t *testing.T) {
+		var sb strings.Builder
+		var vm VM
+		ok, err := Tab(&vm, NewOutputTextStream(&sb), Integer(-1), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Empty(t, sb.String())
+	})
+
+	t.Run("n is a variable", func(t *testing.T) {
+		var vm VM
+		ok, err := Tab(&vm, NewOutputTextStream(nil), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+		assert.False(t, ok)
+	})
+
+	t.Run("n is not an integer", func(t *testing.T) {
+		var vm VM
+		ok, err := Tab(&vm, NewOutputTextStream(nil), Float(1.5), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeInteger, Float(1.5), nil), err)
+		assert.False(t, ok)
+	})
+
+	t.Run("input stream", func(t *testing.T) {
+		var vm VM
+		s := NewInputTextStream(nil)
+		ok, err := Tab(&vm, s, Integer(1), Success, nil).Force(context.Background())
+		assert.Equal(t, permissionError(operationOutput, permissionTypeStream, s, nil), err)
+		assert.False(t, ok)
+	})
+
+	t.Run("binary stream", func(t *testing.T) {
+		var vm VM
+		s := NewOutputBinaryStream(nil)
+		ok, err := Tab(&vm, s, Integer(1), Success, nil).Force(context.Background())
+		assert.Equal(t, permissionError(operationOutput, permissionTypeBinaryStream, s, nil), err)
+		assert.False(t, ok)
+	})
+}
+
 func TestReadTerm(t *testing.T) {
 	t.Run("stream", func(t *testing.T) {
 		f, err := os.Open("testdata/foo.pl")
@@ -4398,6 +5936,21 @@ func TestReadTerm(t *testing.T) {
 		assert.True(t, ok)
 	})
 
+	t.Run("user_input backed by a non-buffered reader", func(t *testing.T) {
+		var vm VM
+		vm.SetUserInput(NewInputTextStream(strings.NewReader("foo(a). bar.")))
+
+		for _, want := range []Term{NewAtom("foo").Apply(NewAtom("a")), NewAtom("bar"), atomEndOfFile} {
+			v := NewVariable()
+			ok, err := ReadTerm(&vm, atomUserInput, v, List(), func(env *Env) *Promise {
+				assert.Equal(t, want, env.Resolve(v))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}
+	})
+
 	t.Run("singletons", func(t *testing.T) {
 		f, err := os.Open("testdata/vars.txt")
 		assert.NoError(t, err)
@@ -4434,6 +5987,28 @@ func TestReadTerm(t *testing.T) {
 		assert.True(t, ok)
 	})
 
+	t.Run("singletons of terms in sequence", func(t *testing.T) {
+		var vm VM
+		vm.SetUserInput(NewInputTextStream(strings.NewReader("f(X, Y). g(Z, Z).")))
+
+		v, singletons := NewVariable(), NewVariable()
+		ok, err := ReadTerm(&vm, atomUserInput, v, List(atomSingletons.Apply(singletons)), func(env *Env) *Promise {
+			c := env.Resolve(v).(*compound)
+			assert.Equal(t, List(c.args...), env.Resolve(singletons))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		v, singletons = NewVariable(), NewVariable()
+		ok, err = ReadTerm(&vm, atomUserInput, v, List(atomSingletons.Apply(singletons)), func(env *Env) *Promise {
+			assert.Equal(t, List(), env.Resolve(singletons))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
 	t.Run("variables", func(t *testing.T) {
 		f, err := os.Open("testdata/vars.txt")
 		assert.NoError(t, err)
@@ -4550,6 +6125,28 @@ func TestReadTerm(t *testing.T) {
 		assert.True(t, ok)
 	})
 
+	t.Run("operator defined by a previous term", func(t *testing.T) {
+		s := &Stream{source: strings.NewReader("op(700, xfx, ===>). a ===> b."), mode: ioModeRead}
+
+		v := NewVariable()
+
+		var vm VM
+		vm.Register3(NewAtom("op"), Op)
+
+		ok, err := ReadTerm(&vm, s, v, List(), func(env *Env) *Promise {
+			return Call(&vm, v, Success, env)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = ReadTerm(&vm, s, v, List(), func(env *Env) *Promise {
+			assert.Equal(t, NewAtom("===>").Apply(NewAtom("a"), NewAtom("b")), env.Resolve(v))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
 	t.Run("streamOrAlias is a variable", func(t *testing.T) {
 		var vm VM
 		ok, err := ReadTerm(&vm, NewVariable(), NewVariable(), List(), Success, nil).Force(context.Background())
@@ -4700,9 +6297,214 @@ func TestReadTerm(t *testing.T) {
 		assert.Equal(t, syntaxError(unexpectedTokenError{actual: Token{kind: tokenGraphic, val: "="}}, nil), err)
 		assert.False(t, ok)
 	})
+
+	t.Run("syntax_errors", func(t *testing.T) {
+		const input = "foo(. bar bar. baz. "
+
+		read := func(vm *VM, mode Term) (Term, bool, error) {
+			v := NewVariable()
+			var got Term
+			ok, err := ReadTerm(vm, atomUserInput, v, List(atomSyntaxErrors.Apply(mode)), func(env *Env) *Promise {
+				got = env.Resolve(v)
+				return Bool(true)
+			}, nil).Force(context.Background())
+			return got, ok, err
+		}
+
+		t.Run("error", func(t *testing.T) {
+			var vm VM
+			vm.SetUserInput(NewInputTextStream(strings.NewReader(input)))
+			_, ok, err := read(&vm, atomError)
+			_, isException := err.(Exception)
+			assert.True(t, isException)
+			assert.False(t, ok)
+		})
+
+		t.Run("fail", func(t *testing.T) {
+			var vm VM
+			vm.SetUserInput(NewInputTextStream(strings.NewReader(input)))
+			for _, want := range []Term{nil, nil, NewAtom("baz"), atomEndOfFile} {
+				got, ok, err := read(&vm, atomFail)
+				assert.NoError(t, err)
+				assert.Equal(t, want != nil, ok)
+				assert.Equal(t, want, got)
+			}
+		})
+
+		t.Run("quiet", func(t *testing.T) {
+			var vm VM
+			vm.SetUserInput(NewInputTextStream(strings.NewReader(input)))
+			for _, want := range []Term{NewAtom("baz"), atomEndOfFile} {
+				got, ok, err := read(&vm, atomQuiet)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, want, got)
+			}
+		})
+
+		t.Run("quiet at the end", func(t *testing.T) {
+			var vm VM
+			vm.SetUserInput(NewInputTextStream(strings.NewReader("foo(")))
+			got, ok, err := read(&vm, atomQuiet)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, atomEndOfFile, got)
+		})
+
+		t.Run("mode is a variable", func(t *testing.T) {
+			var vm VM
+			vm.SetUserInput(NewInputTextStream(strings.NewReader(input)))
+			_, ok, err := read(&vm, NewVariable())
+			assert.Equal(t, InstantiationError(nil), err)
+			assert.False(t, ok)
+		})
+
+		t.Run("unknown mode", func(t *testing.T) {
+			var vm VM
+			vm.SetUserInput(NewInputTextStream(strings.NewReader(input)))
+			_, ok, err := read(&vm, NewAtom("foo"))
+			assert.Equal(t, domainError(validDomainReadOption, atomSyntaxErrors.Apply(NewAtom("foo")), nil), err)
+			assert.False(t, ok)
+		})
+	})
+
+	t.Run("double_quotes", func(t *testing.T) {
+		read := func(vm *VM, options Term) (Term, bool, error) {
+			v := NewVariable()
+			var got Term
+			ok, err := ReadTerm(vm, atomUserInput, v, options, func(env *Env) *Promise {
+				got = env.Resolve(v)
+				return Bool(true)
+			}, nil).Force(context.Background())
+			return got, ok, err
+		}
+
+		for _, tt := range []struct {
+			value Atom
+			term  Term
+		}{
+			{value: atomChars, term: CharList("abc")},
+			{value: atomCodes, term: CodeList("abc")},
+			{value: atomAtom, term: NewAtom("abc")},
+		} {
+			t.Run(tt.value.String(), func(t *testing.T) {
+				var vm VM
+				vm.doubleQuotes = doubleQuotesAtom
+				vm.SetUserInput(NewInputTextStream(strings.NewReader(`"abc". "abc".`)))
+				got, ok, err := read(&vm, List(atomDoubleQuotes.Apply(tt.value)))
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.term, got)
+
+				// The flag is intact.
+				got, ok, err = read(&vm, List())
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, NewAtom("abc"), got)
+			})
+		}
+
+		t.Run("value is a variable", func(t *testing.T) {
+			var vm VM
+			vm.SetUserInput(NewInputTextStream(strings.NewReader(`"abc".`)))
+			_, ok, err := read(&vm, List(atomDoubleQuotes.Apply(NewVariable())))
+			assert.Equal(t, InstantiationError(nil), err)
+			assert.False(t, ok)
+		})
+
+		t.Run("unknown value", func(t *testing.T) {
+			var vm VM
+			vm.SetUserInput(NewInputTextStream(strings.NewReader(`"abc".`)))
+			_, ok, err := read(&vm, List(atomDoubleQuotes.Apply(NewAtom("foo"))))
+			assert.Equal(t, domainError(validDomainReadOption, atomDoubleQuotes.Apply(NewAtom("foo")), nil), err)
+			assert.False(t, ok)
+		})
+	})
+}
+
+func TestReadTermFromAtom(t *testing.T) {
+	var vm VM
+	vm.operators.define(700, operatorSpecifierXFX, NewAtom("==>"))
+
+	t.Run("ok", func(t *testing.T) {
+		term, singletons, variables, variableNames := NewVariable(), NewVariable(), NewVariable(), NewVariable()
+		ok, err := ReadTermFromAtom(&vm, NewAtom("foo(X, Y, X) ==> bar"), term, List(
+			atomSingletons.Apply(singletons),
+			atomVariables.Apply(variables),
+			atomVariableNames.Apply(variableNames),
+		), func(env *Env) *Promise {
+			c, ok := env.Resolve(term).(Compound)
+			assert.True(t, ok)
+			assert.Equal(t, NewAtom("==>"), c.Functor())
+			foo := env.Resolve(c.Arg(0)).(Compound)
+			x, y := foo.Arg(0), foo.Arg(1)
+			assert.Equal(t, x, foo.Arg(2))
+			assert.Equal(t, List(y), env.Resolve(singletons))
+			assert.Equal(t, List(x, y), env.Resolve(variables))
+			assert.Equal(t, List(atomEqual.Apply(NewAtom("X"), x), atomEqual.Apply(NewAtom("Y"), y)), env.Resolve(variableNames))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("ends with a full stop", func(t *testing.T) {
+		ok, err := ReadTermFromAtom(&vm, NewAtom("foo."), NewAtom("foo"), List(), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		for _, a := range []Atom{NewAtom(""), NewAtom("  "), NewAtom("% comment")} {
+			ok, err := ReadTermFromAtom(&vm, a, atomEndOfFile, List(), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		_, err := ReadTermFromAtom(&vm, NewAtom("foo("), NewVariable(), List(), Success, nil).Force(context.Background())
+		_, ok := err.(Exception)
+		assert.True(t, ok)
+		assert.Contains(t, err.Error(), "syntax_error")
+
+		ok, err = ReadTermFromAtom(&vm, NewAtom("foo("), NewVariable(), List(atomSyntaxErrors.Apply(atomFail)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("atom is a variable", func(t *testing.T) {
+		_, err := ReadTermFromAtom(&vm, NewVariable(), NewVariable(), List(), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+	})
+
+	t.Run("atom is not an atom", func(t *testing.T) {
+		_, err := ReadTermFromAtom(&vm, Integer(1), NewVariable(), List(), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeAtom, Integer(1), nil), err)
+	})
+
+	t.Run("unknown option", func(t *testing.T) {
+		_, err := ReadTermFromAtom(&vm, NewAtom("foo"), NewVariable(), List(NewAtom("bar")), Success, nil).Force(context.Background())
+		assert.Equal(t, domainError(validDomainReadOption, NewAtom("bar"), nil), err)
+	})
 }
 
 func TestGetByte(t *testing.T) {
+	t.Run("end of stream", func(t *testing.T) {
+		f, err := os.Open("testdata/empty.txt")
+		assert.NoError(t, err)
+		defer func() {
+			assert.NoError(t, f.Close())
+		}()
+
+		s := &Stream{source: f, mode: ioModeRead, streamType: streamTypeBinary}
+
+		var vm VM
+		ok, err := GetByte(&vm, s, Integer(-1), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
 	t.Run("stream", func(t *testing.T) {
 		f, err := os.Open("testdata/a.txt")
 		assert.NoError(t, err)
@@ -4803,6 +6605,20 @@ func TestGetByte(t *testing.T) {
 			assert.Equal(t, typeError(validTypeInByte, Integer(256), nil), err)
 			assert.False(t, ok)
 		})
+
+		t.Run("too large", func(t *testing.T) {
+			var vm VM
+			ok, err := GetByte(&vm, s, Integer(300), Success, nil).Force(context.Background())
+			assert.Equal(t, typeError(validTypeInByte, Integer(300), nil), err)
+			assert.False(t, ok)
+		})
+
+		t.Run("negative", func(t *testing.T) {
+			var vm VM
+			ok, err := GetByte(&vm, s, Integer(-2), Success, nil).Force(context.Background())
+			assert.Equal(t, typeError(validTypeInByte, Integer(-2), nil), err)
+			assert.False(t, ok)
+		})
 	})
 
 	t.Run("streamOrAlias is neither a variable nor a stream-term or alias", func(t *testing.T) {
@@ -5029,6 +6845,21 @@ func TestGetChar(t *testing.T) {
 }
 
 func TestPeekByte(t *testing.T) {
+	t.Run("end of stream", func(t *testing.T) {
+		f, err := os.Open("testdata/empty.txt")
+		assert.NoError(t, err)
+		defer func() {
+			assert.NoError(t, f.Close())
+		}()
+
+		s := &Stream{source: f, mode: ioModeRead, streamType: streamTypeBinary}
+
+		var vm VM
+		ok, err := PeekByte(&vm, s, Integer(-1), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
 	t.Run("stream", func(t *testing.T) {
 		f, err := os.Open("testdata/abc.txt")
 		assert.NoError(t, err)
@@ -5093,15 +6924,31 @@ func TestPeekByte(t *testing.T) {
 		assert.True(t, ok)
 	})
 
-	t.Run("error", func(t *testing.T) {
-		var m mockReader
-		m.On("Read", mock.Anything).Return(0, errors.New("failed")).Twice()
-		defer m.AssertExpectations(t)
+	t.Run("eof with eof_action(reset)", func(t *testing.T) {
+		s := &Stream{source: strings.NewReader(""), mode: ioModeRead, streamType: streamTypeBinary, eofAction: eofActionReset, reposition: true}
 
-		s := &Stream{source: &m, mode: ioModeRead}
-		s.streamType = streamTypeBinary
-
-		v := NewVariable()
+		var vm VM
+		for i := 0; i < 2; i++ {
+			v := NewVariable()
+			ok, err := PeekByte(&vm, s, v, func(env *Env) *Promise {
+				assert.Equal(t, Integer(-1), env.Resolve(v))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, endOfStreamAt, s.endOfStream)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var m mockReader
+		m.On("Read", mock.Anything).Return(0, errors.New("failed")).Twice()
+		defer m.AssertExpectations(t)
+
+		s := &Stream{source: &m, mode: ioModeRead}
+		s.streamType = streamTypeBinary
+
+		v := NewVariable()
 
 		var vm VM
 		ok, err := PeekByte(&vm, s, v, Success, nil).Force(context.Background())
@@ -5133,6 +6980,20 @@ func TestPeekByte(t *testing.T) {
 			assert.Equal(t, typeError(validTypeInByte, Integer(256), nil), err)
 			assert.False(t, ok)
 		})
+
+		t.Run("too large", func(t *testing.T) {
+			var vm VM
+			ok, err := PeekByte(&vm, s, Integer(300), Success, nil).Force(context.Background())
+			assert.Equal(t, typeError(validTypeInByte, Integer(300), nil), err)
+			assert.False(t, ok)
+		})
+
+		t.Run("negative", func(t *testing.T) {
+			var vm VM
+			ok, err := PeekByte(&vm, s, Integer(-2), Success, nil).Force(context.Background())
+			assert.Equal(t, typeError(validTypeInByte, Integer(-2), nil), err)
+			assert.False(t, ok)
+		})
 	})
 
 	t.Run("streamOrAlias is neither a variable nor a stream term or alias", func(t *testing.T) {
@@ -5186,6 +7047,17 @@ func TestPeekByte(t *testing.T) {
 }
 
 func TestPeekChar(t *testing.T) {
+	t.Run("get_char in continuation", func(t *testing.T) {
+		s := &Stream{source: strings.NewReader("ab"), mode: ioModeRead}
+
+		var vm VM
+		ok, err := PeekChar(&vm, s, NewAtom("a"), func(env *Env) *Promise {
+			return GetChar(&vm, s, NewAtom("a"), Success, env)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
 	t.Run("stream", func(t *testing.T) {
 		f, err := os.Open("testdata/smile.txt")
 		assert.NoError(t, err)
@@ -5253,6 +7125,22 @@ func TestPeekChar(t *testing.T) {
 		assert.True(t, ok)
 	})
 
+	t.Run("eof with eof_action(reset)", func(t *testing.T) {
+		s := &Stream{source: strings.NewReader(""), mode: ioModeRead, eofAction: eofActionReset, reposition: true}
+
+		var vm VM
+		for i := 0; i < 2; i++ {
+			v := NewVariable()
+			ok, err := PeekChar(&vm, s, v, func(env *Env) *Promise {
+				assert.Equal(t, atomEndOfFile, env.Resolve(v))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, endOfStreamAt, s.endOfStream)
+		}
+	})
+
 	t.Run("error", func(t *testing.T) {
 		var m mockReader
 		m.On("Read", mock.Anything).Return(0, errors.New("failed")).Twice()
@@ -5368,13 +7256,42 @@ func Test_Halt(t *testing.T) {
 			osExit = os.Exit
 		}()
 
-		ok, err := Halt(nil, Integer(2), Success, nil).Force(context.Background())
+		var vm VM
+		ok, err := Halt(&vm, Integer(2), Success, nil).Force(context.Background())
 		assert.NoError(t, err)
 		assert.True(t, ok)
 
 		assert.True(t, exitCalled)
 	})
 
+	t.Run("before halt", func(t *testing.T) {
+		var calls []string
+		osExit = func(code int) {
+			calls = append(calls, "exit")
+		}
+		defer func() {
+			osExit = os.Exit
+		}()
+
+		vm := VM{
+			BeforeHalt: []func(int){
+				func(code int) {
+					assert.Equal(t, 3, code)
+					calls = append(calls, "first")
+				},
+				func(code int) {
+					assert.Equal(t, 3, code)
+					calls = append(calls, "second")
+				},
+			},
+		}
+		ok, err := Halt(&vm, Integer(3), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		assert.Equal(t, []string{"second", "first", "exit"}, calls)
+	})
+
 	t.Run("n is a variable", func(t *testing.T) {
 		n := NewVariable()
 
@@ -5526,6 +7443,12 @@ func TestAtomLength(t *testing.T) {
 
 		// 8.16.1.3 Errors
 		{title: "d", atom: NewAtom("atom"), length: Integer(-1), err: domainError(validDomainNotLessThanZero, Integer(-1), nil)},
+
+		{title: "atom_length(123, N).", atom: Integer(123), length: n, err: typeError(validTypeAtom, Integer(123), nil)},
+		{title: "atom_length([], N).", atom: atomEmptyList, length: n, ok: true, env: map[Variable]Term{
+			n: Integer(2),
+		}},
+		{title: "atom_length('', 0).", atom: NewAtom(""), length: Integer(0), ok: true},
 	}
 
 	for _, tt := range tests {
@@ -5583,6 +7506,32 @@ func TestAtomConcat(t *testing.T) {
 		assert.False(t, ok)
 	})
 
+	t.Run("instantiation patterns", func(t *testing.T) {
+		foo, bar, foobar := NewAtom("foo"), NewAtom("bar"), NewAtom("foobar")
+		for _, tt := range []struct {
+			title               string
+			atom1, atom2, atom3 Term
+			ok                  bool
+			err                 error
+		}{
+			{title: "+, +, +", atom1: foo, atom2: bar, atom3: foobar, ok: true},
+			{title: "+, +, -", atom1: foo, atom2: bar, atom3: NewVariable(), ok: true},
+			{title: "+, -, +", atom1: foo, atom2: NewVariable(), atom3: foobar, ok: true},
+			{title: "+, -, -", atom1: foo, atom2: NewVariable(), atom3: NewVariable(), err: InstantiationError(nil)},
+			{title: "-, +, +", atom1: NewVariable(), atom2: bar, atom3: foobar, ok: true},
+			{title: "-, +, -", atom1: NewVariable(), atom2: bar, atom3: NewVariable(), err: InstantiationError(nil)},
+			{title: "-, -, +", atom1: NewVariable(), atom2: NewVariable(), atom3: foobar, ok: true},
+			{title: "-, -, -", atom1: NewVariable(), atom2: NewVariable(), atom3: NewVariable(), err: InstantiationError(nil)},
+			{title: "+, +, + mismatch", atom1: bar, atom2: foo, atom3: foobar, ok: false},
+		} {
+			t.Run(tt.title, func(t *testing.T) {
+				ok, err := AtomConcat(nil, tt.atom1, tt.atom2, tt.atom3, Success, nil).Force(context.Background())
+				assert.Equal(t, tt.err, err)
+				assert.Equal(t, tt.ok, ok)
+			})
+		}
+	})
+
 	t.Run("atom1 and atom3 are variables", func(t *testing.T) {
 		atom1, atom3 := NewVariable(), NewVariable()
 
@@ -5666,6 +7615,43 @@ func TestSubAtom(t *testing.T) {
 		assert.False(t, ok)
 	})
 
+	t.Run("overlapping occurrences", func(t *testing.T) {
+		before, after := NewVariable(), NewVariable()
+		var bs, as []Term
+		ok, err := SubAtom(nil, NewAtom("aaaa"), before, NewVariable(), after, NewAtom("aa"), func(env *Env) *Promise {
+			bs = append(bs, env.Resolve(before))
+			as = append(as, env.Resolve(after))
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, []Term{Integer(0), Integer(1), Integer(2)}, bs)
+		assert.Equal(t, []Term{Integer(2), Integer(1), Integer(0)}, as)
+	})
+
+	t.Run("multibyte sub atom", func(t *testing.T) {
+		before := NewVariable()
+		var bs []Term
+		ok, err := SubAtom(nil, NewAtom("あいあい"), before, Integer(1), NewVariable(), NewAtom("い"), func(env *Env) *Promise {
+			bs = append(bs, env.Resolve(before))
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, []Term{Integer(1), Integer(3)}, bs)
+	})
+
+	t.Run("empty sub atom", func(t *testing.T) {
+		var c int
+		ok, err := SubAtom(nil, NewAtom("ab"), NewVariable(), NewVariable(), NewVariable(), NewAtom(""), func(env *Env) *Promise {
+			c++
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, 3, c)
+	})
+
 	t.Run("get the first char", func(t *testing.T) {
 		char := NewVariable()
 		ok, err := SubAtom(nil, NewAtom("a"), Integer(0), Integer(1), Integer(0), char, func(env *Env) *Promise {
@@ -5731,6 +7717,14 @@ func TestSubAtom(t *testing.T) {
 	})
 }
 
+func BenchmarkSubAtom(b *testing.B) {
+	whole := NewAtom(strings.Repeat("abcdefghij", 100))
+	before, length, after := NewVariable(), NewVariable(), NewVariable()
+	for i := 0; i < b.N; i++ {
+		_, _ = SubAtom(nil, whole, before, length, after, NewAtom("efg"), Failure, nil).Force(context.Background())
+	}
+}
+
 func TestAtomChars(t *testing.T) {
 	l := NewVariable()
 	str := NewVariable()
@@ -5895,6 +7889,28 @@ func TestNumberChars(t *testing.T) {
 		}, nil).Force(context.Background())
 		assert.NoError(t, err)
 		assert.True(t, ok)
+
+		t.Run("negative integer", func(t *testing.T) {
+			num := NewVariable()
+
+			ok, err := NumberChars(nil, num, List(atomMinus, NewAtom("1")), func(env *Env) *Promise {
+				assert.Equal(t, Integer(-1), env.Resolve(num))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+
+		t.Run("negative float", func(t *testing.T) {
+			num := NewVariable()
+
+			ok, err := NumberChars(nil, num, List(atomMinus, NewAtom("2"), NewAtom("3"), atomDot, NewAtom("4")), func(env *Env) *Promise {
+				assert.Equal(t, Float(-23.4), env.Resolve(num))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
 	})
 
 	t.Run("both provided", func(t *testing.T) {
@@ -6022,6 +8038,9 @@ func TestNumberCodes(t *testing.T) {
 		{title: "number_codes(A, [0'-, 0'2, 0'5]).", number: a, list: List(Integer('-'), Integer('2'), Integer('5')), ok: true, env: map[Variable]Term{
 			a: Integer(-25),
 		}},
+		{title: "number_codes(A, [0'-, 0'2, 0'., 0'5]).", number: a, list: List(Integer('-'), Integer('2'), Integer('.'), Integer('5')), ok: true, env: map[Variable]Term{
+			a: Float(-2.5),
+		}},
 		{title: "number_codes(A, [0' , 0'3]).", number: a, list: List(Integer(' '), Integer('3')), ok: true, env: map[Variable]Term{
 			a: Integer(3),
 		}},
@@ -6050,6 +8069,13 @@ func TestNumberCodes(t *testing.T) {
 		{title: "f: with a variable element", number: Integer(0), list: List(NewVariable(), NewAtom("foo")), err: typeError(validTypeInteger, NewAtom("foo"), nil)},
 		{title: "g: without a variable element", number: Integer(0), list: List(Integer(utf8.MaxRune + 1)), err: representationError(flagCharacterCode, nil)},
 		{title: "g: with a variable element", number: Integer(0), list: List(NewVariable(), Integer(utf8.MaxRune+1)), err: representationError(flagCharacterCode, nil)},
+
+		{title: "leading layout", number: a, list: CodeList("  12"), ok: true, env: map[Variable]Term{
+			a: Integer(12),
+		}},
+		{title: "trailing layout", number: a, list: CodeList("12 "), err: syntaxError(errNotANumber, nil)},
+		{title: "trailing garbage", number: a, list: CodeList("12a"), err: syntaxError(errNotANumber, nil)},
+		{title: "split number", number: a, list: CodeList("1 2"), err: syntaxError(errNotANumber, nil)},
 	}
 
 	for _, tt := range tests {
@@ -6072,6 +8098,211 @@ func TestNumberCodes(t *testing.T) {
 	}
 }
 
+func TestWriteToCodes(t *testing.T) {
+	var vm VM
+	vm.operators.define(500, operatorSpecifierYFX, atomPlus)
+
+	codes := NewVariable()
+	ok, err := WriteToCodes(&vm, NewAtom("f").Apply(atomPlus.Apply(Integer(1), Integer(2)), NewAtom("Foo"), NewAtom("$VAR").Apply(Integer(0))), codes, func(env *Env) *Promise {
+		assert.Equal(t, CodeList("f(1+2,Foo,A)"), env.Resolve(codes))
+		return Bool(true)
+	}, nil).Force(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestTermString(t *testing.T) {
+	var vm VM
+	vm.operators.define(500, operatorSpecifierYFX, atomPlus)
+
+	t.Run("term to string", func(t *testing.T) {
+		str := NewVariable()
+		ok, err := TermString(&vm, NewAtom("f").Apply(atomPlus.Apply(Integer(1), Integer(2)), NewAtom("Foo")), str, func(env *Env) *Promise {
+			assert.Equal(t, CodeList("f(1+2,'Foo')"), env.Resolve(str))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("string to term", func(t *testing.T) {
+		term := NewVariable()
+		ok, err := TermString(&vm, term, CodeList("f(1+2, 'Foo')"), func(env *Env) *Promise {
+			assert.Equal(t, NewAtom("f").Apply(atomPlus.Apply(Integer(1), Integer(2)), NewAtom("Foo")), env.Resolve(term))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("string ends with a full stop", func(t *testing.T) {
+		ok, err := TermString(&vm, NewAtom("foo"), CodeList("foo."), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("string is a partial list", func(t *testing.T) {
+		_, err := TermString(&vm, NewVariable(), PartialList(NewVariable(), Integer('f')), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+	})
+
+	t.Run("an element of string is a variable", func(t *testing.T) {
+		_, err := TermString(&vm, NewVariable(), List(Integer('f'), NewVariable()), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+	})
+
+	t.Run("string is a list of characters", func(t *testing.T) {
+		ok, err := TermString(&vm, NewAtom("f").Apply(NewAtom("a")), CharList("f(a)"), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("string is an atom", func(t *testing.T) {
+		ok, err := TermString(&vm, NewAtom("f").Apply(NewAtom("a")), NewAtom("f(a)"), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("an element of string is neither a code nor a character", func(t *testing.T) {
+		_, err := TermString(&vm, NewVariable(), List(Float(1)), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeCharacter, Float(1), nil), err)
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		_, err := TermString(&vm, NewVariable(), CodeList("f("), Success, nil).Force(context.Background())
+		_, ok := err.(Exception)
+		assert.True(t, ok)
+	})
+
+	t.Run("text follows the term", func(t *testing.T) {
+		_, err := TermString(&vm, NewVariable(), CodeList("foo. bar"), Success, nil).Force(context.Background())
+		assert.Equal(t, syntaxError(unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "bar"}}, nil), err)
+	})
+}
+
+func TestTermToAtom(t *testing.T) {
+	var vm VM
+	vm.operators.define(500, operatorSpecifierYFX, atomPlus)
+	vm.operators.define(700, operatorSpecifierXFX, NewAtom("==>"))
+
+	t.Run("term to atom", func(t *testing.T) {
+		atom := NewVariable()
+		ok, err := TermToAtom(&vm, NewAtom("f").Apply(atomPlus.Apply(Integer(1), Integer(2)), NewAtom("Foo"), NewAtom("[]")), atom, func(env *Env) *Promise {
+			assert.Equal(t, NewAtom("f(1+2,'Foo',[])"), env.Resolve(atom))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("atom to term", func(t *testing.T) {
+		term := NewVariable()
+		ok, err := TermToAtom(&vm, term, NewAtom("a ==> b + 'Foo'"), func(env *Env) *Promise {
+			assert.Equal(t, NewAtom("==>").Apply(NewAtom("a"), atomPlus.Apply(NewAtom("b"), NewAtom("Foo"))), env.Resolve(term))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		in := NewAtom("f").Apply(NewAtom("a b"), Float(1.5), List(Integer(1), NewAtom("X")))
+		atom, out := NewVariable(), NewVariable()
+		ok, err := TermToAtom(&vm, in, atom, func(env *Env) *Promise {
+			return TermToAtom(&vm, out, atom, func(env *Env) *Promise {
+				assert.Equal(t, in, env.Resolve(out))
+				return Bool(true)
+			}, env)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("both are bound", func(t *testing.T) {
+		ok, err := TermToAtom(&vm, atomPlus.Apply(Integer(1), Integer(2)), NewAtom("1 + 2"), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = TermToAtom(&vm, atomPlus.Apply(Integer(1), Integer(3)), NewAtom("1 + 2"), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("atom is neither a variable nor an atom", func(t *testing.T) {
+		_, err := TermToAtom(&vm, NewVariable(), Integer(1), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeAtom, Integer(1), nil), err)
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		_, err := TermToAtom(&vm, NewVariable(), NewAtom("f("), Success, nil).Force(context.Background())
+		_, ok := err.(Exception)
+		assert.True(t, ok)
+		assert.Contains(t, err.Error(), "syntax_error")
+	})
+
+	t.Run("text follows the term", func(t *testing.T) {
+		_, err := TermToAtom(&vm, NewVariable(), NewAtom("foo. bar"), Success, nil).Force(context.Background())
+		assert.Equal(t, syntaxError(unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "bar"}}, nil), err)
+	})
+
+	t.Run("atom ends with a full stop", func(t *testing.T) {
+		ok, err := TermToAtom(&vm, NewAtom("foo"), NewAtom("foo."), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
+
+func TestAtomToTerm(t *testing.T) {
+	var vm VM
+
+	t.Run("ok", func(t *testing.T) {
+		term, bindings := NewVariable(), NewVariable()
+		ok, err := AtomToTerm(&vm, NewAtom("foo(X, Y, X)"), term, bindings, func(env *Env) *Promise {
+			c, ok := env.Resolve(term).(Compound)
+			assert.True(t, ok)
+			assert.Equal(t, NewAtom("foo"), c.Functor())
+			assert.Equal(t, 3, c.Arity())
+			x, y := env.Resolve(c.Arg(0)), env.Resolve(c.Arg(1))
+			assert.Equal(t, x, env.Resolve(c.Arg(2)))
+			assert.NotEqual(t, x, y)
+			assert.Equal(t, List(
+				atomEqual.Apply(NewAtom("X"), x),
+				atomEqual.Apply(NewAtom("Y"), y),
+			), env.Resolve(bindings))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("ground", func(t *testing.T) {
+		ok, err := AtomToTerm(&vm, NewAtom("foo(a)"), NewAtom("foo").Apply(NewAtom("a")), List(), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("atom is a variable", func(t *testing.T) {
+		_, err := AtomToTerm(&vm, NewVariable(), NewVariable(), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+	})
+
+	t.Run("atom is neither a variable nor an atom", func(t *testing.T) {
+		_, err := AtomToTerm(&vm, Integer(0), NewVariable(), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeAtom, Integer(0), nil), err)
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		_, err := AtomToTerm(&vm, NewAtom("foo("), NewVariable(), NewVariable(), Success, nil).Force(context.Background())
+		_, ok := err.(Exception)
+		assert.True(t, ok)
+	})
+
+	t.Run("text follows the term", func(t *testing.T) {
+		_, err := AtomToTerm(&vm, NewAtom("foo. bar"), NewVariable(), NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, syntaxError(unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "bar"}}, nil), err)
+	})
+}
+
 func TestStreamProperty(t *testing.T) {
 	f, err := os.Open("testdata/empty.txt")
 	assert.NoError(t, err)
@@ -6110,12 +8341,22 @@ func TestStreamProperty(t *testing.T) {
 				{p: atomInput},
 				{p: atomAlias.Apply(NewAtom("null"))},
 				{p: atomPosition.Apply(Integer(0))},
-				{p: atomEndOfStream.Apply(atomNot)},
+				{p: atomEndOfStream.Apply(atomAt)},
 				{p: atomEOFAction.Apply(atomEOFCode)},
 				{p: atomReposition.Apply(atomTrue)},
 				{p: atomType.Apply(atomText)},
 			},
 		},
+		{
+			title:    "auto_flush",
+			stream:   s,
+			property: atomAutoFlush.Apply(atomFalse),
+			ok:       true,
+			env: []map[Variable]Term{
+				{s: ss[1]},
+				{s: ss[2]},
+			},
+		},
 		{
 			title:    "output",
 			stream:   s,
@@ -6171,27 +8412,117 @@ func TestStreamProperty(t *testing.T) {
 			assert.Equal(t, tt.err, err)
 		})
 	}
-}
 
-func TestSetStreamPosition(t *testing.T) {
-	t.Run("ok", func(t *testing.T) {
-		f, err := os.Open("testdata/empty.txt")
+	t.Run("end_of_stream of an in-memory reader", func(t *testing.T) {
+		var vm VM
+		s := NewInputTextStream(strings.NewReader("a"))
+		eos := func() Term {
+			e := NewVariable()
+			var ret Term
+			ok, err := StreamProperty(&vm, s, atomEndOfStream.Apply(e), func(env *Env) *Promise {
+				ret = env.Resolve(e)
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			return ret
+		}
+
+		assert.Equal(t, atomNot, eos())
+
+		r, _, err := s.ReadRune()
 		assert.NoError(t, err)
-		defer func() {
-			assert.NoError(t, f.Close())
-		}()
+		assert.Equal(t, 'a', r)
+		assert.Equal(t, atomAt, eos())
 
-		s := &Stream{source: f, mode: ioModeRead, reposition: true}
+		_, _, err = s.ReadRune()
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, atomPast, eos())
+	})
 
+	t.Run("end_of_stream of an empty in-memory reader", func(t *testing.T) {
 		var vm VM
-		ok, err := SetStreamPosition(&vm, s, Integer(0), Success, nil).Force(context.Background())
+		ok, err := StreamProperty(&vm, NewInputTextStream(strings.NewReader("")), atomEndOfStream.Apply(atomAt), Success, nil).Force(context.Background())
 		assert.NoError(t, err)
 		assert.True(t, ok)
 	})
 
-	t.Run("seek failed", func(t *testing.T) {
-		var m mockFile
-		m.On("Seek", mock.Anything, mock.Anything).Return(int64(0), errors.New("failed")).Once()
+	t.Run("end_of_stream doesn't consume the input", func(t *testing.T) {
+		var vm VM
+		s := NewInputTextStream(strings.NewReader("ab"))
+		ok, err := StreamProperty(&vm, s, atomEndOfStream.Apply(atomNot), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		r, _, err := s.ReadRune()
+		assert.NoError(t, err)
+		assert.Equal(t, 'a', r)
+		assert.Equal(t, int64(1), s.position)
+	})
+
+	t.Run("specific property on one of many streams", func(t *testing.T) {
+		var vm VM
+		for i := 0; i < 100; i++ {
+			vm.streams.add(&Stream{sink: f, mode: ioModeWrite})
+		}
+		r := &Stream{source: f, mode: ioModeRead}
+		vm.streams.add(r)
+
+		var n int
+		ok, err := StreamProperty(&vm, r, atomMode.Apply(atomRead), func(*Env) *Promise {
+			n++
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, 1, n)
+
+		n = 0
+		ok, err = StreamProperty(&vm, NewVariable(), atomMode.Apply(atomRead), func(*Env) *Promise {
+			n++
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, 1, n)
+
+		ok, err = StreamProperty(&vm, r, atomMode.Apply(atomWrite), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("only the specific property is computed", func(t *testing.T) {
+		var vm VM
+		r := &Stream{source: f, mode: ioModeRead}
+		vm.streams.add(r)
+
+		ps := r.properties(procedureIndicator{name: atomMode, arity: 1})
+		assert.Equal(t, []Term{atomMode.Apply(atomRead)}, ps)
+		ps = r.properties(procedureIndicator{name: atomInput, arity: 0})
+		assert.Equal(t, []Term{atomInput}, ps)
+		assert.Empty(t, r.properties(procedureIndicator{name: atomOutput, arity: 0}))
+	})
+}
+
+func TestSetStreamPosition(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		f, err := os.Open("testdata/empty.txt")
+		assert.NoError(t, err)
+		defer func() {
+			assert.NoError(t, f.Close())
+		}()
+
+		s := &Stream{source: f, mode: ioModeRead, reposition: true}
+
+		var vm VM
+		ok, err := SetStreamPosition(&vm, s, Integer(0), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("seek failed", func(t *testing.T) {
+		var m mockFile
+		m.On("Seek", mock.Anything, mock.Anything).Return(int64(0), errors.New("failed")).Once()
 		defer m.AssertExpectations(t)
 
 		s := &Stream{source: &m, mode: ioModeRead, reposition: true}
@@ -6559,6 +8890,31 @@ func TestSetPrologFlag(t *testing.T) {
 		})
 	})
 
+	t.Run("int_overflow", func(t *testing.T) {
+		t.Run("wrap", func(t *testing.T) {
+			var vm VM
+			ok, err := SetPrologFlag(&vm, atomIntOverflow, atomWrap, Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.True(t, vm.wrapIntOverflow)
+		})
+
+		t.Run("error", func(t *testing.T) {
+			vm := VM{wrapIntOverflow: true}
+			ok, err := SetPrologFlag(&vm, atomIntOverflow, atomError, Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.False(t, vm.wrapIntOverflow)
+		})
+
+		t.Run("unknown", func(t *testing.T) {
+			var vm VM
+			ok, err := SetPrologFlag(&vm, atomIntOverflow, NewAtom("foo"), Success, nil).Force(context.Background())
+			assert.Equal(t, domainError(validDomainFlagValue, atomPlus.Apply(atomIntOverflow, NewAtom("foo")), nil), err)
+			assert.False(t, ok)
+		})
+	})
+
 	t.Run("flag is a variable", func(t *testing.T) {
 		var vm VM
 		ok, err := SetPrologFlag(&vm, NewVariable(), atomFail, Success, nil).Force(context.Background())
@@ -6640,6 +8996,10 @@ func TestCurrentPrologFlag(t *testing.T) {
 		ok, err = CurrentPrologFlag(&vm, atomUnknown, atomError, Success, nil).Force(context.Background())
 		assert.NoError(t, err)
 		assert.True(t, ok)
+
+		ok, err = CurrentPrologFlag(&vm, atomIntOverflow, atomError, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
 	})
 
 	t.Run("not specified", func(t *testing.T) {
@@ -6674,6 +9034,9 @@ func TestCurrentPrologFlag(t *testing.T) {
 			case 8:
 				assert.Equal(t, atomDoubleQuotes, env.Resolve(flag))
 				assert.Equal(t, NewAtom(vm.doubleQuotes.String()), env.Resolve(value))
+			case 9:
+				assert.Equal(t, atomIntOverflow, env.Resolve(flag))
+				assert.Equal(t, atomError, env.Resolve(value))
 			default:
 				assert.Fail(t, "unreachable")
 			}
@@ -6682,7 +9045,14 @@ func TestCurrentPrologFlag(t *testing.T) {
 		}, nil).Force(context.Background())
 		assert.NoError(t, err)
 		assert.False(t, ok)
-		assert.Equal(t, 9, c)
+		assert.Equal(t, 10, c)
+	})
+
+	t.Run("max_arity", func(t *testing.T) {
+		vm := VM{MaxArity: 255}
+		ok, err := CurrentPrologFlag(&vm, atomMaxArity, Integer(255), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
 	})
 
 	t.Run("flag is neither a variable nor an atom", func(t *testing.T) {
@@ -6700,6 +9070,41 @@ func TestCurrentPrologFlag(t *testing.T) {
 	})
 }
 
+func TestCurrentFlagValues(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		vm := VM{unknown: unknownFail}
+		values := NewVariable()
+		ok, err := CurrentFlagValues(&vm, values, func(env *Env) *Promise {
+			var flags []Term
+			iter := ListIterator{List: values, Env: env}
+			for iter.Next() {
+				flags = append(flags, env.Resolve(iter.Current()))
+			}
+			assert.NoError(t, iter.Err())
+			assert.Contains(t, flags, pair(atomBounded, atomTrue))
+			assert.Contains(t, flags, pair(atomMaxInteger, Integer(math.MaxInt64)))
+			assert.Contains(t, flags, pair(atomMinInteger, Integer(math.MinInt64)))
+			assert.Contains(t, flags, pair(atomIntegerRoundingFunction, atomTowardZero))
+			assert.Contains(t, flags, pair(atomCharConversion, atomOff))
+			assert.Contains(t, flags, pair(atomDebug, atomOff))
+			assert.Contains(t, flags, pair(atomMaxArity, atomUnbounded))
+			assert.Contains(t, flags, pair(atomUnknown, atomFail))
+			assert.Contains(t, flags, pair(atomDoubleQuotes, atomChars))
+			assert.Contains(t, flags, pair(atomIntOverflow, atomError))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("values is not a list", func(t *testing.T) {
+		var vm VM
+		ok, err := CurrentFlagValues(&vm, NewAtom("foo"), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
 func TestExpandTerm(t *testing.T) {
 	f, g := NewAtom("f"), NewAtom("g")
 	a, b, c := NewAtom("a"), NewAtom("b"), NewAtom("c")
@@ -7032,6 +9437,44 @@ func TestNth0(t *testing.T) {
 			_, err := Nth0(nil, NewVariable(), PartialList(NewVariable(), NewAtom("a")), NewVariable(), Failure, nil).Force(context.Background())
 			assert.Equal(t, InstantiationError(nil), err)
 		})
+
+		t.Run("list is a partial list", func(t *testing.T) {
+			var (
+				n       = NewVariable()
+				elem    = NewVariable()
+				results []Term
+			)
+			_, err := Nth0(nil, n, PartialList(NewVariable(), NewAtom("a"), NewAtom("b")), elem, func(env *Env) *Promise {
+				results = append(results, atomMinus.Apply(env.Resolve(n), env.Resolve(elem)))
+				return Bool(false)
+			}, nil).Force(context.Background())
+			assert.Equal(t, InstantiationError(nil), err)
+			assert.Equal(t, []Term{
+				atomMinus.Apply(Integer(0), NewAtom("a")),
+				atomMinus.Apply(Integer(1), NewAtom("b")),
+			}, results)
+		})
+
+		t.Run("list is a cyclic list", func(t *testing.T) {
+			l := NewVariable()
+			env := NewEnv().bind(l, PartialList(l, NewAtom("a"), NewAtom("b")))
+			_, err := Nth0(nil, NewVariable(), l, NewVariable(), Failure, env).Force(context.Background())
+			assert.Equal(t, typeError(validTypeList, l, env), err)
+		})
+
+		t.Run("first answer of a long list", func(t *testing.T) {
+			elems := make([]Term, 100000)
+			for i := range elems {
+				elems[i] = Integer(i)
+			}
+			n := NewVariable()
+			ok, err := Nth0(nil, n, List(elems...), Integer(3), func(env *Env) *Promise {
+				assert.Equal(t, Integer(3), env.Resolve(n))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
 	})
 
 	t.Run("n is an integer", func(t *testing.T) {
@@ -7148,8 +9591,25 @@ func TestSucc(t *testing.T) {
 			})
 
 			t.Run("s < 0", func(t *testing.T) {
-				_, err := Succ(nil, NewVariable(), Integer(-1), Success, nil).Force(context.Background())
-				assert.Equal(t, domainError(validDomainNotLessThanZero, Integer(-1), nil), err)
+				ok, err := Succ(nil, NewVariable(), Integer(-1), Success, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.False(t, ok)
+			})
+
+			t.Run("s is math.MinInt64", func(t *testing.T) {
+				ok, err := Succ(nil, NewVariable(), Integer(math.MinInt64), Success, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.False(t, ok)
+			})
+
+			t.Run("s is math.MaxInt64", func(t *testing.T) {
+				x := NewVariable()
+				ok, err := Succ(nil, x, Integer(math.MaxInt64), func(env *Env) *Promise {
+					assert.Equal(t, Integer(math.MaxInt64-1), env.Resolve(x))
+					return Bool(true)
+				}, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.True(t, ok)
 			})
 
 			t.Run("s = 0", func(t *testing.T) {
@@ -7197,9 +9657,16 @@ func TestSucc(t *testing.T) {
 			assert.Equal(t, evaluationError(exceptionalValueIntOverflow, nil), err)
 		})
 
+		t.Run("x is math.MaxInt64 - 1", func(t *testing.T) {
+			ok, err := Succ(nil, Integer(math.MaxInt64-1), Integer(math.MaxInt64), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+
 		t.Run("s is negative", func(t *testing.T) {
-			_, err := Succ(nil, Integer(0), Integer(-1), Success, nil).Force(context.Background())
-			assert.Equal(t, domainError(validDomainNotLessThanZero, Integer(-1), nil), err)
+			ok, err := Succ(nil, Integer(0), Integer(-1), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.False(t, ok)
 		})
 	})
 
@@ -7207,6 +9674,58 @@ func TestSucc(t *testing.T) {
 		_, err := Succ(nil, Float(0), NewVariable(), Success, nil).Force(context.Background())
 		assert.Equal(t, typeError(validTypeInteger, Float(0), nil), err)
 	})
+
+	t.Run("bound variables", func(t *testing.T) {
+		x, s := NewVariable(), NewVariable()
+		env := NewEnv().bind(x, Integer(3)).bind(s, Integer(4))
+		ok, err := Succ(nil, x, s, Success, env).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		y := NewVariable()
+		ok, err = Succ(nil, y, s, func(env *Env) *Promise {
+			assert.Equal(t, Integer(3), env.Resolve(y))
+			return Bool(true)
+		}, env).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
+
+func TestPlus(t *testing.T) {
+	tests := []struct {
+		title   string
+		x, y, z Term
+		ok      bool
+		err     error
+		want    [3]Term
+	}{
+		{title: "plus(1, 2, Z)", x: Integer(1), y: Integer(2), z: NewVariable(), ok: true, want: [3]Term{Integer(1), Integer(2), Integer(3)}},
+		{title: "plus(1, Y, 3)", x: Integer(1), y: NewVariable(), z: Integer(3), ok: true, want: [3]Term{Integer(1), Integer(2), Integer(3)}},
+		{title: "plus(X, 2, 3)", x: NewVariable(), y: Integer(2), z: Integer(3), ok: true, want: [3]Term{Integer(1), Integer(2), Integer(3)}},
+		{title: "plus(-1, -2, Z)", x: Integer(-1), y: Integer(-2), z: NewVariable(), ok: true, want: [3]Term{Integer(-1), Integer(-2), Integer(-3)}},
+		{title: "plus(1, 2, 3)", x: Integer(1), y: Integer(2), z: Integer(3), ok: true, want: [3]Term{Integer(1), Integer(2), Integer(3)}},
+		{title: "plus(1, 2, 4)", x: Integer(1), y: Integer(2), z: Integer(4)},
+		{title: "plus(max_integer, 0, Z)", x: Integer(math.MaxInt64), y: Integer(0), z: NewVariable(), ok: true, want: [3]Term{Integer(math.MaxInt64), Integer(0), Integer(math.MaxInt64)}},
+		{title: "plus(max_integer, 1, Z)", x: Integer(math.MaxInt64), y: Integer(1), z: NewVariable(), err: evaluationError(exceptionalValueIntOverflow, nil)},
+		{title: "plus(min_integer, -1, Z)", x: Integer(math.MinInt64), y: Integer(-1), z: NewVariable(), err: evaluationError(exceptionalValueIntOverflow, nil)},
+		{title: "plus(-1, Y, max_integer)", x: Integer(-1), y: NewVariable(), z: Integer(math.MaxInt64), err: evaluationError(exceptionalValueIntOverflow, nil)},
+		{title: "plus(X, 1, min_integer)", x: NewVariable(), y: Integer(1), z: Integer(math.MinInt64), err: evaluationError(exceptionalValueIntOverflow, nil)},
+		{title: "plus(X, Y, 3)", x: NewVariable(), y: NewVariable(), z: Integer(3), err: InstantiationError(nil)},
+		{title: "plus(1.0, 2, Z)", x: Float(1), y: Integer(2), z: NewVariable(), err: typeError(validTypeInteger, Float(1), nil)},
+		{title: "plus(1, a, Z)", x: Integer(1), y: NewAtom("a"), z: NewVariable(), err: typeError(validTypeInteger, NewAtom("a"), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := Plus(nil, tt.x, tt.y, tt.z, func(env *Env) *Promise {
+				assert.Equal(t, tt.want, [3]Term{env.Resolve(tt.x), env.Resolve(tt.y), env.Resolve(tt.z)})
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.err, err)
+		})
+	}
 }
 
 func TestLength(t *testing.T) {
@@ -7236,6 +9755,48 @@ func TestLength(t *testing.T) {
 		})
 	})
 
+	t.Run("list is a variable", func(t *testing.T) {
+		t.Run("length is a variable", func(t *testing.T) {
+			l, n := NewVariable(), NewVariable()
+			var count int
+			ok, err := Length(nil, l, n, func(env *Env) *Promise {
+				var elems []Variable
+				iter := ListIterator{List: l, Env: env}
+				for iter.Next() {
+					elems = append(elems, env.Resolve(iter.Current()).(Variable))
+				}
+				assert.NoError(t, iter.Err())
+
+				assert.Len(t, elems, count)
+				assert.Equal(t, Integer(count), env.Resolve(n))
+
+				count++
+				return Bool(count == 4)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, 4, count)
+		})
+
+		t.Run("length is an integer", func(t *testing.T) {
+			l := NewVariable()
+			ok, err := Length(nil, l, Integer(3), func(env *Env) *Promise {
+				iter := ListIterator{List: l, Env: env}
+				var elems []Variable
+				for iter.Next() {
+					elems = append(elems, env.Resolve(iter.Current()).(Variable))
+				}
+				assert.NoError(t, iter.Err())
+				assert.Len(t, elems, 3)
+				assert.NotEqual(t, elems[0], elems[1])
+				assert.NotEqual(t, elems[1], elems[2])
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+	})
+
 	t.Run("list is a partial list", func(t *testing.T) {
 		t.Run("length is a variable", func(t *testing.T) {
 			t.Run("length and the suffix of list are different", func(t *testing.T) {
@@ -7388,19 +9949,87 @@ func TestSkipMaxList(t *testing.T) {
 }
 
 func TestRepeat(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	t.Run("cancelled by the continuation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 
-	c := 0
+		c := 0
 
-	_, err := Repeat(nil, func(*Env) *Promise {
-		c++
-		cancel()
-		return Bool(true)
-	}, nil).Force(ctx)
-	assert.Equal(t, context.Canceled, err)
+		_, err := Repeat(nil, func(*Env) *Promise {
+			c++
+			cancel()
+			return Bool(true)
+		}, nil).Force(ctx)
+		assert.Equal(t, context.Canceled, err)
+
+		assert.Equal(t, 1, c)
+	})
+
+	t.Run("repeat, fail", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		done := make(chan error)
+		go func() {
+			_, err := Repeat(nil, Failure, nil).Force(ctx)
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			assert.Equal(t, context.Canceled, err)
+		case <-time.After(time.Second):
+			t.Fatal("repeat didn't stop")
+		}
+	})
+}
+
+func TestRepeatN(t *testing.T) {
+	t.Run("backtrack", func(t *testing.T) {
+		c := 0
+		ok, err := RepeatN(nil, Integer(3), func(*Env) *Promise {
+			c++
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, 3, c)
+	})
+
+	t.Run("succeeds", func(t *testing.T) {
+		c := 0
+		ok, err := RepeatN(nil, Integer(3), func(*Env) *Promise {
+			c++
+			return Bool(c == 2)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 2, c)
+	})
+
+	t.Run("zero", func(t *testing.T) {
+		ok, err := RepeatN(nil, Integer(0), func(*Env) *Promise {
+			t.Fatal("unreachable")
+			return nil
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("n is a variable", func(t *testing.T) {
+		_, err := RepeatN(nil, NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, InstantiationError(nil), err)
+	})
+
+	t.Run("n is neither a variable nor an integer", func(t *testing.T) {
+		_, err := RepeatN(nil, NewAtom("foo"), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeInteger, NewAtom("foo"), nil), err)
+	})
 
-	assert.Equal(t, 1, c)
+	t.Run("n is negative", func(t *testing.T) {
+		_, err := RepeatN(nil, Integer(-1), Success, nil).Force(context.Background())
+		assert.Equal(t, domainError(validDomainNotLessThanZero, Integer(-1), nil), err)
+	})
 }
 
 func TestNegation(t *testing.T) {
@@ -7429,6 +10058,61 @@ func TestNegation(t *testing.T) {
 	assert.Equal(t, e, err)
 }
 
+func TestForall(t *testing.T) {
+	x := NewVariable()
+	between := NewAtom("between")
+	e := errors.New("failed")
+
+	var vm VM
+	vm.Register3(between, Between)
+	vm.Register2(atomLessThan, LessThan)
+	vm.Register0(atomFalse, func(*VM, Cont, *Env) *Promise {
+		return Bool(false)
+	})
+	vm.Register0(atomError, func(*VM, Cont, *Env) *Promise {
+		return Error(e)
+	})
+
+	tests := []struct {
+		title        string
+		cond, action Term
+		ok           bool
+		err          error
+	}{
+		{title: "action succeeds for all", cond: between.Apply(Integer(1), Integer(3), x), action: atomLessThan.Apply(x, Integer(4)), ok: true},
+		{title: "action fails for one", cond: between.Apply(Integer(1), Integer(3), x), action: atomLessThan.Apply(x, Integer(3)), ok: false},
+		{title: "cond fails", cond: atomFalse, action: atomFalse, ok: true},
+		{title: "cut in action", cond: between.Apply(Integer(1), Integer(3), x), action: atomComma.Apply(atomCut, atomLessThan.Apply(x, Integer(4))), ok: true},
+		{title: "error in action", cond: between.Apply(Integer(1), Integer(3), x), action: atomError, err: e},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := Forall(&vm, tt.cond, tt.action, func(env *Env) *Promise {
+				assert.Equal(t, x, env.Resolve(x))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+
+	t.Run("stops at the first failure", func(t *testing.T) {
+		var n int
+		vm.Register1(NewAtom("check"), func(_ *VM, x Term, k Cont, env *Env) *Promise {
+			n++
+			if env.Resolve(x) == Integer(2) {
+				return Bool(false)
+			}
+			return k(env)
+		})
+		ok, err := Forall(&vm, between.Apply(Integer(1), Integer(5), x), NewAtom("check").Apply(x), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, 2, n)
+	})
+}
+
 func TestAppend(t *testing.T) {
 	xs, ys, zs := NewVariable(), NewVariable(), NewVariable()
 	tests := []struct {
@@ -7573,6 +10257,247 @@ func (m *mockWriter) Write(p []byte) (int, error) {
 	return args.Int(0), args.Error(1)
 }
 
+// sizeWriter is an io.Writer which discards the bytes but records the total and the largest size of the writes.
+type sizeWriter struct {
+	total, max int
+}
+
+func (w *sizeWriter) Write(p []byte) (int, error) {
+	w.total += len(p)
+	if len(p) > w.max {
+		w.max = len(p)
+	}
+	return len(p), nil
+}
+
+func TestPrintMessage(t *testing.T) {
+	tests := []struct {
+		title         string
+		kind, message Term
+		output        string
+		err           error
+	}{
+		{title: "format", kind: NewAtom("informational"), message: atomFormat.Apply(NewAtom("~a is ~d~n~q~~"), List(NewAtom("foo"), Integer(1), NewAtom("Bar"))), output: "foo is 1\n'Bar'~\n"},
+		{title: "format with a non-list argument", kind: NewAtom("informational"), message: atomFormat.Apply(NewAtom("hello ~w"), NewAtom("world")), output: "hello world\n"},
+		{title: "format with aligned columns", kind: NewAtom("informational"), message: atomFormat.Apply(NewAtom("~a~t~20|~a~nxyzzy~t~20|~a~n"), List(NewAtom("foo"), NewAtom("bar"), NewAtom("baz"))), output: "foo                 bar\nxyzzy               baz\n"},
+		{title: "format with right aligned columns", kind: NewAtom("informational"), message: atomFormat.Apply(NewAtom("~t~d~6|~t~d~6+"), List(Integer(1), Integer(123))), output: "     1   123\n"},
+		{title: "format with fill characters", kind: NewAtom("informational"), message: atomFormat.Apply(NewAtom("~`-t~a~`-t~11|"), List(NewAtom("foo"))), output: "----foo----\n"},
+		{title: "format with a column stop already passed", kind: NewAtom("informational"), message: atomFormat.Apply(NewAtom("~a~t~2|~a"), List(NewAtom("foo"), NewAtom("bar"))), output: "foobar\n"},
+		{title: "format with a default column", kind: NewAtom("informational"), message: atomFormat.Apply(NewAtom("a~+b~n~2n"), List()), output: "a       b\n\n\n"},
+		{title: "format with an unexpected argument", kind: NewAtom("informational"), message: atomFormat.Apply(NewAtom("~2a"), List(NewAtom("foo"))), output: "format('~2a',[foo])\n"},
+		{title: "format with wrong arguments", kind: NewAtom("informational"), message: atomFormat.Apply(NewAtom("~d"), List(NewAtom("a"))), output: "format('~d',[a])\n"},
+		{title: "format with an unknown directive", kind: NewAtom("informational"), message: atomFormat.Apply(NewAtom("~z"), List()), output: "format('~z',[])\n"},
+		{title: "singletons", kind: atomWarning, message: atomSingletons.Apply(List(NewAtom("X"), NewAtom("Y"))), output: "Warning: Singleton variables: [X,Y]\n"},
+		{title: "error", kind: atomError, message: atomError.Apply(atomTypeError.Apply(atomInteger, NewAtom("a")), NewVariable()), output: "Error: error(type_error(integer,a),_"},
+		{title: "unexpected message", kind: NewAtom("foo"), message: NewAtom("f").Apply(NewAtom("Foo"), Integer(1)), output: "f('Foo',1)\n"},
+		{title: "silent", kind: atomSilent, message: NewAtom("foo"), output: ""},
+		{title: "kind is a variable", kind: NewVariable(), message: NewAtom("foo"), err: InstantiationError(nil)},
+		{title: "kind is not an atom", kind: Integer(0), message: NewAtom("foo"), err: typeError(validTypeAtom, Integer(0), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			var buf bytes.Buffer
+			var vm VM
+			vm.SetUserError(NewOutputTextStream(&buf))
+			ok, err := PrintMessage(&vm, tt.kind, tt.message, Success, nil).Force(context.Background())
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.err == nil, ok)
+			assert.True(t, strings.HasPrefix(buf.String(), tt.output), buf.String())
+		})
+	}
+
+	t.Run("message_hook", func(t *testing.T) {
+		var buf bytes.Buffer
+		var vm VM
+		vm.SetUserError(NewOutputTextStream(&buf))
+		var lines Term
+		vm.Register3(atomMessageHook, func(_ *VM, message, kind, ls Term, k Cont, env *Env) *Promise {
+			lines = env.Resolve(ls)
+			return k(env)
+		})
+		ok, err := PrintMessage(&vm, atomWarning, atomFormat.Apply(NewAtom("a~nb"), List()), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, List(NewAtom("a"), NewAtom("b")), lines)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("message_hook is interrupted by the context", func(t *testing.T) {
+		var vm VM
+		vm.SetUserError(NewOutputTextStream(io.Discard))
+		var loop func(context.Context) *Promise
+		loop = func(context.Context) *Promise {
+			return Delay(loop)
+		}
+		vm.Register3(atomMessageHook, func(_ *VM, message, kind, ls Term, k Cont, env *Env) *Promise {
+			return Delay(loop)
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		ok, err := PrintMessage(&vm, atomWarning, NewAtom("foo"), Success, nil).Force(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("MessageHook", func(t *testing.T) {
+		x := NewVariable()
+		env := NewEnv().bind(x, NewAtom("bar"))
+		for _, tt := range []struct {
+			title  string
+			lines  []Term
+			ok     bool
+			output string
+		}{
+			{title: "lines", lines: []Term{NewAtom("hello"), atomFormat.Apply(NewAtom("~a ~d"), List(NewAtom("world"), Integer(1)))}, ok: true, output: "Warning: hello\nWarning: world 1\n"},
+			{title: "suppressed", ok: true, output: ""},
+			{title: "declined", ok: false, output: "Warning: foo(bar)\n"},
+		} {
+			t.Run(tt.title, func(t *testing.T) {
+				var buf bytes.Buffer
+				var vm VM
+				vm.SetUserError(NewOutputTextStream(&buf))
+				vm.MessageHook = func(kind string, message Term) ([]Term, bool) {
+					assert.Equal(t, "warning", kind)
+					assert.Equal(t, NewAtom("foo").Apply(NewAtom("bar")), message)
+					return tt.lines, tt.ok
+				}
+				ok, err := PrintMessage(&vm, atomWarning, NewAtom("foo").Apply(x), Success, env).Force(context.Background())
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.output, buf.String())
+			})
+		}
+	})
+
+	t.Run("no user_error", func(t *testing.T) {
+		var vm VM
+		ok, err := PrintMessage(&vm, atomWarning, NewAtom("foo"), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		title        string
+		format, args Term
+		output       string
+		err          error
+	}{
+		{title: "write", format: NewAtom("~w ~p ~q ~a"), args: List(NewAtom("Foo"), NewAtom("Bar"), NewAtom("Baz"), NewAtom("Qux")), output: "Foo Bar 'Baz' Qux"},
+		{title: "non-list argument", format: NewAtom("hello ~w"), args: NewAtom("world"), output: "hello world"},
+		{title: "code list format", format: CodeList("~w~~"), args: List(Integer(1)), output: "1~"},
+		{title: "character list format", format: CharList("~w"), args: List(Integer(1)), output: "1"},
+		{title: "decimal", format: NewAtom("~d ~2d ~3d ~D ~2D"), args: List(Integer(-42), Integer(1234), Integer(5), Integer(1234567), Integer(-1234567)), output: "-42 12.34 0.005 1,234,567 -12,345.67"},
+		{title: "radix", format: NewAtom("~8r ~16r ~16R"), args: List(Integer(8), Integer(255), Integer(255)), output: "10 ff FF"},
+		{title: "float", format: NewAtom("~e ~4f ~0f ~g"), args: List(Float(1.5), Float(3.14159), Integer(2), Float(0.1)), output: "1.500000e+00 3.1416 2 0.1"},
+		{title: "character", format: NewAtom("~c~3c~*c"), args: List(Integer('a'), Integer('b'), Integer(2), Integer('c')), output: "abbbcc"},
+		{title: "string", format: NewAtom("~s~s"), args: List(CodeList("foo"), CharList("bar")), output: "foobar"},
+		{title: "ignore", format: NewAtom("~i~w"), args: List(Integer(1), Integer(2)), output: "2"},
+		{title: "new line", format: NewAtom("a~nb~2n"), args: List(), output: "a\nb\n\n"},
+		{title: "columns", format: NewAtom("~w~t~10|~w~n~t~w~10|~t~w~5+"), args: List(NewAtom("foo"), NewAtom("bar"), NewAtom("baz"), Integer(1)), output: "foo       bar\n       baz    1"},
+		{title: "format is a variable", format: NewVariable(), args: List(), err: InstantiationError(nil)},
+		{title: "format is not text", format: Integer(1), args: List(), err: typeError(validTypeList, Integer(1), nil)},
+		{title: "unknown directive", format: NewAtom("~z"), args: List(), err: syntaxError(errors.New("format: unknown directive ~z"), nil)},
+		{title: "incomplete directive", format: NewAtom("foo~"), args: List(), err: syntaxError(errors.New("format: incomplete directive"), nil)},
+		{title: "unexpected argument", format: NewAtom("~2a"), args: List(NewAtom("foo")), err: syntaxError(errors.New("format: unexpected argument of ~a"), nil)},
+		{title: "no radix", format: NewAtom("~r"), args: List(Integer(1)), err: syntaxError(errors.New("format: no radix"), nil)},
+		{title: "radix out of range", format: NewAtom("~37r"), args: List(Integer(1)), err: syntaxError(errors.New("format: radix not in 2..36"), nil)},
+		{title: "not enough arguments", format: NewAtom("~w ~w"), args: List(Integer(1)), err: syntaxError(errors.New("format: not enough arguments"), nil)},
+		{title: "too many arguments", format: NewAtom("~w"), args: List(Integer(1), Integer(2)), err: syntaxError(errors.New("format: too many arguments"), nil)},
+		{title: "not an atom", format: NewAtom("~a"), args: List(Integer(1)), err: typeError(validTypeAtom, Integer(1), nil)},
+		{title: "not an integer", format: NewAtom("~d"), args: List(NewAtom("a")), err: typeError(validTypeInteger, NewAtom("a"), nil)},
+		{title: "not a number", format: NewAtom("~f"), args: List(NewAtom("a")), err: typeError(validTypeNumber, NewAtom("a"), nil)},
+		{title: "negative column", format: NewAtom("~*c"), args: List(Integer(-1), Integer('a')), err: domainError(validDomainNotLessThanZero, Integer(-1), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			var sb strings.Builder
+			var vm VM
+			ok, err := Format(&vm, NewOutputTextStream(&sb), tt.format, tt.args, Success, nil).Force(context.Background())
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.err == nil, ok)
+			if tt.err == nil {
+				assert.Equal(t, tt.output, sb.String())
+			}
+		})
+	}
+
+	t.Run("column of the stream", func(t *testing.T) {
+		var sb strings.Builder
+		s := NewOutputTextStream(&sb)
+		for _, r := range "foo" {
+			_, _ = s.WriteRune(r)
+		}
+
+		var vm VM
+		ok, err := Format(&vm, s, NewAtom("~t~w~6|"), List(Integer(1)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "foo  1", sb.String())
+		assert.Equal(t, 6, s.column)
+	})
+
+	t.Run("large column", func(t *testing.T) {
+		var w sizeWriter
+		var vm VM
+		ok, err := Format(&vm, NewOutputTextStream(&w), NewAtom("~t~w~1000000|~w~t~1000000+"), List(Integer(1), Integer(2)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 2000000, w.total)
+		assert.LessOrEqual(t, w.max, 1024)
+	})
+
+	t.Run("large count", func(t *testing.T) {
+		var w sizeWriter
+		var vm VM
+		ok, err := Format(&vm, NewOutputTextStream(&w), NewAtom("~1000000n~1000000c~*c"), List(Integer('a'), Integer(1000000), Integer('b')), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 3000000, w.total)
+		assert.LessOrEqual(t, w.max, 1024)
+	})
+
+	t.Run("sink", func(t *testing.T) {
+		for _, tt := range []struct {
+			sink   Atom
+			output Term
+		}{
+			{sink: atomAtom, output: NewAtom("a1")},
+			{sink: atomCodes, output: CodeList("a1")},
+			{sink: atomChars, output: CharList("a1")},
+			{sink: atomString, output: CharList("a1")},
+		} {
+			var vm VM
+			v := NewVariable()
+			ok, err := Format(&vm, tt.sink.Apply(v), NewAtom("~a~d"), List(NewAtom("a"), Integer(1)), func(env *Env) *Promise {
+				assert.Equal(t, tt.output, env.Resolve(v))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}
+	})
+
+	t.Run("sink is not valid", func(t *testing.T) {
+		var vm VM
+		sink := NewAtom("foo").Apply(NewAtom("bar"))
+		ok, err := Format(&vm, sink, NewAtom(""), List(), Success, nil).Force(context.Background())
+		assert.Equal(t, domainError(validDomainOutputSink, sink, nil), err)
+		assert.False(t, ok)
+	})
+
+	t.Run("input stream", func(t *testing.T) {
+		var vm VM
+		s := NewInputTextStream(nil)
+		ok, err := Format(&vm, s, NewAtom(""), List(), Success, nil).Force(context.Background())
+		assert.Equal(t, permissionError(operationOutput, permissionTypeStream, s, nil), err)
+		assert.False(t, ok)
+	})
+}
+
 func setMemFree(n int64) func() {
 	if n <= 0 {
 		return func() {}
@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"errors"
+	"math"
 )
 
 type userDefined struct {
@@ -13,6 +14,240 @@ type userDefined struct {
 
 	// 7.4.3 says "If no clauses are defined for a procedure indicated by a directive ... then the procedure shall exist but have no clauses."
 	clauses
+
+	// reserve is the unused room in front of clauses in the same backing array so that asserta/1 doesn't copy all the clauses every time.
+	reserve clauses
+
+	// index narrows down the clauses to try by the first argument. It's nil until the procedure gets indexThreshold clauses.
+	index *clauseIndex
+}
+
+// indexThreshold is the number of clauses from which a procedure is indexed. Scanning fewer clauses is cheap enough.
+const indexThreshold = 8
+
+func (u *userDefined) call(vm *VM, args []Term, k Cont, env *Env) *Promise {
+	if u.index != nil && len(args) > 0 {
+		if cs, ok := u.index.lookup(args[0], env); ok {
+			return cs.call(vm, args, k, env)
+		}
+	}
+	return u.clauses.call(vm, args, k, env)
+}
+
+// append adds cs after the clauses.
+func (u *userDefined) append(cs clauses) {
+	u.clauses = append(u.clauses, cs...)
+	switch {
+	case u.index != nil:
+		for _, c := range cs {
+			u.index.append(c)
+		}
+	case len(u.clauses) >= indexThreshold && u.clauses[0].pi.arity > 0:
+		u.index = newClauseIndex(u.clauses)
+	}
+}
+
+// prepend adds cs in front of the clauses.
+func (u *userDefined) prepend(cs clauses) {
+	prependClauses(&u.clauses, &u.reserve, cs)
+	switch {
+	case u.index != nil:
+		for i := len(cs) - 1; i >= 0; i-- {
+			u.index.prepend(cs[i])
+		}
+	case len(u.clauses) >= indexThreshold && u.clauses[0].pi.arity > 0:
+		u.index = newClauseIndex(u.clauses)
+	}
+}
+
+// remove removes c from the clauses while keeping the order of the rest. It reports whether c was found.
+func (u *userDefined) remove(c clause) bool {
+	if !removeClause(&u.clauses, c) {
+		return false
+	}
+	if u.index != nil {
+		u.index.remove(c)
+	}
+	return true
+}
+
+// clone returns a copy of u which shares no backing arrays with u so that modifying either doesn't affect the other.
+func (u *userDefined) clone() userDefined {
+	c := *u
+	c.clauses = append(clauses(nil), u.clauses...)
+	c.reserve = nil
+	if u.index != nil {
+		c.index = newClauseIndex(c.clauses)
+	}
+	return c
+}
+
+// prependClauses adds added in front of cs.
+// When there's not enough room in front, it reallocates cs with as much room in front as cs so that repeated prepends are amortized O(1).
+// The room after cs is kept as well so that assertz/1 can append in place.
+func prependClauses(cs, reserve *clauses, added clauses) {
+	n := len(added)
+	if len(*reserve) < n || len(*cs) == 0 || &(*reserve)[:len(*reserve)+1][len(*reserve)] != &(*cs)[0] {
+		room := len(*cs) + n
+		buf := make(clauses, room+len(*cs)+room)
+		copy(buf[room:], *cs)
+		*reserve, *cs = buf[:room], buf[room:room+len(*cs)]
+	}
+	m := len(*reserve) - n
+	copy((*reserve)[m:], added)
+	*cs = (*reserve)[m : len(*reserve)+len(*cs) : cap(*reserve)]
+	*reserve = (*reserve)[:m]
+}
+
+// removeClause removes c from cs while keeping the order of the rest. It reports whether c was found.
+func removeClause(cs *clauses, c clause) bool {
+	for i, d := range *cs {
+		if id(d.raw) == id(c.raw) {
+			*cs, (*cs)[len(*cs)-1] = append((*cs)[:i], (*cs)[i+1:]...), clause{}
+			return true
+		}
+	}
+	return false
+}
+
+// clauseIndex is a first-argument index of clauses.
+// Every bucket holds the clauses which may match a goal of which the first argument has the key in the original order.
+// Clauses of which the first argument is a variable, or can't be a key, are in every bucket as well as in others.
+type clauseIndex struct {
+	buckets map[clauseKey]clauseBucket
+	others  clauseBucket
+}
+
+type clauseBucket struct {
+	clauses
+	reserve clauses
+}
+
+// clauseKey is either an atomic term or the principal functor of a compound term.
+// It's a comparable struct rather than a Term so that it doesn't allocate as a map key.
+type clauseKey struct {
+	kind  clauseKeyKind
+	bits  uint64
+	arity int
+}
+
+type clauseKeyKind int8
+
+const (
+	clauseKeyAtom clauseKeyKind = iota
+	clauseKeyInteger
+	clauseKeyFloat
+	clauseKeyCompound
+)
+
+func newClauseIndex(cs clauses) *clauseIndex {
+	idx := clauseIndex{buckets: map[clauseKey]clauseBucket{}}
+	for _, c := range cs {
+		idx.append(c)
+	}
+	return &idx
+}
+
+// lookup returns the clauses which may match a goal of which the first argument is arg.
+// It reports false if arg is a variable or can't be a key so that all the clauses have to be tried.
+func (idx *clauseIndex) lookup(arg Term, env *Env) (clauses, bool) {
+	key, ok := indexKey(arg, env)
+	if !ok {
+		return nil, false
+	}
+	if b, ok := idx.buckets[key]; ok {
+		return b.clauses, true
+	}
+	return idx.others.clauses, true
+}
+
+func (idx *clauseIndex) append(c clause) {
+	key, ok := c.indexKey()
+	if !ok {
+		idx.others.clauses = append(idx.others.clauses, c)
+		for k, b := range idx.buckets {
+			b.clauses = append(b.clauses, c)
+			idx.buckets[k] = b
+		}
+		return
+	}
+	b, ok := idx.buckets[key]
+	if !ok {
+		b.clauses = append(clauses(nil), idx.others.clauses...)
+	}
+	b.clauses = append(b.clauses, c)
+	idx.buckets[key] = b
+}
+
+func (idx *clauseIndex) prepend(c clause) {
+	key, ok := c.indexKey()
+	if !ok {
+		prependClauses(&idx.others.clauses, &idx.others.reserve, clauses{c})
+		for k, b := range idx.buckets {
+			prependClauses(&b.clauses, &b.reserve, clauses{c})
+			idx.buckets[k] = b
+		}
+		return
+	}
+	b, ok := idx.buckets[key]
+	if !ok {
+		idx.buckets[key] = clauseBucket{clauses: append(clauses{c}, idx.others.clauses...)}
+		return
+	}
+	prependClauses(&b.clauses, &b.reserve, clauses{c})
+	idx.buckets[key] = b
+}
+
+func (idx *clauseIndex) remove(c clause) {
+	key, ok := c.indexKey()
+	if !ok {
+		removeClause(&idx.others.clauses, c)
+		for k, b := range idx.buckets {
+			removeClause(&b.clauses, c)
+			idx.buckets[k] = b
+		}
+		return
+	}
+	if b, ok := idx.buckets[key]; ok {
+		removeClause(&b.clauses, c)
+		idx.buckets[key] = b
+	}
+}
+
+// indexKey returns the key of the clause by the first argument of the head.
+func (c *clause) indexKey() (clauseKey, bool) {
+	t := c.raw
+	if r, ok := t.(Compound); ok && r.Functor() == atomIf && r.Arity() == 2 {
+		t = r.Arg(0)
+	}
+	h, ok := t.(Compound)
+	if !ok {
+		return clauseKey{}, false
+	}
+	return indexKey(h.Arg(0), nil)
+}
+
+// indexKey returns the key of t. It reports false for variables and terms which can't be keys.
+func indexKey(t Term, env *Env) (clauseKey, bool) {
+	switch t := env.Resolve(t).(type) {
+	case Atom:
+		return clauseKey{kind: clauseKeyAtom, bits: uint64(t)}, true
+	case Integer:
+		return clauseKey{kind: clauseKeyInteger, bits: uint64(t)}, true
+	case Float:
+		switch {
+		case t != t: // NaN doesn't unify with anything.
+			return clauseKey{}, false
+		case t == 0: // 0.0 and -0.0 unify.
+			return clauseKey{kind: clauseKeyFloat}, true
+		default:
+			return clauseKey{kind: clauseKeyFloat, bits: math.Float64bits(float64(t))}, true
+		}
+	case Compound:
+		return clauseKey{kind: clauseKeyCompound, bits: uint64(t.Functor()), arity: t.Arity()}, true
+	default:
+		return clauseKey{}, false
+	}
 }
 
 type clauses []clause
@@ -56,6 +291,129 @@ func compile(t Term, env *Env) (clauses, error) {
 	return []clause{c}, err
 }
 
+// compiledClauses is a compiled term kept in the compilation cache.
+type compiledClauses struct {
+	raw     Term
+	clauses clauses
+}
+
+// compileCache is the compilation cache of a Prolog text keyed by variantHash.
+type compileCache map[uint64]compiledClauses
+
+// compileCached compiles t in text like compile but reuses the bytecode of a variant of t if any in the previous load of
+// the same source. Since the bytecode refers to variables by their offsets, it's shared between variants. A changed
+// clause isn't a variant of the cached one and thus gets compiled anew.
+func (vm *VM) compileCached(text *text, t Term, env *Env) (clauses, error) {
+	t = env.Resolve(t)
+	key := variantHash(t, env)
+	c, ok := text.compiled[key]
+	if !ok {
+		c, ok = vm.compiled[text.source][key]
+	}
+	if ok && sameVariant(c.raw, t, env) {
+		text.compiled[key] = c
+		raw := t
+		if c, ok := t.(Compound); !ok || c.Functor() != atomIf || c.Arity() != 2 {
+			raw = env.simplify(t)
+		}
+		cs := make(clauses, len(c.clauses))
+		for i, c := range c.clauses {
+			c.raw = raw
+			cs[i] = c
+		}
+		return cs, nil
+	}
+
+	cs, err := compile(t, env)
+	if err != nil {
+		return nil, err
+	}
+	text.compiled[key] = compiledClauses{raw: t, clauses: cs}
+	return cs, nil
+}
+
+// sameVariant checks if x and y are variants, i.e. the same up to a one-to-one renaming of variables.
+func sameVariant(x, y Term, env *Env) bool {
+	var xs, ys []Variable
+	var walk func(x, y Term) bool
+	walk = func(x, y Term) bool {
+		x, y = env.Resolve(x), env.Resolve(y)
+		switch x := x.(type) {
+		case Variable:
+			y, ok := y.(Variable)
+			if !ok {
+				return false
+			}
+			for i := range xs {
+				if xs[i] == x || ys[i] == y {
+					return xs[i] == x && ys[i] == y
+				}
+			}
+			xs, ys = append(xs, x), append(ys, y)
+			return true
+		case Compound:
+			y, ok := y.(Compound)
+			if !ok || x.Functor() != y.Functor() || x.Arity() != y.Arity() {
+				return false
+			}
+			for i := 0; i < x.Arity(); i++ {
+				if !walk(x.Arg(i), y.Arg(i)) {
+					return false
+				}
+			}
+			return true
+		default:
+			return x == y
+		}
+	}
+	return walk(x, y)
+}
+
+// variantHash returns a hash of t which is the same for variants.
+func variantHash(t Term, env *Env) uint64 {
+	const prime = 1099511628211
+	h := uint64(14695981039346656037)
+	mix := func(x uint64) {
+		h = (h ^ x) * prime
+	}
+	var vars []Variable
+	var walk func(t Term)
+	walk = func(t Term) {
+		switch t := env.Resolve(t).(type) {
+		case Variable:
+			mix(1)
+			for i, v := range vars {
+				if v == t {
+					mix(uint64(i))
+					return
+				}
+			}
+			vars = append(vars, t)
+			mix(uint64(len(vars) - 1))
+		case Atom:
+			mix(2)
+			mix(uint64(t))
+		case Integer:
+			mix(3)
+			mix(uint64(t))
+		case Float:
+			mix(4)
+			mix(math.Float64bits(float64(t)))
+		case Compound:
+			mix(5)
+			mix(uint64(t.Functor()))
+			mix(uint64(t.Arity()))
+			for i := 0; i < t.Arity(); i++ {
+				walk(t.Arg(i))
+			}
+		default:
+			mix(6)
+		}
+	}
+	walk(t)
+	return h
+}
+
 type clause struct {
 	pi       procedureIndicator
 	raw      Term
@@ -113,6 +471,17 @@ func (c *clause) compilePred(p Term, env *Env) error {
 		c.bytecode = append(c.bytecode, instruction{opcode: opCall, operand: procedureIndicator{name: p, arity: 0}})
 		return nil
 	case Compound:
+		if p.Arity() == 2 {
+			switch p.Functor() {
+			case atomSemiColon:
+				if cond, ok := env.Resolve(p.Arg(0)).(Compound); ok && cond.Functor() == atomThen && cond.Arity() == 2 {
+					return c.compileControl(opIfThenElse, env, cond.Arg(0), cond.Arg(1), p.Arg(1))
+				}
+				return c.compileControl(opOr, env, p.Arg(0), p.Arg(1))
+			case atomThen:
+				return c.compileControl(opIfThenElse, env, p.Arg(0), p.Arg(1), nil)
+			}
+		}
 		for i := 0; i < p.Arity(); i++ {
 			c.compileBodyArg(p.Arg(i), env)
 		}
@@ -123,6 +492,26 @@ func (c *clause) compilePred(p Term, env *Env) error {
 	}
 }
 
+// compileControl compiles a control construct in a clause body. Each of goals is compiled into a separate bytecode
+// which shares the variables with the clause. A nil goal results in a nil bytecode.
+func (c *clause) compileControl(opcode opcode, env *Env, goals ...Term) error {
+	branches := make([]bytecode, len(goals))
+	outer := c.bytecode
+	for i, g := range goals {
+		if g == nil {
+			continue
+		}
+		c.bytecode = nil
+		if err := c.compileBody(g, env); err != nil {
+			c.bytecode = outer
+			return err
+		}
+		branches[i] = append(c.bytecode, instruction{opcode: opExit})
+	}
+	c.bytecode = append(outer, instruction{opcode: opcode, branches: branches})
+	return nil
+}
+
 func (c *clause) compileHeadArg(a Term, env *Env) {
 	switch a := env.Resolve(a).(type) {
 	case Variable:
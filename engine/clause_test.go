@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserDefined_call(t *testing.T) {
+	foo := NewAtom("foo")
+	x, y := NewVariable(), NewVariable()
+
+	newVM := func(t *testing.T) *VM {
+		var vm VM
+		for _, c := range []Term{
+			foo.Apply(NewAtom("a"), Integer(1)),
+			foo.Apply(NewAtom("b"), Integer(2)),
+			foo.Apply(NewVariable(), Integer(3)),
+			foo.Apply(Integer(1), Integer(4)),
+			foo.Apply(NewAtom("f").Apply(NewAtom("a")), Integer(5)),
+			foo.Apply(NewAtom("a"), Integer(6)),
+			foo.Apply(Float(1), Integer(7)),
+			foo.Apply(NewAtom("f").Apply(NewAtom("b")), Integer(8)),
+			foo.Apply(NewAtom("a"), Integer(9)),
+		} {
+			_, err := Assertz(&vm, c, Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+		}
+		return &vm
+	}
+
+	solutions := func(t *testing.T, vm *VM, first Term) []Term {
+		var ret []Term
+		ok, err := Call(vm, foo.Apply(first, y), func(env *Env) *Promise {
+			ret = append(ret, env.Resolve(y))
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		return ret
+	}
+
+	t.Run("indexed", func(t *testing.T) {
+		vm := newVM(t)
+		assert.NotNil(t, vm.procedures[procedureIndicator{name: foo, arity: 2}].(*userDefined).index)
+	})
+
+	tests := []struct {
+		title string
+		first Term
+		want  []Term
+	}{
+		{title: "atom", first: NewAtom("a"), want: []Term{Integer(1), Integer(3), Integer(6), Integer(9)}},
+		{title: "integer", first: Integer(1), want: []Term{Integer(3), Integer(4)}},
+		{title: "float", first: Float(1), want: []Term{Integer(3), Integer(7)}},
+		{title: "compound", first: NewAtom("f").Apply(x), want: []Term{Integer(3), Integer(5), Integer(8)}},
+		{title: "unknown key", first: NewAtom("c"), want: []Term{Integer(3)}},
+		{title: "variable", first: x, want: []Term{Integer(1), Integer(2), Integer(3), Integer(4), Integer(5), Integer(6), Integer(7), Integer(8), Integer(9)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			assert.Equal(t, tt.want, solutions(t, newVM(t), tt.first))
+		})
+	}
+
+	t.Run("asserta", func(t *testing.T) {
+		vm := newVM(t)
+		_, err := Asserta(vm, foo.Apply(NewAtom("a"), Integer(0)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		_, err = Asserta(vm, foo.Apply(NewVariable(), Integer(-1)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []Term{Integer(-1), Integer(0), Integer(1), Integer(3), Integer(6), Integer(9)}, solutions(t, vm, NewAtom("a")))
+		assert.Equal(t, []Term{Integer(-1), Integer(3), Integer(4)}, solutions(t, vm, Integer(1)))
+		assertIndexConsistent(t, vm.procedures[procedureIndicator{name: foo, arity: 2}].(*userDefined))
+	})
+
+	t.Run("assertz", func(t *testing.T) {
+		vm := newVM(t)
+		_, err := Assertz(vm, foo.Apply(NewVariable(), Integer(10)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		_, err = Assertz(vm, foo.Apply(NewAtom("c"), Integer(11)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []Term{Integer(1), Integer(3), Integer(6), Integer(9), Integer(10)}, solutions(t, vm, NewAtom("a")))
+		assert.Equal(t, []Term{Integer(3), Integer(10), Integer(11)}, solutions(t, vm, NewAtom("c")))
+		assertIndexConsistent(t, vm.procedures[procedureIndicator{name: foo, arity: 2}].(*userDefined))
+	})
+
+	t.Run("retract", func(t *testing.T) {
+		vm := newVM(t)
+		_, err := Retract(vm, foo.Apply(NewAtom("a"), Integer(6)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		_, err = Retract(vm, foo.Apply(NewAtom("z"), Integer(3)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []Term{Integer(1), Integer(9)}, solutions(t, vm, NewAtom("a")))
+		assert.Equal(t, []Term{Integer(4)}, solutions(t, vm, Integer(1)))
+		assertIndexConsistent(t, vm.procedures[procedureIndicator{name: foo, arity: 2}].(*userDefined))
+	})
+}
+
+// assertIndexConsistent checks if every bucket has exactly the clauses which may match the key in the original order.
+func assertIndexConsistent(t *testing.T, u *userDefined) {
+	t.Helper()
+	others := clauses{}
+	for _, c := range u.clauses {
+		if _, ok := c.indexKey(); !ok {
+			others = append(others, c)
+		}
+	}
+	assert.Equal(t, others, append(clauses{}, u.index.others.clauses...))
+	for key, b := range u.index.buckets {
+		want := clauses{}
+		for _, c := range u.clauses {
+			if k, ok := c.indexKey(); !ok || k == key {
+				want = append(want, c)
+			}
+		}
+		assert.Equal(t, want, append(clauses{}, b.clauses...))
+	}
+}
+
+func BenchmarkUserDefined_call(b *testing.B) {
+	foo := NewAtom("foo")
+	var vm VM
+	for i := 0; i < 10000; i++ {
+		_, err := Assertz(&vm, foo.Apply(Integer(i), NewAtom("bar")), Success, nil).Force(context.Background())
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ok, err := Call(&vm, foo.Apply(Integer(i%10000), NewVariable()), Success, nil).Force(context.Background())
+		if err != nil || !ok {
+			b.Fatal(ok, err)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package engine
 
 import (
+	"bufio"
 	"bytes"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -36,6 +38,8 @@ func TestWriteCompound(t *testing.T) {
 		{title: "list", term: List(NewAtom(`a`), NewAtom(`b`), NewAtom(`c`)), output: `[a,b,c]`},
 		{title: "list-ish", term: PartialList(NewAtom(`rest`), NewAtom(`a`), NewAtom(`b`)), output: `[a,b|rest]`},
 		{title: "circular list", term: l, output: `[a,b,a|...]`},
+		{title: "list ignore_ops(true)", term: List(NewAtom(`a`), NewAtom(`b`)), opts: WriteOptions{ignoreOps: true, quoted: true}, output: `'.'(a,'.'(b,[]))`},
+		{title: "list-ish ignore_ops(true)", term: PartialList(NewAtom(`rest`), NewAtom(`a`), NewAtom(`b`)), opts: WriteOptions{ignoreOps: true, quoted: true}, output: `'.'(a,'.'(b,rest))`},
 		{title: "curly brackets", term: atomEmptyBlock.Apply(NewAtom(`foo`)), output: `{foo}`},
 		{title: "fx", term: atomIf.Apply(atomIf.Apply(NewAtom(`foo`))), opts: WriteOptions{ops: ops, priority: 1201}, output: `:- (:-foo)`},
 		{title: "fy", term: atomNegation.Apply(atomMinus.Apply(atomNegation.Apply(NewAtom(`foo`)))), opts: WriteOptions{ops: ops, priority: 1201}, output: `\+ - (\+foo)`},
@@ -64,6 +68,48 @@ func TestWriteCompound(t *testing.T) {
 	}
 }
 
+func TestWriteCompound_roundTrip(t *testing.T) {
+	tail, x, y, z := NewVariable(), NewVariable(), NewVariable(), NewVariable()
+	terms := []struct {
+		title string
+		term  Term
+	}{
+		{title: "empty list", term: atomEmptyList},
+		{title: "list", term: List(NewAtom(`a`), NewAtom(`b`), NewAtom(`c`))},
+		{title: "partial list", term: PartialList(tail, NewAtom(`a`), NewAtom(`b`))},
+		{title: "operator as functor", term: atomPlus.Apply(Integer(1), Integer(2))},
+		{title: "operator as atom", term: atomPlus},
+		{title: "prefix operator", term: atomMinus.Apply(NewAtom(`a`))},
+		{title: "prefix operator and number", term: atomMinus.Apply(Integer(1))},
+		{title: "operators as arguments", term: NewAtom(`f`).Apply(atomIf, atomMinus, atomComma)},
+		{title: "alphanumeric operators", term: NewAtom(`is`).Apply(x, NewAtom(`mod`).Apply(y, z))},
+		{title: "alphanumeric operators and floats", term: NewAtom(`is`).Apply(x, NewAtom(`rem`).Apply(Float(1), Float(2)))},
+		{title: "clause", term: atomIf.Apply(NewAtom(`a`), atomComma.Apply(NewAtom(`b`), NewAtom(`c`)))},
+	}
+
+	var vm VM
+	vm.InstallDefaultOperators()
+	for _, ignoreOps := range []bool{false, true} {
+		for _, tt := range terms {
+			t.Run(fmt.Sprintf("%s ignore_ops(%t)", tt.title, ignoreOps), func(t *testing.T) {
+				var buf bytes.Buffer
+				assert.NoError(t, tt.term.WriteTerm(&buf, &WriteOptions{ignoreOps: ignoreOps, quoted: true, ops: vm.operators, priority: 1200}, nil))
+				buf.WriteString(" .") // A symbol char atom followed by a period would be read as another atom.
+
+				p := NewParser(&vm, bufio.NewReader(&buf))
+				parsed, err := p.Term()
+				assert.NoError(t, err)
+
+				env := NewEnv()
+				for i, v := range env.freeVariables(tt.term) {
+					env = env.bind(p.Vars[i].Variable, v)
+				}
+				assert.Equal(t, 0, tt.term.Compare(parsed, env))
+			})
+		}
+	}
+}
+
 func TestCompareCompound(t *testing.T) {
 	x := NewVariable()
 
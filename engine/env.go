@@ -24,6 +24,8 @@ const (
 )
 
 // Env is a mapping from variables to terms.
+// An Env is immutable. Binding a variable results in a new Env and leaves the original one intact.
+// Thus, an Env is safe for concurrent use and each query owns the bindings made in it.
 type Env struct {
 	// basically, this is Red-Black tree from Purely Functional Data Structures by Okazaki.
 	color       color
@@ -80,9 +82,10 @@ func (e *Env) bind(v Variable, t Term) *Env {
 	if node == nil {
 		node = rootEnv
 	}
-	ret := *node.insert(k, t)
+	// insert always returns a new node so that we can modify it in place.
+	ret := node.insert(k, t)
 	ret.color = black
-	return &ret
+	return ret
 }
 
 func (e *Env) insert(k envKey, v Term) *Env {
@@ -285,6 +288,10 @@ func (e *Env) unify(x, y Term, occursCheck bool) (*Env, bool) {
 		case Variable:
 			return e.unify(y, x, occursCheck)
 		case Compound:
+			// The same compound always unifies with itself without any bindings.
+			if x, ok := x.(*compound); ok && x == y {
+				return e, true
+			}
 			if x.Functor() != y.Functor() {
 				return e, false
 			}
@@ -84,3 +84,41 @@ func TestContains(t *testing.T) {
 	assert.True(t, contains(&compound{functor: NewAtom("f"), args: []Term{NewAtom("a")}}, NewAtom("a"), env))
 	assert.False(t, contains(&compound{functor: NewAtom("f")}, NewAtom("a"), env))
 }
+
+func BenchmarkEnv_Unify(b *testing.B) {
+	x, y := NewVariable(), NewVariable()
+	f := NewAtom("f")
+	t := f.Apply(NewAtom("a"), Integer(1), f.Apply(NewAtom("b")))
+
+	b.Run("identical atoms", func(b *testing.B) {
+		b.ReportAllocs()
+		var env *Env
+		for i := 0; i < b.N; i++ {
+			_, _ = env.Unify(NewAtom("a"), NewAtom("a"))
+		}
+	})
+
+	b.Run("identical compounds", func(b *testing.B) {
+		b.ReportAllocs()
+		var env *Env
+		for i := 0; i < b.N; i++ {
+			_, _ = env.Unify(t, t)
+		}
+	})
+
+	b.Run("single binding", func(b *testing.B) {
+		b.ReportAllocs()
+		var env *Env
+		for i := 0; i < b.N; i++ {
+			_, _ = env.Unify(x, t)
+		}
+	})
+
+	b.Run("compound with bindings", func(b *testing.B) {
+		b.ReportAllocs()
+		var env *Env
+		for i := 0; i < b.N; i++ {
+			_, _ = env.Unify(f.Apply(x, Integer(1), y), t)
+		}
+	})
+}
@@ -53,6 +53,7 @@ const (
 	validTypePredicateIndicator
 	validTypePair
 	validTypeFloat
+	validTypeAssoc
 )
 
 var validTypeAtoms = [...]Atom{
@@ -71,6 +72,7 @@ var validTypeAtoms = [...]Atom{
 	validTypePredicateIndicator: atomPredicateIndicator,
 	validTypePair:               atomPair,
 	validTypeFloat:              atomFloat,
+	validTypeAssoc:              atomAssoc,
 }
 
 // Term returns an Atom for the validType.
@@ -111,6 +113,11 @@ const (
 	validDomainWriteOption
 
 	validDomainOrder
+	validDomainConsultOption
+	validDomainOutputSink
+	validDomainAggregateSpec
+	validDomainCharType
+	validDomainUniqueKeyPairs
 )
 
 var validDomainAtoms = [...]Atom{
@@ -132,6 +139,11 @@ var validDomainAtoms = [...]Atom{
 	validDomainStreamProperty:    atomStreamProperty,
 	validDomainWriteOption:       atomWriteOption,
 	validDomainOrder:             atomOrder,
+	validDomainConsultOption:     atomConsultOption,
+	validDomainOutputSink:        atomOutputSink,
+	validDomainAggregateSpec:     atomAggregateSpec,
+	validDomainCharType:          atomCharType,
+	validDomainUniqueKeyPairs:    atomUniqueKeyPairs,
 }
 
 // Term returns an Atom for the validDomain.
@@ -278,11 +290,13 @@ const (
 	resourceFiniteMemory resource = iota
 
 	resourceMemory
+	resourceInferences
 )
 
 var resourceAtoms = [...]Atom{
 	resourceFiniteMemory: atomFiniteMemory,
 	resourceMemory:       atomMemory,
+	resourceInferences:   atomInferences,
 }
 
 // Term returns an Atom for the resource.
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"io"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -16,7 +17,7 @@ func (f Float) WriteTerm(w io.Writer, opts *WriteOptions, _ *Env) error {
 	ew := errWriter{w: w}
 	openClose := opts.left.name == atomMinus && opts.left.specifier.class() == operatorClassPrefix && f > 0
 
-	if openClose || (f < 0 && opts.left != operator{}) {
+	if openClose || (f < 0 && opts.left != operator{}) || letterDigit(opts.left.name) {
 		_, _ = ew.Write([]byte(" "))
 	}
 
@@ -38,20 +39,26 @@ func (f Float) WriteTerm(w io.Writer, opts *WriteOptions, _ *Env) error {
 		_, _ = ew.Write([]byte(")"))
 	}
 
-	if !openClose && opts.right != (operator{}) && (opts.right.name == atomSmallE || opts.right.name == atomE) {
+	if !openClose && opts.right != (operator{}) && (letterDigit(opts.right.name) || opts.right.name == atomE) {
 		_, _ = ew.Write([]byte(" "))
 	}
 
 	return ew.err
 }
 
-// Compare compares the Float with a Term.
+// Compare compares the Float with a Term. NaN precedes all the other numbers so that the order is total.
 func (f Float) Compare(t Term, env *Env) int {
 	switch t := env.Resolve(t).(type) {
 	case Variable:
 		return 1
 	case Float:
-		switch {
+		switch fn, tn := math.IsNaN(float64(f)), math.IsNaN(float64(t)); {
+		case fn && tn:
+			return 0
+		case fn:
+			return -1
+		case tn:
+			return 1
 		case f > t:
 			return 1
 		case f < t:
@@ -59,7 +66,13 @@ func (f Float) Compare(t Term, env *Env) int {
 		default:
 			return 0
 		}
-	default: // Integer, Atom, custom atomic terms, Compound.
+	case Integer:
+		// Numbers are ordered by value. If they're equal, the float precedes the integer.
+		if c, ok := cmpFI(f, t); !ok || c <= 0 {
+			return -1
+		}
+		return 1
+	default: // Atom, custom atomic terms, Compound.
 		return -1
 	}
 }
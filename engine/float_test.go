@@ -3,6 +3,7 @@ package engine
 import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"testing"
 )
 
@@ -22,6 +23,9 @@ func TestFloat_WriteTerm(t *testing.T) {
 		{title: "positive following unary minus", f: 33.0, opts: WriteOptions{left: operator{specifier: operatorSpecifierFX, name: atomMinus}}, output: ` (33.0)`},
 		{title: "negative", f: -33.0, output: `-33.0`},
 		{title: "ambiguous e", f: 33.0, opts: WriteOptions{right: operator{name: NewAtom(`e`)}}, output: `33.0 `}, // So that it won't be 33.0e.
+		{title: "following an alphanumeric operator", f: 33.0, opts: WriteOptions{left: operator{name: NewAtom(`is`)}}, output: ` 33.0`},
+		{title: "followed by an alphanumeric operator", f: 33.0, opts: WriteOptions{right: operator{name: NewAtom(`mod`)}}, output: `33.0 `},
+		{title: "followed by a symbolic operator", f: 33.0, opts: WriteOptions{right: operator{name: atomPlus}}, output: `33.0`},
 	}
 
 	var buf bytes.Buffer
@@ -48,6 +52,16 @@ func TestFloat_Compare(t *testing.T) {
 		{title: `1.0 = 1.0`, f: Float(1), t: Float(1), o: 0},
 		{title: `1.0 < 2.0`, f: Float(1), t: Float(2), o: -1},
 		{title: `1.0 < 1`, f: Float(1), t: Integer(1), o: -1},
+		{title: `1.5 > 1`, f: Float(1.5), t: Integer(1), o: 1},
+		{title: `1.5 < 2`, f: Float(1.5), t: Integer(2), o: -1},
+		{title: `-1.5 < -1`, f: Float(-1.5), t: Integer(-1), o: -1},
+		{title: `1.0e30 > max_integer`, f: Float(1e30), t: Integer(math.MaxInt64), o: 1},
+		{title: `-1.0e30 < min_integer`, f: Float(-1e30), t: Integer(math.MinInt64), o: -1},
+		{title: `9007199254740992.0 < 9007199254740993`, f: Float(1 << 53), t: Integer(1<<53 + 1), o: -1},
+		{title: `nan < 0`, f: Float(math.NaN()), t: Integer(0), o: -1},
+		{title: `nan < -1.0e30`, f: Float(math.NaN()), t: Float(-1e30), o: -1},
+		{title: `0.0 > nan`, f: Float(0), t: Float(math.NaN()), o: 1},
+		{title: `nan = nan`, f: Float(math.NaN()), t: Float(math.NaN()), o: 0},
 		{title: `1.0 < a`, f: Float(1), t: NewAtom("a"), o: -1},
 		{title: `1.0 < f(a)`, f: Float(1), t: NewAtom("f").Apply(NewAtom("a")), o: -1},
 	}
@@ -42,8 +42,10 @@ func (i Integer) WriteTerm(w io.Writer, opts *WriteOptions, _ *Env) error {
 // Compare compares the Integer with a Term.
 func (i Integer) Compare(t Term, env *Env) int {
 	switch t := env.Resolve(t).(type) {
-	case Variable, Float:
+	case Variable:
 		return 1
+	case Float:
+		return -t.Compare(i, env)
 	case Integer:
 		switch {
 		case i > t:
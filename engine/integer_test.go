@@ -3,6 +3,7 @@ package engine
 import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"testing"
 )
 
@@ -47,6 +48,11 @@ func TestInteger_Compare(t *testing.T) {
 	}{
 		{title: `1 > X`, i: 1, t: x, o: 1},
 		{title: `1 > 1.0`, i: 1, t: Float(1), o: 1},
+		{title: `1 < 1.5`, i: 1, t: Float(1.5), o: -1},
+		{title: `2 > 1.5`, i: 2, t: Float(1.5), o: 1},
+		{title: `max_integer < 1.0e30`, i: math.MaxInt64, t: Float(1e30), o: -1},
+		{title: `9007199254740993 > 9007199254740992.0`, i: 1<<53 + 1, t: Float(1 << 53), o: 1},
+		{title: `0 > nan`, i: 0, t: Float(math.NaN()), o: 1},
 		{title: `1 > 0`, i: 1, t: Integer(0), o: 1},
 		{title: `1 = 1`, i: 1, t: Integer(1), o: 0},
 		{title: `1 < 2`, i: 1, t: Integer(2), o: -1},
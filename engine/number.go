@@ -3,6 +3,8 @@ package engine
 import (
 	"errors"
 	"math"
+	"math/bits"
+	"math/rand"
 )
 
 var (
@@ -10,8 +12,18 @@ var (
 	minInt = Integer(math.MinInt64)
 )
 
-var constants = map[Atom]Number{
-	atomPi: Float(math.Pi),
+// constants are the builtin evaluable atoms. VM.RegisterConstant adds more to a VM.
+// Every integer is an immediate value, so the tagged integers span the whole range.
+var constants = map[Atom]func() Number{
+	atomPi:               func() Number { return Float(math.Pi) },
+	atomSmallE:           func() Number { return Float(math.E) },
+	atomInf:              func() Number { return Float(math.Inf(1)) },
+	atomInfinite:         func() Number { return Float(math.Inf(1)) },
+	atomNaN:              func() Number { return Float(math.NaN()) },
+	atomEpsilon:          func() Number { return Float(math.Nextafter(1, 2) - 1) },
+	atomMaxTaggedInteger: func() Number { return maxInt },
+	atomMinTaggedInteger: func() Number { return minInt },
+	atomRandom:           func() Number { return Float(rand.Float64()) },
 }
 
 var unaryFunctors = map[Atom]func(Number) (Number, error){
@@ -36,6 +48,9 @@ var unaryFunctors = map[Atom]func(Number) (Number, error){
 	atomAsin:                asin,
 	atomAcos:                acos,
 	atomTan:                 tan,
+	atomMsb:                 msb,
+	atomLsb:                 lsb,
+	atomPopcount:            popcount,
 }
 
 var binaryFunctors = map[Atom]func(Number, Number) (Number, error){
@@ -55,8 +70,21 @@ var binaryFunctors = map[Atom]func(Number, Number) (Number, error){
 	atomMax:               max,
 	atomMin:               min,
 	atomCaret:             integerPower,
+	atomAtan:              atan2,
 	atomAtan2:             atan2,
+	atomCopysign:          copysign,
 	atomXor:               xor,
+	atomGcd:               gcd,
+}
+
+// wrappingFunctors are the integer operations which wrap around instead of raising int_overflow while
+// current_prolog_flag(int_overflow, wrap).
+var wrappingFunctors = map[Atom]func(Integer, Integer) Integer{
+	atomPlus:             func(x, y Integer) Integer { return x + y },
+	atomMinus:            func(x, y Integer) Integer { return x - y },
+	atomAsterisk:         func(x, y Integer) Integer { return x * y },
+	atomBitwiseLeftShift: func(n, s Integer) Integer { return n << s },
+	atomCaret:            wrappingPow,
 }
 
 // Number is a prolog number, either Integer or Float.
@@ -65,7 +93,17 @@ type Number interface {
 	number()
 }
 
-func eval(expression Term, env *Env) (_ Number, err error) {
+func (vm *VM) constant(name Atom) (func() Number, bool) {
+	if vm != nil {
+		if c, ok := vm.constants[name]; ok {
+			return c, true
+		}
+	}
+	c, ok := constants[name]
+	return c, ok
+}
+
+func eval(vm *VM, expression Term, env *Env) (_ Number, err error) {
 	defer func() {
 		var ev exceptionalValue
 		if errors.As(err, &ev) {
@@ -77,11 +115,11 @@ func eval(expression Term, env *Env) (_ Number, err error) {
 	case Variable:
 		return nil, InstantiationError(env)
 	case Atom:
-		c, ok := constants[t]
+		c, ok := vm.constant(t)
 		if !ok {
 			return nil, typeError(validTypeEvaluable, atomSlash.Apply(t, Integer(0)), env)
 		}
-		return c, nil
+		return c(), nil
 	case Number:
 		return t, nil
 	case Compound:
@@ -91,7 +129,7 @@ func eval(expression Term, env *Env) (_ Number, err error) {
 			if !ok {
 				return nil, typeError(validTypeEvaluable, atomSlash.Apply(t.Functor(), Integer(1)), env)
 			}
-			x, err := eval(t.Arg(0), env)
+			x, err := eval(vm, t.Arg(0), env)
 			if err != nil {
 				return nil, err
 			}
@@ -101,15 +139,21 @@ func eval(expression Term, env *Env) (_ Number, err error) {
 			if !ok {
 				return nil, typeError(validTypeEvaluable, atomSlash.Apply(t.Functor(), Integer(2)), env)
 			}
-			x, err := eval(t.Arg(0), env)
+			x, err := eval(vm, t.Arg(0), env)
 			if err != nil {
 				return nil, err
 			}
-			y, err := eval(t.Arg(1), env)
+			y, err := eval(vm, t.Arg(1), env)
 			if err != nil {
 				return nil, err
 			}
-			return f(x, y)
+			r, err := f(x, y)
+			if err == exceptionalValueIntOverflow && vm != nil && vm.wrapIntOverflow {
+				if w, ok := wrappingFunctors[t.Functor()]; ok {
+					return w(x.(Integer), y.(Integer)), nil
+				}
+			}
+			return r, err
 		default:
 			return nil, typeError(validTypeEvaluable, atomSlash.Apply(t.Functor(), Integer(arity)), env)
 		}
@@ -120,7 +164,7 @@ func eval(expression Term, env *Env) (_ Number, err error) {
 
 // Is evaluates expression and unifies the result with result.
 func Is(vm *VM, result, expression Term, k Cont, env *Env) *Promise {
-	v, err := eval(expression, env)
+	v, err := eval(vm, expression, env)
 	if err != nil {
 		return Error(err)
 	}
@@ -128,13 +172,13 @@ func Is(vm *VM, result, expression Term, k Cont, env *Env) *Promise {
 }
 
 // Equal succeeds iff e1 equals to e2.
-func Equal(_ *VM, e1, e2 Term, k Cont, env *Env) *Promise {
-	ev1, err := eval(e1, env)
+func Equal(vm *VM, e1, e2 Term, k Cont, env *Env) *Promise {
+	ev1, err := eval(vm, e1, env)
 	if err != nil {
 		return Error(err)
 	}
 
-	ev2, err := eval(e2, env)
+	ev2, err := eval(vm, e2, env)
 	if err != nil {
 		return Error(err)
 	}
@@ -163,13 +207,13 @@ func Equal(_ *VM, e1, e2 Term, k Cont, env *Env) *Promise {
 }
 
 // NotEqual succeeds iff e1 doesn't equal to e2.
-func NotEqual(_ *VM, e1, e2 Term, k Cont, env *Env) *Promise {
-	ev1, err := eval(e1, env)
+func NotEqual(vm *VM, e1, e2 Term, k Cont, env *Env) *Promise {
+	ev1, err := eval(vm, e1, env)
 	if err != nil {
 		return Error(err)
 	}
 
-	ev2, err := eval(e2, env)
+	ev2, err := eval(vm, e2, env)
 	if err != nil {
 		return Error(err)
 	}
@@ -198,13 +242,13 @@ func NotEqual(_ *VM, e1, e2 Term, k Cont, env *Env) *Promise {
 }
 
 // LessThan succeeds iff e1 is less than e2.
-func LessThan(_ *VM, e1, e2 Term, k Cont, env *Env) *Promise {
-	ev1, err := eval(e1, env)
+func LessThan(vm *VM, e1, e2 Term, k Cont, env *Env) *Promise {
+	ev1, err := eval(vm, e1, env)
 	if err != nil {
 		return Error(err)
 	}
 
-	ev2, err := eval(e2, env)
+	ev2, err := eval(vm, e2, env)
 	if err != nil {
 		return Error(err)
 	}
@@ -233,13 +277,13 @@ func LessThan(_ *VM, e1, e2 Term, k Cont, env *Env) *Promise {
 }
 
 // GreaterThan succeeds iff e1 is greater than e2.
-func GreaterThan(_ *VM, e1, e2 Term, k Cont, env *Env) *Promise {
-	ev1, err := eval(e1, env)
+func GreaterThan(vm *VM, e1, e2 Term, k Cont, env *Env) *Promise {
+	ev1, err := eval(vm, e1, env)
 	if err != nil {
 		return Error(err)
 	}
 
-	ev2, err := eval(e2, env)
+	ev2, err := eval(vm, e2, env)
 	if err != nil {
 		return Error(err)
 	}
@@ -268,13 +312,13 @@ func GreaterThan(_ *VM, e1, e2 Term, k Cont, env *Env) *Promise {
 }
 
 // LessThanOrEqual succeeds iff e1 is less than or equal to e2.
-func LessThanOrEqual(_ *VM, e1, e2 Term, k Cont, env *Env) *Promise {
-	ev1, err := eval(e1, env)
+func LessThanOrEqual(vm *VM, e1, e2 Term, k Cont, env *Env) *Promise {
+	ev1, err := eval(vm, e1, env)
 	if err != nil {
 		return Error(err)
 	}
 
-	ev2, err := eval(e2, env)
+	ev2, err := eval(vm, e2, env)
 	if err != nil {
 		return Error(err)
 	}
@@ -303,13 +347,13 @@ func LessThanOrEqual(_ *VM, e1, e2 Term, k Cont, env *Env) *Promise {
 }
 
 // GreaterThanOrEqual succeeds iff e1 is greater than or equal to e2.
-func GreaterThanOrEqual(_ *VM, e1, e2 Term, k Cont, env *Env) *Promise {
-	ev1, err := eval(e1, env)
+func GreaterThanOrEqual(vm *VM, e1, e2 Term, k Cont, env *Env) *Promise {
+	ev1, err := eval(vm, e1, env)
 	if err != nil {
 		return Error(err)
 	}
 
-	ev2, err := eval(e2, env)
+	ev2, err := eval(vm, e2, env)
 	if err != nil {
 		return Error(err)
 	}
@@ -740,7 +784,7 @@ func bitwiseLeftShift(n, s Number) (Number, error) {
 	case Integer:
 		switch s := s.(type) {
 		case Integer:
-			return Integer(n << s), nil
+			return shiftLeftI(n, s)
 		default:
 			return nil, typeError(validTypeInteger, s, nil)
 		}
@@ -789,6 +833,42 @@ func bitwiseComplement(b1 Number) (Number, error) {
 	}
 }
 
+// msb returns the position of the most significant set bit of positive integer x.
+func msb(x Number) (Number, error) {
+	n, ok := x.(Integer)
+	if !ok {
+		return nil, typeError(validTypeInteger, x, nil)
+	}
+	if n <= 0 {
+		return nil, exceptionalValueUndefined
+	}
+	return Integer(bits.Len64(uint64(n)) - 1), nil
+}
+
+// lsb returns the position of the least significant set bit of positive integer x.
+func lsb(x Number) (Number, error) {
+	n, ok := x.(Integer)
+	if !ok {
+		return nil, typeError(validTypeInteger, x, nil)
+	}
+	if n <= 0 {
+		return nil, exceptionalValueUndefined
+	}
+	return Integer(bits.TrailingZeros64(uint64(n))), nil
+}
+
+// popcount returns the number of set bits of non-negative integer x.
+func popcount(x Number) (Number, error) {
+	n, ok := x.(Integer)
+	if !ok {
+		return nil, typeError(validTypeInteger, x, nil)
+	}
+	if n < 0 {
+		return nil, exceptionalValueUndefined
+	}
+	return Integer(bits.OnesCount64(uint64(n))), nil
+}
+
 // pos returns x as is.
 func pos(x Number) (Number, error) {
 	switch x := x.(type) {
@@ -923,6 +1003,18 @@ func integerPower(x, y Number) (Number, error) {
 	return intPow(vx, vy)
 }
 
+// wrappingPow returns a raised to the power of b modulo 2^64.
+func wrappingPow(a, b Integer) Integer {
+	r := Integer(1)
+	for ; b > 0; b >>= 1 {
+		if b&1 != 0 {
+			r *= a
+		}
+		a *= a
+	}
+	return r
+}
+
 // Loosely based on https://www.programminglogic.com/fast-exponentiation-algorithms/
 func intPow(a, b Integer) (Integer, error) {
 	var (
@@ -1017,6 +1109,31 @@ func atan2(y, x Number) (Number, error) {
 	return Float(math.Atan2(vy, vx)), nil
 }
 
+// copysign returns a float with the magnitude of x and the sign of y.
+func copysign(x, y Number) (Number, error) {
+	var vx float64
+	switch x := x.(type) {
+	case Integer:
+		vx = float64(x)
+	case Float:
+		vx = float64(x)
+	default:
+		return nil, exceptionalValueUndefined
+	}
+
+	var vy float64
+	switch y := y.(type) {
+	case Integer:
+		vy = float64(y)
+	case Float:
+		vy = float64(y)
+	default:
+		return nil, exceptionalValueUndefined
+	}
+
+	return Float(math.Copysign(vx, vy)), nil
+}
+
 // tan returns the tangent of x.
 func tan(x Number) (Number, error) {
 	var vx float64
@@ -1047,8 +1164,65 @@ func xor(x, y Number) (Number, error) {
 	return vx ^ vy, nil
 }
 
+// gcd returns the greatest common divisor of x and y.
+func gcd(x, y Number) (Number, error) {
+	vx, ok := x.(Integer)
+	if !ok {
+		return nil, typeError(validTypeInteger, x, nil)
+	}
+
+	vy, ok := y.(Integer)
+	if !ok {
+		return nil, typeError(validTypeInteger, y, nil)
+	}
+
+	for vy != 0 {
+		vx, vy = vy, vx%vy
+	}
+	if vx < 0 {
+		if vx == minInt {
+			return nil, exceptionalValueIntOverflow
+		}
+		vx = -vx
+	}
+	return vx, nil
+}
+
 // Comparison
 
+// cmpFI compares x and n by their exact values. Converting n to Float instead would lose precision for integers
+// beyond 2^53. If x is NaN, they're unordered and it returns false.
+func cmpFI(x Float, n Integer) (int, bool) {
+	switch {
+	case math.IsNaN(float64(x)):
+		return 0, false
+	case x >= -math.MinInt64:
+		return 1, true
+	case x < math.MinInt64:
+		return -1, true
+	}
+
+	// x is in the range of Integer so it truncates to an exact integer and a fraction.
+	i := Integer(x)
+	switch {
+	case i < n:
+		return -1, true
+	case i > n:
+		return 1, true
+	}
+
+	switch f := x - Float(i); {
+	case f < 0:
+		return -1, true
+	case f > 0:
+		return 1, true
+	default:
+		return 0, true
+	}
+}
+
+// As IEEE 754 specifies, a comparison with NaN is false except for neqF/neqFI/neqIF since NaN is unordered.
+
 func eqF(x, y Float) bool {
 	return x == y
 }
@@ -1058,8 +1232,8 @@ func eqI(m, n Integer) bool {
 }
 
 func eqFI(x Float, n Integer) bool {
-	y := floatItoF(n)
-	return eqF(x, y)
+	c, ok := cmpFI(x, n)
+	return ok && c == 0
 }
 
 func eqIF(n Integer, y Float) bool {
@@ -1075,8 +1249,8 @@ func neqI(m, n Integer) bool {
 }
 
 func neqFI(x Float, n Integer) bool {
-	y := floatItoF(n)
-	return neqF(x, y)
+	c, ok := cmpFI(x, n)
+	return !ok || c != 0
 }
 
 func neqIF(n Integer, y Float) bool {
@@ -1092,8 +1266,8 @@ func lssI(m, n Integer) bool {
 }
 
 func lssFI(x Float, n Integer) bool {
-	y := floatItoF(n)
-	return lssF(x, y)
+	c, ok := cmpFI(x, n)
+	return ok && c < 0
 }
 
 func lssIF(n Integer, y Float) bool {
@@ -1109,8 +1283,8 @@ func leqI(m, n Integer) bool {
 }
 
 func leqFI(x Float, n Integer) bool {
-	y := floatItoF(n)
-	return leqF(x, y)
+	c, ok := cmpFI(x, n)
+	return ok && c <= 0
 }
 
 func leqIF(n Integer, y Float) bool {
@@ -1126,8 +1300,8 @@ func gtrI(m, n Integer) bool {
 }
 
 func gtrFI(x Float, n Integer) bool {
-	y := floatItoF(n)
-	return gtrF(x, y)
+	c, ok := cmpFI(x, n)
+	return ok && c > 0
 }
 
 func gtrIF(n Integer, y Float) bool {
@@ -1143,8 +1317,8 @@ func geqI(m, n Integer) bool {
 }
 
 func geqFI(x Float, n Integer) bool {
-	y := floatItoF(n)
-	return geqF(x, y)
+	c, ok := cmpFI(x, n)
+	return ok && c >= 0
 }
 
 func geqIF(n Integer, y Float) bool {
@@ -1234,6 +1408,25 @@ func mulI(x, y Integer) (Integer, error) {
 	}
 }
 
+func shiftLeftI(n, s Integer) (Integer, error) {
+	switch {
+	case s < 0:
+		if s < -63 {
+			s = -63
+		}
+		return n >> -s, nil
+	case n == 0:
+		return 0, nil
+	case s > 63:
+		return 0, exceptionalValueIntOverflow
+	}
+	r := n << s
+	if r>>s != n {
+		return 0, exceptionalValueIntOverflow
+	}
+	return r, nil
+}
+
 func intDivI(x, y Integer) (Integer, error) {
 	switch {
 	case y == 0:
@@ -1257,7 +1450,12 @@ func modI(x, y Integer) (Integer, error) {
 	if y == 0 {
 		return 0, exceptionalValueZeroDivisor
 	}
-	return x - (Integer(math.Floor(float64(x)/float64(y))) * y), nil
+	// The remainder takes the sign of the divisor.
+	m := x % y
+	if m != 0 && (m < 0) != (y < 0) {
+		m += y
+	}
+	return m, nil
 }
 
 func negI(x Integer) (Integer, error) {
@@ -1301,7 +1499,12 @@ func intFloorDivI(x, y Integer) (Integer, error) {
 	case y == 0:
 		return 0, exceptionalValueZeroDivisor
 	default:
-		return Integer(math.Floor(float64(x) / float64(y))), nil
+		// The quotient is rounded toward negative infinity.
+		q := x / y
+		if x%y != 0 && (x < 0) != (y < 0) {
+			q--
+		}
+		return q, nil
 	}
 }
 
@@ -23,6 +23,12 @@ func TestIs(t *testing.T) {
 		{title: "float", result: Float(1), expression: Float(1), ok: true},
 
 		{title: "pi", result: Float(math.Pi), expression: atomPi, ok: true},
+		{title: "e", result: Float(math.E), expression: atomSmallE, ok: true},
+		{title: "inf", result: Float(math.Inf(1)), expression: atomInf, ok: true},
+		{title: "infinite", result: Float(math.Inf(1)), expression: atomInfinite, ok: true},
+		{title: "epsilon", result: Float(2.220446049250313e-16), expression: atomEpsilon, ok: true},
+		{title: "max_tagged_integer", result: Integer(math.MaxInt64), expression: atomMaxTaggedInteger, ok: true},
+		{title: "min_tagged_integer", result: Integer(math.MinInt64), expression: atomMinTaggedInteger, ok: true},
 
 		{title: "1 + 1", result: Integer(2), expression: atomPlus.Apply(Integer(1), Integer(1)), ok: true},
 		{title: "maxInt + 1", expression: atomPlus.Apply(Integer(math.MaxInt64), Integer(1)), err: evaluationError(exceptionalValueIntOverflow, nil)},
@@ -76,6 +82,11 @@ func TestIs(t *testing.T) {
 
 		{title: "1 mod 1", result: Integer(0), expression: atomMod.Apply(Integer(1), Integer(1)), ok: true},
 		{title: "1 mod 0", expression: atomMod.Apply(Integer(1), Integer(0)), err: evaluationError(exceptionalValueZeroDivisor, nil)},
+		{title: "7 mod -2", result: Integer(-1), expression: atomMod.Apply(Integer(7), Integer(-2)), ok: true},
+		{title: "-7 mod 2", result: Integer(1), expression: atomMod.Apply(Integer(-7), Integer(2)), ok: true},
+		{title: "-7 mod -2", result: Integer(-1), expression: atomMod.Apply(Integer(-7), Integer(-2)), ok: true},
+		{title: "maxInt mod 2", result: Integer(1), expression: atomMod.Apply(Integer(math.MaxInt64), Integer(2)), ok: true},
+		{title: "minInt mod -1", result: Integer(0), expression: atomMod.Apply(Integer(math.MinInt64), Integer(-1)), ok: true},
 		{title: "1.0 mod 1", expression: atomMod.Apply(Float(1), Integer(1)), err: typeError(validTypeInteger, Float(1), nil)},
 		{title: "1 mod 1.0", expression: atomMod.Apply(Integer(1), Float(1)), err: typeError(validTypeInteger, Float(1), nil)},
 
@@ -131,6 +142,12 @@ func TestIs(t *testing.T) {
 		{title: "1 div 1", result: Integer(1), expression: atomDiv.Apply(Integer(1), Integer(1)), ok: true},
 		{title: "1 div 0", expression: atomDiv.Apply(Integer(1), Integer(0)), err: evaluationError(exceptionalValueZeroDivisor, nil)},
 		{title: "minInt div -1", expression: atomDiv.Apply(Integer(math.MinInt64), Integer(-1)), err: evaluationError(exceptionalValueIntOverflow, nil)},
+		{title: "7 div -2", result: Integer(-4), expression: atomDiv.Apply(Integer(7), Integer(-2)), ok: true},
+		{title: "-7 div 2", result: Integer(-4), expression: atomDiv.Apply(Integer(-7), Integer(2)), ok: true},
+		{title: "-7 div -2", result: Integer(3), expression: atomDiv.Apply(Integer(-7), Integer(-2)), ok: true},
+		{title: "-7 // 2", result: Integer(-3), expression: atomSlashSlash.Apply(Integer(-7), Integer(2)), ok: true},
+		{title: "maxInt div 1", result: Integer(math.MaxInt64), expression: atomDiv.Apply(Integer(math.MaxInt64), Integer(1)), ok: true},
+		{title: "9007199254740993 div 2", result: Integer(4503599627370496), expression: atomDiv.Apply(Integer(9007199254740993), Integer(2)), ok: true},
 		{title: "1.0 div 1", expression: atomDiv.Apply(Float(1), Integer(1)), err: typeError(validTypeInteger, Float(1), nil)},
 		{title: "1 div 1.0", expression: atomDiv.Apply(Integer(1), Float(1)), err: typeError(validTypeInteger, Float(1), nil)},
 
@@ -145,6 +162,8 @@ func TestIs(t *testing.T) {
 		{title: "unknown unary", expression: foo.Apply(Integer(1)), err: typeError(validTypeEvaluable, atomSlash.Apply(foo, Integer(1)), nil)},
 		{title: "unknown binary", expression: foo.Apply(Integer(1), Integer(2)), err: typeError(validTypeEvaluable, atomSlash.Apply(foo, Integer(2)), nil)},
 		{title: "arity is more than 2", expression: foo.Apply(Integer(1), Integer(2), Integer(3)), err: typeError(validTypeEvaluable, atomSlash.Apply(foo, Integer(3)), nil)},
+		{title: "abs(foo)", expression: atomAbs.Apply(foo), err: typeError(validTypeEvaluable, atomSlash.Apply(foo, Integer(0)), nil)},
+		{title: "sign(foo(1))", expression: atomSign.Apply(foo.Apply(Integer(1))), err: typeError(validTypeEvaluable, atomSlash.Apply(foo, Integer(1)), nil)},
 
 		// 8.6.1.3 Errors
 		{title: "a", result: NewVariable(), expression: NewVariable(), err: InstantiationError(nil)},
@@ -250,6 +269,18 @@ func TestIs(t *testing.T) {
 		{title: "atan2(0.0, mock)", expression: atomAtan2.Apply(Float(0), &mockNumber{}), err: evaluationError(exceptionalValueUndefined, nil)},
 		{title: "atan2(mock, 1)", expression: atomAtan2.Apply(&mockNumber{}, Integer(1)), err: evaluationError(exceptionalValueUndefined, nil)},
 		{title: "atan2(0, 0)", expression: atomAtan2.Apply(Integer(0), Integer(0)), err: evaluationError(exceptionalValueUndefined, nil)},
+		{title: "atan2(1, 1)", result: Float(math.Pi / 4), expression: atomAtan2.Apply(Integer(1), Integer(1)), ok: true},
+		{title: "atan2(1, -1)", result: Float(3 * math.Pi / 4), expression: atomAtan2.Apply(Integer(1), Integer(-1)), ok: true},
+		{title: "atan2(-1, -1)", result: Float(-3 * math.Pi / 4), expression: atomAtan2.Apply(Integer(-1), Integer(-1)), ok: true},
+		{title: "atan2(-1, 1)", result: Float(-math.Pi / 4), expression: atomAtan2.Apply(Integer(-1), Integer(1)), ok: true},
+		{title: "atan2(0.0, -1)", result: Float(math.Pi), expression: atomAtan2.Apply(Float(0), Integer(-1)), ok: true},
+		{title: "atan(1, -1)", result: Float(3 * math.Pi / 4), expression: atomAtan.Apply(Integer(1), Integer(-1)), ok: true},
+		{title: "atan(0, 0)", expression: atomAtan.Apply(Integer(0), Integer(0)), err: evaluationError(exceptionalValueUndefined, nil)},
+		{title: "copysign(2, -1)", result: Float(-2), expression: atomCopysign.Apply(Integer(2), Integer(-1)), ok: true},
+		{title: "copysign(-2.5, 1)", result: Float(2.5), expression: atomCopysign.Apply(Float(-2.5), Integer(1)), ok: true},
+		{title: "copysign(1, -0.0)", result: Float(-1), expression: atomCopysign.Apply(Integer(1), Float(math.Copysign(0, -1))), ok: true},
+		{title: "copysign(mock, 1)", expression: atomCopysign.Apply(&mockNumber{}, Integer(1)), err: evaluationError(exceptionalValueUndefined, nil)},
+		{title: "copysign(1, mock)", expression: atomCopysign.Apply(Integer(1), &mockNumber{}), err: evaluationError(exceptionalValueUndefined, nil)},
 
 		{title: "tan(0)", result: Float(0), expression: atomTan.Apply(Integer(0)), ok: true},
 		{title: "tan(0.0)", result: Float(0), expression: atomTan.Apply(Float(0)), ok: true},
@@ -262,6 +293,12 @@ func TestIs(t *testing.T) {
 		{title: "16 << 2", result: Integer(64), expression: atomBitwiseLeftShift.Apply(Integer(16), Integer(2)), ok: true},
 		{title: "16 << 2.0", expression: atomBitwiseLeftShift.Apply(Integer(16), Float(2)), err: typeError(validTypeInteger, Float(2), nil)},
 		{title: "16.0 << 2", expression: atomBitwiseLeftShift.Apply(Float(16), Integer(2)), err: typeError(validTypeInteger, Float(16), nil)},
+		{title: "16 << -2", result: Integer(4), expression: atomBitwiseLeftShift.Apply(Integer(16), Integer(-2)), ok: true},
+		{title: "-1 << 63", result: Integer(math.MinInt64), expression: atomBitwiseLeftShift.Apply(Integer(-1), Integer(63)), ok: true},
+		{title: "0 << 100", result: Integer(0), expression: atomBitwiseLeftShift.Apply(Integer(0), Integer(100)), ok: true},
+		{title: "1 << 63", expression: atomBitwiseLeftShift.Apply(Integer(1), Integer(63)), err: evaluationError(exceptionalValueIntOverflow, nil)},
+		{title: "1 << 64", expression: atomBitwiseLeftShift.Apply(Integer(1), Integer(64)), err: evaluationError(exceptionalValueIntOverflow, nil)},
+		{title: "maxInt << 1", expression: atomBitwiseLeftShift.Apply(Integer(math.MaxInt64), Integer(1)), err: evaluationError(exceptionalValueIntOverflow, nil)},
 
 		{title: `10 /\ 12`, result: Integer(8), expression: atomBitwiseAnd.Apply(Integer(10), Integer(12)), ok: true},
 		{title: `10 /\ 12.0`, expression: atomBitwiseAnd.Apply(Integer(10), Float(12)), err: typeError(validTypeInteger, Float(12), nil)},
@@ -277,6 +314,30 @@ func TestIs(t *testing.T) {
 		{title: "xor(10, 12)", result: Integer(6), expression: atomXor.Apply(Integer(10), Integer(12)), ok: true},
 		{title: "xor(10, 12.0)", expression: atomXor.Apply(Integer(10), Float(12)), err: typeError(validTypeInteger, Float(12), nil)},
 		{title: "xor(10.0, 12)", expression: atomXor.Apply(Float(10), Integer(12)), err: typeError(validTypeInteger, Float(10), nil)},
+		{title: "msb(1)", result: Integer(0), expression: atomMsb.Apply(Integer(1)), ok: true},
+		{title: "msb(1000)", result: Integer(9), expression: atomMsb.Apply(Integer(1000)), ok: true},
+		{title: "msb(maxInt)", result: Integer(62), expression: atomMsb.Apply(Integer(math.MaxInt64)), ok: true},
+		{title: "msb(0)", expression: atomMsb.Apply(Integer(0)), err: evaluationError(exceptionalValueUndefined, nil)},
+		{title: "msb(-1)", expression: atomMsb.Apply(Integer(-1)), err: evaluationError(exceptionalValueUndefined, nil)},
+		{title: "msb(1.0)", expression: atomMsb.Apply(Float(1)), err: typeError(validTypeInteger, Float(1), nil)},
+		{title: "lsb(1)", result: Integer(0), expression: atomLsb.Apply(Integer(1)), ok: true},
+		{title: "lsb(1000)", result: Integer(3), expression: atomLsb.Apply(Integer(1000)), ok: true},
+		{title: "lsb(0)", expression: atomLsb.Apply(Integer(0)), err: evaluationError(exceptionalValueUndefined, nil)},
+		{title: "lsb(-1)", expression: atomLsb.Apply(Integer(-1)), err: evaluationError(exceptionalValueUndefined, nil)},
+		{title: "lsb(1.0)", expression: atomLsb.Apply(Float(1)), err: typeError(validTypeInteger, Float(1), nil)},
+		{title: "popcount(0)", result: Integer(0), expression: atomPopcount.Apply(Integer(0)), ok: true},
+		{title: "popcount(1000)", result: Integer(6), expression: atomPopcount.Apply(Integer(1000)), ok: true},
+		{title: "popcount(maxInt)", result: Integer(63), expression: atomPopcount.Apply(Integer(math.MaxInt64)), ok: true},
+		{title: "popcount(-1)", expression: atomPopcount.Apply(Integer(-1)), err: evaluationError(exceptionalValueUndefined, nil)},
+		{title: "popcount(1.0)", expression: atomPopcount.Apply(Float(1)), err: typeError(validTypeInteger, Float(1), nil)},
+		{title: "gcd(12, 18)", result: Integer(6), expression: atomGcd.Apply(Integer(12), Integer(18)), ok: true},
+		{title: "gcd(-12, 18)", result: Integer(6), expression: atomGcd.Apply(Integer(-12), Integer(18)), ok: true},
+		{title: "gcd(12, -18)", result: Integer(6), expression: atomGcd.Apply(Integer(12), Integer(-18)), ok: true},
+		{title: "gcd(0, 5)", result: Integer(5), expression: atomGcd.Apply(Integer(0), Integer(5)), ok: true},
+		{title: "gcd(0, 0)", result: Integer(0), expression: atomGcd.Apply(Integer(0), Integer(0)), ok: true},
+		{title: "gcd(min_int, 0)", expression: atomGcd.Apply(Integer(math.MinInt64), Integer(0)), err: evaluationError(exceptionalValueIntOverflow, nil)},
+		{title: "gcd(12, 18.0)", expression: atomGcd.Apply(Integer(12), Float(18)), err: typeError(validTypeInteger, Float(18), nil)},
+		{title: "gcd(12.0, 18)", expression: atomGcd.Apply(Float(12), Integer(18)), err: typeError(validTypeInteger, Float(12), nil)},
 	}
 
 	for _, tt := range tests {
@@ -287,6 +348,82 @@ func TestIs(t *testing.T) {
 			assert.Equal(t, tt.err, err)
 		})
 	}
+
+	t.Run("nan", func(t *testing.T) {
+		v := NewVariable()
+		ok, err := Is(nil, v, atomNaN, func(env *Env) *Promise {
+			f, ok := env.Resolve(v).(Float)
+			assert.True(t, ok)
+			assert.True(t, math.IsNaN(float64(f)))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("random", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			v := NewVariable()
+			ok, err := Is(nil, v, atomRandom, func(env *Env) *Promise {
+				f, ok := env.Resolve(v).(Float)
+				assert.True(t, ok)
+				assert.True(t, 0 <= f && f < 1)
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}
+	})
+
+	t.Run("caught by catch/3", func(t *testing.T) {
+		is := NewAtom("is")
+		var vm VM
+		vm.Register2(is, Is)
+		vm.Register0(atomTrue, func(_ *VM, k Cont, env *Env) *Promise { return k(env) })
+		for _, tt := range []struct {
+			expression, catcher Term
+		}{
+			{expression: atomSlash.Apply(Integer(1), Integer(0)), catcher: atomEvaluationError.Apply(atomZeroDivisor)},
+			{expression: atomPlus.Apply(Integer(math.MaxInt64), Integer(1)), catcher: atomEvaluationError.Apply(atomIntOverflow)},
+			{expression: atomPlus.Apply(foo, Integer(1)), catcher: atomTypeError.Apply(atomEvaluable, atomSlash.Apply(foo, Integer(0)))},
+			{expression: atomPlus.Apply(NewVariable(), Integer(1)), catcher: atomInstantiationError},
+		} {
+			ok, err := Catch(&vm, is.Apply(NewVariable(), tt.expression), atomError.Apply(tt.catcher, NewVariable()), atomTrue, Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}
+	})
+}
+
+func TestIs_intOverflowWrap(t *testing.T) {
+	tests := []struct {
+		title      string
+		result     Integer
+		expression Term
+	}{
+		{title: "maxInt + 1", result: math.MinInt64, expression: atomPlus.Apply(Integer(math.MaxInt64), Integer(1))},
+		{title: "minInt - 1", result: math.MaxInt64, expression: atomMinus.Apply(Integer(math.MinInt64), Integer(1))},
+		{title: "maxInt * 2", result: -2, expression: atomAsterisk.Apply(Integer(math.MaxInt64), Integer(2))},
+		{title: "1 << 63", result: math.MinInt64, expression: atomBitwiseLeftShift.Apply(Integer(1), Integer(63))},
+		{title: "1 << 64", result: 0, expression: atomBitwiseLeftShift.Apply(Integer(1), Integer(64))},
+		{title: "2 ^ 64", result: 0, expression: atomCaret.Apply(Integer(2), Integer(64))},
+		{title: "3 ^ 41", result: -420491770248316829, expression: atomCaret.Apply(Integer(3), Integer(41))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			vm := VM{wrapIntOverflow: true}
+			ok, err := Is(&vm, tt.result, tt.expression, Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+
+	t.Run("not an integer operation", func(t *testing.T) {
+		vm := VM{wrapIntOverflow: true}
+		_, err := Is(&vm, NewVariable(), atomAbs.Apply(Integer(math.MinInt64)), Success, nil).Force(context.Background())
+		assert.Equal(t, evaluationError(exceptionalValueIntOverflow, nil), err)
+	})
 }
 
 func TestEqual(t *testing.T) {
@@ -319,6 +456,28 @@ func TestEqual(t *testing.T) {
 		})
 	})
 
+	t.Run("beyond float precision", func(t *testing.T) {
+		ok, err := Equal(&vm, Integer(1<<53+1), Float(1<<53), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+
+		ok, err = Equal(&vm, Float(1<<60), Integer(1<<60), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("nan", func(t *testing.T) {
+		for _, e := range []Term{Integer(math.MinInt64), Integer(0), Float(math.NaN())} {
+			ok, err := Equal(&vm, Float(math.NaN()), e, Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.False(t, ok)
+
+			ok, err = Equal(&vm, e, Float(math.NaN()), Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.False(t, ok)
+		}
+	})
+
 	t.Run("e1 is a variable", func(t *testing.T) {
 		_, err := Equal(&vm, Integer(1), NewVariable(), Success, nil).Force(context.Background())
 		assert.Error(t, err)
@@ -352,6 +511,11 @@ func TestNotEqual(t *testing.T) {
 		{title: `X =\= 1`, e1: x, e2: Integer(1), err: InstantiationError(nil)},
 		{title: `1 =\= X`, e1: Integer(1), e2: x, err: InstantiationError(nil)},
 		{title: `1 =\= 1`, e1: Integer(1), e2: Integer(1), ok: false},
+		{title: `9007199254740993 =\= 9007199254740992.0`, e1: Integer(1<<53 + 1), e2: Float(1 << 53), ok: true},
+		{title: `1152921504606846976 =\= 1152921504606846976.0`, e1: Integer(1 << 60), e2: Float(1 << 60), ok: false},
+		{title: `nan =\= -9223372036854775808`, e1: Float(math.NaN()), e2: Integer(math.MinInt64), ok: true},
+		{title: `1 =\= nan`, e1: Integer(1), e2: Float(math.NaN()), ok: true},
+		{title: `nan =\= nan`, e1: Float(math.NaN()), e2: Float(math.NaN()), ok: true},
 	}
 
 	for _, tt := range tests {
@@ -379,6 +543,15 @@ func TestLessThan(t *testing.T) {
 		{title: `X < 1`, e1: x, e2: Integer(1), err: InstantiationError(nil)},
 		{title: `1 < X`, e1: Integer(1), e2: x, err: InstantiationError(nil)},
 		{title: `1 < 1`, e1: Integer(1), e2: Integer(1), ok: false},
+		{title: `9007199254740992.0 < 9007199254740993`, e1: Float(1 << 53), e2: Integer(1<<53 + 1), ok: true},
+		{title: `-9007199254740993 < -9007199254740992.0`, e1: Integer(-1<<53 - 1), e2: Float(-1 << 53), ok: true},
+		{title: `9223372036854775807 < 9223372036854775808.0`, e1: Integer(math.MaxInt64), e2: Float(1 << 63), ok: true},
+		{title: `-9223372036854775808 < -9223372036854775808.0`, e1: Integer(math.MinInt64), e2: Float(math.MinInt64), ok: false},
+		{title: `-1.5 < -1`, e1: Float(-1.5), e2: Integer(-1), ok: true},
+		{title: `nan < 1`, e1: Float(math.NaN()), e2: Integer(1), ok: false},
+		{title: `1 < nan`, e1: Integer(1), e2: Float(math.NaN()), ok: false},
+		{title: `nan < -9223372036854775807`, e1: Float(math.NaN()), e2: Integer(-math.MaxInt64), ok: false},
+		{title: `nan < 1.0`, e1: Float(math.NaN()), e2: Float(1), ok: false},
 	}
 
 	for _, tt := range tests {
@@ -406,6 +579,9 @@ func TestGreaterThan(t *testing.T) {
 		{title: `X > 1`, e1: x, e2: Integer(1), err: InstantiationError(nil)},
 		{title: `1 > X`, e1: Integer(1), e2: x, err: InstantiationError(nil)},
 		{title: `1 > 1`, e1: Integer(1), e2: Integer(1), ok: false},
+		{title: `nan > 1`, e1: Float(math.NaN()), e2: Integer(1), ok: false},
+		{title: `1 > nan`, e1: Integer(1), e2: Float(math.NaN()), ok: false},
+		{title: `nan > 1.0`, e1: Float(math.NaN()), e2: Float(1), ok: false},
 	}
 
 	for _, tt := range tests {
@@ -433,6 +609,9 @@ func TestLessThanOrEqual(t *testing.T) {
 		{title: `X =< 1`, e1: x, e2: Integer(1), err: InstantiationError(nil)},
 		{title: `1 =< X`, e1: Integer(1), e2: x, err: InstantiationError(nil)},
 		{title: `2 =< 1`, e1: Integer(2), e2: Integer(1), ok: false},
+		{title: `nan =< -9223372036854775808`, e1: Float(math.NaN()), e2: Integer(math.MinInt64), ok: false},
+		{title: `1 =< nan`, e1: Integer(1), e2: Float(math.NaN()), ok: false},
+		{title: `nan =< nan`, e1: Float(math.NaN()), e2: Float(math.NaN()), ok: false},
 	}
 
 	for _, tt := range tests {
@@ -460,6 +639,9 @@ func TestGreaterThanOrEqual(t *testing.T) {
 		{title: `X >= 1`, e1: x, e2: Integer(1), err: InstantiationError(nil)},
 		{title: `1 >= X`, e1: Integer(1), e2: x, err: InstantiationError(nil)},
 		{title: `1 >= 2`, e1: Integer(1), e2: Integer(2), ok: false},
+		{title: `nan >= -9223372036854775808`, e1: Float(math.NaN()), e2: Integer(math.MinInt64), ok: false},
+		{title: `1 >= nan`, e1: Integer(1), e2: Float(math.NaN()), ok: false},
+		{title: `nan >= nan`, e1: Float(math.NaN()), e2: Float(math.NaN()), ok: false},
 	}
 
 	for _, tt := range tests {
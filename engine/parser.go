@@ -24,6 +24,7 @@ type Parser struct {
 	operators    operators
 	doubleQuotes doubleQuotes
 
+	// Vars are the variables that appeared in the term last parsed by Term.
 	Vars []ParsedVariable
 
 	placeholder Atom
@@ -75,14 +76,7 @@ func (p *Parser) termOf(o reflect.Value) (Term, error) {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return Integer(o.Int()), nil
 	case reflect.String:
-		switch p.doubleQuotes {
-		case doubleQuotesCodes:
-			return CodeList(o.String()), nil
-		case doubleQuotesAtom:
-			return NewAtom(o.String()), nil
-		default:
-			return CharList(o.String()), nil
-		}
+		return p.doubleQuotes.term(o.String()), nil
 	case reflect.Array, reflect.Slice:
 		l := o.Len()
 		es := make([]Term, l)
@@ -120,6 +114,8 @@ func (p *Parser) current() Token {
 
 // Term parses a term followed by a full stop.
 func (p *Parser) Term() (Term, error) {
+	p.Vars = nil
+
 	t, err := p.term(1201)
 	switch err {
 	case nil:
@@ -145,6 +141,29 @@ func (p *Parser) Term() (Term, error) {
 	return t, nil
 }
 
+// skip discards tokens up to and including the next full stop.
+func (p *Parser) skip() error {
+	for {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		if t.kind == tokenEnd {
+			return nil
+		}
+	}
+}
+
+// resync discards tokens up to and including the next full stop after the syntax error err.
+// If err was caused by a full stop, it's already synchronized.
+func (p *Parser) resync(err error) error {
+	var e unexpectedTokenError
+	if errors.As(err, &e) && e.actual.kind == tokenEnd && p.buf.empty() {
+		return nil
+	}
+	return p.skip()
+}
+
 // Number parses a number term.
 func (p *Parser) number() (Number, error) {
 	var (
@@ -344,6 +363,18 @@ func (d doubleQuotes) String() string {
 	}[d]
 }
 
+// term returns the text s in the same representation as a double-quoted list read under d.
+func (d doubleQuotes) term(s string) Term {
+	switch d {
+	case doubleQuotesCodes:
+		return CodeList(s)
+	case doubleQuotesAtom:
+		return NewAtom(s)
+	default:
+		return CharList(s)
+	}
+}
+
 // Loosely based on Pratt parser explained in this article: https://matklad.github.io/2020/04/13/simple-but-powerful-pratt-parsing.html
 func (p *Parser) term(maxPriority Integer) (Term, error) {
 	var lhs Term
@@ -322,3 +322,19 @@ func TestParser_More(t *testing.T) {
 	assert.Equal(t, NewAtom("bar"), term)
 	assert.False(t, p.More())
 }
+
+func TestParser_Vars(t *testing.T) {
+	var vm VM
+	p := NewParser(&vm, strings.NewReader(`f(X, Y). g(Z, Z).`))
+	_, err := p.Term()
+	assert.NoError(t, err)
+	assert.Len(t, p.Vars, 2)
+	assert.Equal(t, NewAtom("X"), p.Vars[0].Name)
+	assert.Equal(t, NewAtom("Y"), p.Vars[1].Name)
+
+	_, err = p.Term()
+	assert.NoError(t, err)
+	assert.Len(t, p.Vars, 1)
+	assert.Equal(t, NewAtom("Z"), p.Vars[0].Name)
+	assert.Equal(t, 2, p.Vars[0].Count)
+}
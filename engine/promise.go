@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 )
 
 var (
@@ -25,7 +26,8 @@ type Promise struct {
 	recover   func(error) *Promise
 }
 
-// Delay delays an execution of k.
+// Delay delays an execution of k. If more than one k is given, they're tried from left to right on backtracking as
+// the alternatives of a nondeterministic predicate.
 func Delay(k ...func(context.Context) *Promise) *Promise {
 	return &Promise{delayed: k}
 }
@@ -45,8 +47,10 @@ func Error(err error) *Promise {
 
 var dummyCutParent Promise
 
-// cut returns a promise that once the execution reaches it, it eliminates other possible choices.
-func cut(parent *Promise, k func(context.Context) *Promise) *Promise {
+// Cut returns a promise that once the execution reaches it, it eliminates other possible choices made since parent,
+// including the ones of parent itself, and continues with k. A predicate which commits to the first solution of its
+// goal passes the promise of the goal as parent. If parent is nil, it eliminates all the other choices of the query.
+func Cut(parent *Promise, k func(context.Context) *Promise) *Promise {
 	if parent == nil {
 		parent = &dummyCutParent
 	}
@@ -75,7 +79,9 @@ func catch(recover func(error) *Promise, k func(context.Context) *Promise) *Prom
 }
 
 // Force enforces the delayed execution and returns the result. (i.e. trampoline)
+// If ctx carries inference limits, it returns resource_error(inferences) once any of them is exceeded.
 func (p *Promise) Force(ctx context.Context) (ok bool, err error) {
+	limit, _ := ctx.Value(inferenceLimitKey{}).(*inferenceLimit)
 	stack := promiseStack{p}
 	for len(stack) > 0 {
 		select {
@@ -104,6 +110,10 @@ func (p *Promise) Force(ctx context.Context) (ok bool, err error) {
 				p.cutParent = nil // we don't have to do this again when we revisit.
 			}
 
+			if limit != nil && !limit.infer() {
+				return false, resourceError(resourceInferences, nil)
+			}
+
 			// Try the child promises from left to right.
 			q := p.child(ctx)
 			stack = append(stack, p, q)
@@ -124,12 +134,50 @@ func (p *Promise) child(ctx context.Context) (promise *Promise) {
 
 func ensurePromise(p **Promise) {
 	if r := recover(); r != nil {
-		*p = Error(panicError(r))
+		*p = Error(&panicError{value: r})
 	}
 }
 
-func panicError(r interface{}) error {
-	return fmt.Errorf("panic: %v", r)
+type inferenceLimitKey struct{}
+
+// inferenceLimit counts inferences, i.e. steps of Force, against max. It also counts them against the enclosing limits.
+type inferenceLimit struct {
+	parent *inferenceLimit
+	max    uint64
+	count  uint64
+}
+
+// WithInferenceLimit returns a copy of ctx which limits the number of inferences to n for the queries forced with it.
+// An inference is a step of the execution such as calling a user-defined procedure or trying its next clause.
+// Limits nest so that the inferences under the returned context count against the limits of ctx as well.
+func WithInferenceLimit(ctx context.Context, n uint64) context.Context {
+	parent, _ := ctx.Value(inferenceLimitKey{}).(*inferenceLimit)
+	return context.WithValue(ctx, inferenceLimitKey{}, &inferenceLimit{parent: parent, max: n})
+}
+
+// infer counts an inference and reports whether it's within all the limits.
+func (l *inferenceLimit) infer() bool {
+	ok := true
+	for ; l != nil; l = l.parent {
+		if atomic.AddUint64(&l.count, 1) > l.max {
+			ok = false
+		}
+	}
+	return ok
+}
+
+func (l *inferenceLimit) exceeded() bool {
+	return atomic.LoadUint64(&l.count) > l.max
+}
+
+// panicError is an error caused by a Go panic in a predicate.
+type panicError struct {
+	value    interface{}
+	reported bool
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
 }
 
 type promiseStack []*Promise
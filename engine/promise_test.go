@@ -24,7 +24,7 @@ func TestPromise_Force(t *testing.T) {
 				return Bool(false)
 			}, func(context.Context) *Promise {
 				res = append(res, 6)
-				return cut(nil, func(context.Context) *Promise {
+				return Cut(nil, func(context.Context) *Promise {
 					return Bool(true)
 				})
 			}, func(context.Context) *Promise {
@@ -72,4 +72,32 @@ func TestPromise_Force(t *testing.T) {
 		assert.True(t, ok)
 		assert.Equal(t, 10, count)
 	})
+
+	t.Run("inference limit", func(t *testing.T) {
+		for _, tt := range []struct {
+			title string
+			ctx   context.Context
+			ok    bool
+			err   error
+			count int
+		}{
+			{title: "within", ctx: WithInferenceLimit(context.Background(), 10), ok: true, count: 10},
+			{title: "exceeded", ctx: WithInferenceLimit(context.Background(), 5), err: resourceError(resourceInferences, nil), count: 5},
+			{title: "outer exceeded", ctx: WithInferenceLimit(WithInferenceLimit(context.Background(), 5), 10), err: resourceError(resourceInferences, nil), count: 5},
+			{title: "inner exceeded", ctx: WithInferenceLimit(WithInferenceLimit(context.Background(), 10), 5), err: resourceError(resourceInferences, nil), count: 5},
+		} {
+			t.Run(tt.title, func(t *testing.T) {
+				count := 0
+				k := repeat(func(context.Context) *Promise {
+					count++
+					return Bool(count >= 10)
+				})
+
+				ok, err := k.Force(tt.ctx)
+				assert.Equal(t, tt.err, err)
+				assert.Equal(t, tt.ok, ok)
+				assert.Equal(t, tt.count, count)
+			})
+		}
+	})
 }
@@ -2,10 +2,12 @@ package engine
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"unicode/utf8"
 	"unsafe"
 )
 
@@ -29,10 +31,13 @@ type Stream struct {
 	mode        ioMode
 	alias       Atom
 	position    int64
+	column      int
 	endOfStream endOfStream
 	eofAction   eofAction
 	reposition  bool
 	streamType  streamType
+	autoFlush   bool
+	sync        bool
 }
 
 // NewInputTextStream creates a new input text stream backed by the given io.Reader.
@@ -245,6 +250,9 @@ func (s *Stream) WriteByte(c byte) error {
 		return err
 	}
 	_, err = b.Write([]byte{c})
+	if err == nil && s.autoFlush {
+		err = s.Flush()
+	}
 	return err
 }
 
@@ -255,7 +263,29 @@ func (s *Stream) WriteRune(r rune) (size int, err error) {
 	if err != nil {
 		return 0, err
 	}
-	return t.Write([]byte(string(r)))
+	size, err = t.Write([]byte(string(r)))
+	if err == nil && s.autoFlush {
+		err = s.Flush()
+	}
+	return size, err
+}
+
+// SetAutoFlush sets whether the stream flushes the buffered output after each output operation.
+// It's useful for an output stream backed by a buffered writer, e.g. *bufio.Writer, in interactive use.
+func (s *Stream) SetAutoFlush(autoFlush bool) {
+	s.autoFlush = autoFlush
+}
+
+// SetSync sets whether Flush and Close also commit the output to stable storage if the sink supports it, e.g. *os.File.
+// It's off by default since committing is costly and fails for some sinks, e.g. *os.File of a pipe or a terminal.
+// open/4 turns it on with the stream option sync(true).
+func (s *Stream) SetSync(sync bool) {
+	s.sync = sync
+}
+
+// E.g. *os.File.
+type syncer interface {
+	Sync() error
 }
 
 // Flush flushes the buffered output to the sink.
@@ -265,23 +295,21 @@ func (s *Stream) Flush() error {
 		Flush() error
 	}
 
-	// E.g. *os.File.
-	type syncer interface {
-		Sync() error
-	}
-
 	if s.mode != ioModeWrite && s.mode != ioModeAppend {
 		return errWrongIOMode
 	}
 
-	switch f := s.sink.(type) {
-	case flusher:
-		return f.Flush()
-	case syncer:
+	if f, ok := s.sink.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if f, ok := s.sink.(syncer); ok && s.sync {
 		return f.Sync()
-	default:
-		return nil
 	}
+
+	return nil
 }
 
 // Close closes the underlying source/sink.
@@ -292,6 +320,12 @@ func (s *Stream) Close() error {
 		}
 	}
 
+	if f, ok := s.sink.(syncer); ok && s.sync {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+
 	if c, ok := s.sink.(io.Closer); ok {
 		if err := c.Close(); err != nil {
 			return err
@@ -319,6 +353,11 @@ func (s *Stream) initRead() error {
 		case eofActionError:
 			return errPastEndOfStream
 		case eofActionReset:
+			// If the stream can't be repositioned, e.g. user_input, another attempt will be made from where it is.
+			if !s.reposition {
+				s.endOfStream = endOfStreamNot
+				return nil
+			}
 			_, err := s.Seek(0, io.SeekStart)
 			return err
 		}
@@ -339,39 +378,104 @@ func (s *Stream) checkEOS() {
 	}
 }
 
-func (s *Stream) properties() []Term {
-	ps := make([]Term, 0, 9)
+// peekEOS updates the end of stream of an input stream by peeking the next byte through the buffered reader.
+// It doesn't peek a file which is not a regular file, e.g. a terminal, since it may block until there's an input.
+func (s *Stream) peekEOS() {
+	if s.mode != ioModeRead || s.source == nil || s.endOfStream == endOfStreamPast {
+		return
+	}
+
+	if f, ok := s.source.(*os.File); ok {
+		fi, err := f.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return
+		}
+	}
+
+	if s.buf == nil {
+		s.buf = bufio.NewReader(s.source)
+	}
+
+	switch _, err := s.buf.Peek(1); {
+	case err == nil:
+		s.endOfStream = endOfStreamNot
+	case errors.Is(err, io.EOF):
+		s.endOfStream = endOfStreamAt
+	}
+}
+
+// properties returns the properties of the stream. If want isn't zero, it returns only the ones of the same name and
+// arity so that it doesn't compute the others, e.g. end_of_stream.
+func (s *Stream) properties(want procedureIndicator) []Term {
+	match := func(name Atom, arity Integer) bool {
+		return want == (procedureIndicator{}) || want == procedureIndicator{name: name, arity: arity}
+	}
 
-	if n := s.Name(); n != "" {
+	ps := make([]Term, 0, 10)
+
+	if n := s.Name(); n != "" && match(atomFileName, 1) {
 		ps = append(ps, atomFileName.Apply(NewAtom(n)))
 	}
 
-	ps = append(ps, atomMode.Apply(s.mode.Term()))
+	if match(atomMode, 1) {
+		ps = append(ps, atomMode.Apply(s.mode.Term()))
+	}
 
 	switch s.mode {
 	case ioModeRead:
-		ps = append(ps, atomInput)
+		if match(atomInput, 0) {
+			ps = append(ps, atomInput)
+		}
 	case ioModeWrite, ioModeAppend:
-		ps = append(ps, atomOutput)
+		if match(atomOutput, 0) {
+			ps = append(ps, atomOutput)
+		}
 	}
 
-	if s.alias != 0 {
+	if s.alias != 0 && match(atomAlias, 1) {
 		ps = append(ps, atomAlias.Apply(s.alias))
 	}
 
-	ps = append(ps,
-		atomPosition.Apply(Integer(s.position)),
-		atomEndOfStream.Apply(s.endOfStream.Term()),
-		atomEOFAction.Apply(s.eofAction.Term()),
-	)
+	if match(atomPosition, 1) {
+		ps = append(ps, atomPosition.Apply(Integer(s.position)))
+	}
+
+	if match(atomEndOfStream, 1) {
+		s.peekEOS()
+		ps = append(ps, atomEndOfStream.Apply(s.endOfStream.Term()))
+	}
 
-	if s.reposition {
-		ps = append(ps, atomReposition.Apply(atomTrue))
-	} else {
-		ps = append(ps, atomReposition.Apply(atomFalse))
+	if match(atomEOFAction, 1) {
+		ps = append(ps, atomEOFAction.Apply(s.eofAction.Term()))
 	}
 
-	ps = append(ps, atomType.Apply(s.streamType.Term()))
+	if match(atomReposition, 1) {
+		if s.reposition {
+			ps = append(ps, atomReposition.Apply(atomTrue))
+		} else {
+			ps = append(ps, atomReposition.Apply(atomFalse))
+		}
+	}
+
+	if match(atomType, 1) {
+		ps = append(ps, atomType.Apply(s.streamType.Term()))
+	}
+
+	if (s.mode == ioModeWrite || s.mode == ioModeAppend) && match(atomAutoFlush, 1) {
+		if s.autoFlush {
+			ps = append(ps, atomAutoFlush.Apply(atomTrue))
+		} else {
+			ps = append(ps, atomAutoFlush.Apply(atomFalse))
+		}
+	}
+
+	if (s.mode == ioModeWrite || s.mode == ioModeAppend) && match(atomSync, 1) {
+		if s.sync {
+			ps = append(ps, atomSync.Apply(atomTrue))
+		} else {
+			ps = append(ps, atomSync.Apply(atomFalse))
+		}
+	}
 
 	return ps
 }
@@ -410,9 +514,126 @@ func (t textWriter) Write(p []byte) (int, error) {
 	s := t.stream
 	n, err := s.sink.Write(p)
 	s.position += int64(n)
+	if i := bytes.LastIndexByte(p[:n], '\n'); i >= 0 {
+		s.column = utf8.RuneCount(p[i+1 : n])
+	} else {
+		s.column += utf8.RuneCount(p[:n])
+	}
+	return n, err
+}
+
+// columnWriter is an io.Writer which keeps track of the column of the output.
+// It holds back the text after a fill position so that the padding can be inserted there at the next column stop.
+type columnWriter struct {
+	w      io.Writer
+	column int // The column where the pending text starts.
+	stop   int // The last column stop.
+
+	pending []rune
+	fills   []columnFill
+}
+
+// columnFill is a position in the pending text where padding characters are inserted.
+type columnFill struct {
+	pos  int
+	char rune
+}
+
+func newColumnWriter(w io.Writer, column int) *columnWriter {
+	return &columnWriter{w: w, column: column, stop: column}
+}
+
+// Write holds back p until the next column stop or flush if there's a fill position where padding can be inserted.
+// Otherwise, or if p contains a newline which resets the column, p is written immediately.
+func (c *columnWriter) Write(p []byte) (int, error) {
+	if len(c.fills) > 0 {
+		if bytes.IndexByte(p, '\n') < 0 {
+			c.pending = append(c.pending, []rune(string(p))...)
+			return len(p), nil
+		}
+		if err := c.Flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := c.w.Write(p)
+	if i := bytes.LastIndexByte(p[:n], '\n'); i >= 0 {
+		c.column, c.stop = utf8.RuneCount(p[i+1:n]), 0
+	} else {
+		c.column += utf8.RuneCount(p[:n])
+	}
 	return n, err
 }
 
+// Column returns the current column.
+func (c *columnWriter) Column() int {
+	return c.column + len(c.pending)
+}
+
+// fill marks the current position as where padding characters are inserted at the next column stop.
+func (c *columnWriter) fill(char rune) {
+	c.fills = append(c.fills, columnFill{pos: len(c.pending), char: char})
+}
+
+// columnStop pads the pending text up to column and writes it.
+// The padding is distributed among the fill positions, or appended if there's none.
+// If the pending text is already past column, it's written as is.
+func (c *columnWriter) columnStop(column int) error {
+	if pad := column - c.Column(); pad > 0 {
+		fills := c.fills
+		if len(fills) == 0 {
+			fills = []columnFill{{pos: len(c.pending), char: ' '}}
+		}
+		var last int
+		for i, f := range fills {
+			n := pad / len(fills)
+			if i >= len(fills)-pad%len(fills) {
+				n++
+			}
+			if _, err := io.WriteString(c.w, string(c.pending[last:f.pos])); err != nil {
+				return err
+			}
+			if err := writeRepeat(c.w, f.char, n); err != nil {
+				return err
+			}
+			last = f.pos
+		}
+		c.column += last + pad
+		c.pending = c.pending[last:]
+	}
+	c.stop = c.Column()
+	return c.Flush()
+}
+
+// Flush writes the pending text without padding.
+func (c *columnWriter) Flush() error {
+	if _, err := io.WriteString(c.w, string(c.pending)); err != nil {
+		return err
+	}
+	c.column += len(c.pending)
+	c.pending = c.pending[:0]
+	c.fills = c.fills[:0]
+	return nil
+}
+
+// writeRepeat writes r n times to w. It writes in chunks so that the memory it uses doesn't depend on n.
+func writeRepeat(w io.Writer, r rune, n int) error {
+	const chunkSize = 1024
+	c := []byte(string(r))
+	chunk := bytes.Repeat(c, chunkSize)
+	for n > 0 {
+		m := n
+		if m > chunkSize {
+			m = chunkSize
+		}
+		if _, err := w.Write(chunk[:m*len(c)]); err != nil {
+			return err
+		}
+		n -= m
+	}
+	return nil
+}
+
 type binaryWriter struct {
 	stream *Stream
 }
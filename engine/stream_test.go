@@ -170,6 +170,13 @@ func TestStream_Close(t *testing.T) {
 	}
 	ngCloser.mockCloser.On("Close").Return(errors.New("ng"))
 
+	var ngSyncer struct {
+		mockWriter
+		mockSyncer
+		mockCloser
+	}
+	ngSyncer.mockSyncer.On("Sync").Return(errors.New("ng"))
+
 	var vm VM
 
 	foo := NewAtom("foo")
@@ -189,6 +196,7 @@ func TestStream_Close(t *testing.T) {
 		{title: "alias", s: s},
 
 		{title: "ng closer", s: &Stream{sink: &ngCloser}, err: errors.New("ng")},
+		{title: "ng syncer", s: &Stream{sink: &ngSyncer, sync: true}, err: errors.New("ng")},
 	}
 
 	for _, tt := range tests {
@@ -258,6 +266,13 @@ func TestStream_ReadByte(t *testing.T) {
 			pos:   1,
 			eos:   endOfStreamNot,
 		},
+		{
+			title: "end of stream past: reset without reposition",
+			s:     &Stream{source: bytes.NewReader([]byte{}), streamType: streamTypeBinary, endOfStream: endOfStreamPast, eofAction: eofActionReset, position: 3},
+			err:   io.EOF,
+			pos:   3,
+			eos:   endOfStreamPast,
+		},
 		{
 			title: "input text",
 			s:     &Stream{source: bytes.NewReader([]byte{1, 2, 3}), streamType: streamTypeText},
@@ -338,6 +353,13 @@ func TestStream_ReadRune(t *testing.T) {
 			pos:   1,
 			eos:   endOfStreamNot,
 		},
+		{
+			title: "end of stream past: reset without reposition",
+			s:     &Stream{source: bytes.NewReader([]byte("")), streamType: streamTypeText, endOfStream: endOfStreamPast, eofAction: eofActionReset, position: 3},
+			err:   io.EOF,
+			pos:   3,
+			eos:   endOfStreamPast,
+		},
 		{
 			title: "input binary",
 			s:     &Stream{source: bytes.NewReader([]byte("abc")), streamType: streamTypeBinary},
@@ -565,10 +587,36 @@ func TestStream_Flush(t *testing.T) {
 		m.mockSyncer.On("Sync").Return(nil).Once()
 		defer m.mockSyncer.AssertExpectations(t)
 
+		s := &Stream{sink: &m, mode: ioModeAppend, sync: true}
+		assert.NoError(t, s.Flush())
+	})
+
+	t.Run("syncer without sync", func(t *testing.T) {
+		var m struct {
+			mockWriter
+			mockSyncer
+		}
+		defer m.mockSyncer.AssertExpectations(t)
+
 		s := &Stream{sink: &m, mode: ioModeAppend}
 		assert.NoError(t, s.Flush())
 	})
 
+	t.Run("flusher and syncer", func(t *testing.T) {
+		var m struct {
+			mockWriter
+			mockFlusher
+			mockSyncer
+		}
+		m.mockFlusher.On("Flush").Return(nil).Once()
+		defer m.mockFlusher.AssertExpectations(t)
+		m.mockSyncer.On("Sync").Return(nil).Once()
+		defer m.mockSyncer.AssertExpectations(t)
+
+		s := &Stream{sink: &m, mode: ioModeAppend, sync: true}
+		assert.NoError(t, s.Flush())
+	})
+
 	t.Run("else", func(t *testing.T) {
 		var m mockWriter
 		defer m.AssertExpectations(t)
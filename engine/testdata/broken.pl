@@ -0,0 +1,4 @@
+foo(a).
+foo(b :- .
+foo(c).
+bar(.
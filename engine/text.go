@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/fs"
 	"strings"
 )
@@ -19,10 +20,20 @@ func (e *discontiguousError) Error() string {
 // Compile compiles the Prolog text and updates the DB accordingly.
 func (vm *VM) Compile(ctx context.Context, s string, args ...interface{}) error {
 	var t text
-	if err := vm.compile(ctx, &t, s, args...); err != nil {
+	return vm.load(ctx, &t, s, args...)
+}
+
+func (vm *VM) load(ctx context.Context, t *text, s string, args ...interface{}) error {
+	if err := vm.compile(ctx, t, s, args...); err != nil {
 		return err
 	}
 
+	// Replace the cache entries of the previous load so that the cache doesn't grow on reloads.
+	if vm.compiled == nil {
+		vm.compiled = map[string]compileCache{}
+	}
+	vm.compiled[t.source] = t.compiled
+
 	if err := t.flush(); err != nil {
 		return err
 	}
@@ -32,7 +43,7 @@ func (vm *VM) Compile(ctx context.Context, s string, args ...interface{}) error
 	}
 	for pi, u := range t.clauses {
 		if existing, ok := vm.procedures[pi].(*userDefined); ok && existing.multifile && u.multifile {
-			existing.clauses = append(existing.clauses, u.clauses...)
+			existing.append(u.clauses)
 			continue
 		}
 
@@ -57,6 +68,43 @@ func (vm *VM) Compile(ctx context.Context, s string, args ...interface{}) error
 
 // Consult executes Prolog texts in files.
 func Consult(vm *VM, files Term, k Cont, env *Env) *Promise {
+	return consult(vm, files, errorActionHalt, k, env)
+}
+
+// Consult2 executes Prolog texts in files with options.
+// With on_error(continue), a clause with a syntax error is reported by print_message/2 and skipped so that the rest
+// of the text is loaded. With on_error(halt), which is the default, the syntax error is raised.
+func Consult2(vm *VM, files, options Term, k Cont, env *Env) *Promise {
+	var onError errorAction
+	iter := ListIterator{List: options, Env: env}
+	for iter.Next() {
+		switch option := env.Resolve(iter.Current()).(type) {
+		case Variable:
+			return Error(InstantiationError(env))
+		case Compound:
+			if option.Functor() != atomOnError || option.Arity() != 1 {
+				return Error(domainError(validDomainConsultOption, option, env))
+			}
+			switch env.Resolve(option.Arg(0)) {
+			case atomHalt:
+				onError = errorActionHalt
+			case atomContinue:
+				onError = errorActionContinue
+			default:
+				return Error(domainError(validDomainConsultOption, option, env))
+			}
+		default:
+			return Error(domainError(validDomainConsultOption, option, env))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return Error(err)
+	}
+
+	return consult(vm, files, onError, k, env)
+}
+
+func consult(vm *VM, files Term, onError errorAction, k Cont, env *Env) *Promise {
 	var filenames []Term
 	iter := ListIterator{List: files, Env: env}
 	for iter.Next() {
@@ -68,7 +116,7 @@ func Consult(vm *VM, files Term, k Cont, env *Env) *Promise {
 
 	return Delay(func(ctx context.Context) *Promise {
 		for _, filename := range filenames {
-			if err := vm.ensureLoaded(ctx, filename, env); err != nil {
+			if err := vm.ensureLoaded(ctx, filename, onError, env); err != nil {
 				return Error(err)
 			}
 		}
@@ -81,6 +129,9 @@ func (vm *VM) compile(ctx context.Context, text *text, s string, args ...interfa
 	if text.clauses == nil {
 		text.clauses = map[procedureIndicator]*userDefined{}
 	}
+	if text.compiled == nil {
+		text.compiled = compileCache{}
+	}
 
 	s = ignoreShebangLine(s)
 	p := NewParser(vm, strings.NewReader(s))
@@ -89,10 +140,23 @@ func (vm *VM) compile(ctx context.Context, text *text, s string, args ...interfa
 	}
 
 	for p.More() {
-		p.Vars = p.Vars[:]
 		t, err := p.Term()
 		if err != nil {
-			return err
+			if text.onError != errorActionContinue {
+				return err
+			}
+			// Report the skipped clause and resynchronize to the next clause.
+			if _, err := PrintMessage(vm, atomError, syntaxError(err, nil).Term(), Success, nil).Force(ctx); err != nil {
+				return err
+			}
+			switch err := p.resync(err); err {
+			case nil:
+				continue
+			case io.EOF:
+				return nil
+			default:
+				return err
+			}
 		}
 
 		et, err := expand(vm, t, nil)
@@ -104,6 +168,12 @@ func (vm *VM) compile(ctx context.Context, text *text, s string, args ...interfa
 		if err != nil {
 			return err
 		}
+		if vm.WarnSingletons && pi != (procedureIndicator{name: atomIf, arity: 1}) {
+			if err := vm.warnSingletons(ctx, p.Vars); err != nil {
+				return err
+			}
+		}
+
 		switch pi {
 		case procedureIndicator{name: atomIf, arity: 1}: // Directive
 			if err := vm.directive(ctx, text, arg(0)); err != nil {
@@ -123,7 +193,7 @@ func (vm *VM) compile(ctx context.Context, text *text, s string, args ...interfa
 				}
 			}
 
-			cs, err := compile(et, nil)
+			cs, err := vm.compileCached(text, et, nil)
 			if err != nil {
 				return err
 			}
@@ -134,6 +204,21 @@ func (vm *VM) compile(ctx context.Context, text *text, s string, args ...interfa
 	return nil
 }
 
+// warnSingletons prints a warning message singletons(Names) if the clause has named variables which appear only once.
+func (vm *VM) warnSingletons(ctx context.Context, vars []ParsedVariable) error {
+	var names []Term
+	for _, v := range vars {
+		if v.Count == 1 && !strings.HasPrefix(v.Name.String(), "_") {
+			names = append(names, v.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	_, err := PrintMessage(vm, atomWarning, atomSingletons.Apply(List(names...)), Success, nil).Force(ctx)
+	return err
+}
+
 func (vm *VM) directive(ctx context.Context, text *text, d Term) error {
 	if err := text.flush(); err != nil {
 		return err
@@ -164,7 +249,7 @@ func (vm *VM) directive(ctx context.Context, text *text, d Term) error {
 
 		return vm.compile(ctx, text, string(b))
 	case procedureIndicator{name: atomEnsureLoaded, arity: 1}:
-		return vm.ensureLoaded(ctx, arg(0), nil)
+		return vm.ensureLoaded(ctx, arg(0), text.onError, nil)
 	default:
 		ok, err := Call(vm, d, Success, nil).Force(ctx)
 		if err != nil {
@@ -180,7 +265,7 @@ func (vm *VM) directive(ctx context.Context, text *text, d Term) error {
 	}
 }
 
-func (vm *VM) ensureLoaded(ctx context.Context, file Term, env *Env) error {
+func (vm *VM) ensureLoaded(ctx context.Context, file Term, onError errorAction, env *Env) error {
 	f, b, err := vm.open(file, env)
 	if err != nil {
 		return err
@@ -196,7 +281,7 @@ func (vm *VM) ensureLoaded(ctx context.Context, file Term, env *Env) error {
 		vm.loaded[f] = struct{}{}
 	}()
 
-	return vm.Compile(ctx, string(b))
+	return vm.load(ctx, &text{source: f, onError: onError}, string(b))
 }
 
 func (vm *VM) open(file Term, env *Env) (string, []byte, error) {
@@ -220,11 +305,22 @@ func (vm *VM) open(file Term, env *Env) (string, []byte, error) {
 }
 
 type text struct {
-	buf     clauses
-	clauses map[procedureIndicator]*userDefined
-	goals   []Term
+	source   string // The file name of the text, or empty if it's given by Compile.
+	buf      clauses
+	clauses  map[procedureIndicator]*userDefined
+	goals    []Term
+	onError  errorAction
+	compiled compileCache
 }
 
+// errorAction is what to do when a clause in the text has a syntax error.
+type errorAction uint8
+
+const (
+	errorActionHalt errorAction = iota
+	errorActionContinue
+)
+
 func (t *text) forEachUserDefined(pi Term, f func(u *userDefined)) error {
 	iter := anyIterator{Any: pi}
 	for iter.Next() {
@@ -277,7 +373,7 @@ func (t *text) flush() error {
 	if len(u.clauses) > 0 && !u.discontiguous {
 		return &discontiguousError{pi: pi}
 	}
-	u.clauses = append(u.clauses, t.buf...)
+	u.append(t.buf)
 	t.buf = t.buf[:0]
 	return nil
 }
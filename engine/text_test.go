@@ -1,10 +1,13 @@
 package engine
 
 import (
+	"bytes"
 	"context"
 	"embed"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -160,6 +163,104 @@ bar(X, "abc", [a, b], [a, b|Y], f(a)) :- X, !, foo(X, "abc", [a, b], [a, b|Y], f
 				},
 			},
 		}},
+		{title: "control constructs", text: `
+baz :- ';'('->'(a, !), b).
+qux :- ','(';'(a, b), c).
+quux :- '->'(a, b).
+`, result: map[procedureIndicator]procedure{
+			{name: NewAtom("foo"), arity: 1}: &userDefined{
+				multifile: true,
+				clauses: clauses{
+					{
+						pi:  procedureIndicator{name: NewAtom("foo"), arity: 1},
+						raw: &compound{functor: NewAtom("foo"), args: []Term{NewAtom("c")}},
+						bytecode: bytecode{
+							{opcode: opGetConst, operand: NewAtom("c")},
+							{opcode: opExit},
+						},
+					},
+				},
+			},
+			{name: NewAtom("baz"), arity: 0}: &userDefined{
+				clauses: clauses{
+					{
+						pi:  procedureIndicator{name: NewAtom("baz"), arity: 0},
+						raw: atomIf.Apply(NewAtom("baz"), atomSemiColon.Apply(atomThen.Apply(NewAtom("a"), atomCut), NewAtom("b"))),
+						bytecode: bytecode{
+							{opcode: opEnter},
+							{opcode: opIfThenElse, branches: []bytecode{
+								{
+									{opcode: opEnter},
+									{opcode: opCall, operand: procedureIndicator{name: NewAtom("a"), arity: 0}},
+									{opcode: opExit},
+								},
+								{
+									{opcode: opEnter},
+									{opcode: opCut},
+									{opcode: opExit},
+								},
+								{
+									{opcode: opEnter},
+									{opcode: opCall, operand: procedureIndicator{name: NewAtom("b"), arity: 0}},
+									{opcode: opExit},
+								},
+							}},
+							{opcode: opExit},
+						},
+					},
+				},
+			},
+			{name: NewAtom("qux"), arity: 0}: &userDefined{
+				clauses: clauses{
+					{
+						pi:  procedureIndicator{name: NewAtom("qux"), arity: 0},
+						raw: atomIf.Apply(NewAtom("qux"), atomComma.Apply(atomSemiColon.Apply(NewAtom("a"), NewAtom("b")), NewAtom("c"))),
+						bytecode: bytecode{
+							{opcode: opEnter},
+							{opcode: opOr, branches: []bytecode{
+								{
+									{opcode: opEnter},
+									{opcode: opCall, operand: procedureIndicator{name: NewAtom("a"), arity: 0}},
+									{opcode: opExit},
+								},
+								{
+									{opcode: opEnter},
+									{opcode: opCall, operand: procedureIndicator{name: NewAtom("b"), arity: 0}},
+									{opcode: opExit},
+								},
+							}},
+							{opcode: opCall, operand: procedureIndicator{name: NewAtom("c"), arity: 0}},
+							{opcode: opExit},
+						},
+					},
+				},
+			},
+			{name: NewAtom("quux"), arity: 0}: &userDefined{
+				clauses: clauses{
+					{
+						pi:  procedureIndicator{name: NewAtom("quux"), arity: 0},
+						raw: atomIf.Apply(NewAtom("quux"), atomThen.Apply(NewAtom("a"), NewAtom("b"))),
+						bytecode: bytecode{
+							{opcode: opEnter},
+							{opcode: opIfThenElse, branches: []bytecode{
+								{
+									{opcode: opEnter},
+									{opcode: opCall, operand: procedureIndicator{name: NewAtom("a"), arity: 0}},
+									{opcode: opExit},
+								},
+								{
+									{opcode: opEnter},
+									{opcode: opCall, operand: procedureIndicator{name: NewAtom("b"), arity: 0}},
+									{opcode: opExit},
+								},
+								nil,
+							}},
+							{opcode: opExit},
+						},
+					},
+				},
+			},
+		}},
 		{title: "dynamic", text: `
 :- dynamic(foo/1).
 foo(a).
@@ -499,6 +600,205 @@ func TestVM_Consult(t *testing.T) {
 	}
 }
 
+func TestConsult2(t *testing.T) {
+	x := NewVariable()
+
+	tests := []struct {
+		title   string
+		files   Term
+		options Term
+		ok      bool
+		err     error
+	}{
+		{title: `:- consult('testdata/abc.txt', []).`, files: NewAtom("testdata/abc.txt"), options: List(), err: io.EOF},
+		{title: `:- consult('testdata/abc.txt', [on_error(continue)]).`, files: NewAtom("testdata/abc.txt"), options: List(atomOnError.Apply(atomContinue)), ok: true},
+		{title: `:- consult('testdata/empty.txt', [on_error(halt)]).`, files: NewAtom("testdata/empty.txt"), options: List(atomOnError.Apply(atomHalt)), ok: true},
+		{title: `:- consult('testdata/empty.txt', [X]).`, files: NewAtom("testdata/empty.txt"), options: List(x), err: InstantiationError(nil)},
+		{title: `:- consult('testdata/empty.txt', [on_error(foo)]).`, files: NewAtom("testdata/empty.txt"), options: List(atomOnError.Apply(NewAtom("foo"))), err: domainError(validDomainConsultOption, atomOnError.Apply(NewAtom("foo")), nil)},
+		{title: `:- consult('testdata/empty.txt', [foo]).`, files: NewAtom("testdata/empty.txt"), options: List(NewAtom("foo")), err: domainError(validDomainConsultOption, NewAtom("foo"), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			vm := VM{
+				FS: testdata,
+			}
+			vm.SetUserError(NewOutputTextStream(io.Discard))
+			ok, err := Consult2(&vm, tt.files, tt.options, Success, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			if e, ok := tt.err.(Exception); ok {
+				_, ok := NewEnv().Unify(e.Term(), err.(Exception).Term())
+				assert.True(t, ok)
+			} else {
+				assert.Equal(t, tt.err, err)
+			}
+		})
+	}
+
+	t.Run("on_error(continue)", func(t *testing.T) {
+		var buf bytes.Buffer
+		vm := VM{
+			FS: testdata,
+		}
+		vm.SetUserError(NewOutputTextStream(&buf))
+		ok, err := Consult2(&vm, NewAtom("testdata/broken.pl"), List(atomOnError.Apply(atomContinue)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		foo := vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined)
+		assert.Len(t, foo.clauses, 2)
+		assert.Equal(t, NewAtom("foo").Apply(NewAtom("a")), foo.clauses[0].raw)
+		assert.Equal(t, NewAtom("foo").Apply(NewAtom("c")), foo.clauses[1].raw)
+
+		// The skipped clause is reported.
+		assert.Contains(t, buf.String(), "syntax_error")
+	})
+
+	t.Run("on_error(halt)", func(t *testing.T) {
+		vm := VM{
+			FS: testdata,
+		}
+		ok, err := Consult2(&vm, NewAtom("testdata/broken.pl"), List(atomOnError.Apply(atomHalt)), Success, nil).Force(context.Background())
+		assert.Error(t, err)
+		assert.False(t, ok)
+
+		_, ok = vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}]
+		assert.False(t, ok)
+	})
+}
+
+func TestVM_Compile_cache(t *testing.T) {
+	foo := NewAtom("foo")
+	newVM := func() *VM {
+		var vm VM
+		vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+		vm.operators.define(1000, operatorSpecifierXFY, atomComma)
+		vm.operators.define(700, operatorSpecifierXFX, atomEqual)
+		vm.Register2(atomEqual, Unify)
+		vm.Register0(atomTrue, func(_ *VM, k Cont, env *Env) *Promise {
+			return k(env)
+		})
+		return &vm
+	}
+	bytecodeOf := func(vm *VM, arity Integer) []*instruction {
+		u := vm.procedures[procedureIndicator{name: foo, arity: arity}].(*userDefined)
+		ret := make([]*instruction, len(u.clauses))
+		for i, c := range u.clauses {
+			ret[i] = &c.bytecode[0]
+		}
+		return ret
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		vm := newVM()
+		assert.NoError(t, vm.Compile(context.Background(), "foo(X, Y) :- X = Y. foo(a, b)."))
+		before := bytecodeOf(vm, 2)
+		assert.NoError(t, vm.Compile(context.Background(), "foo(A, B) :- A = B. foo(a, b)."))
+		after := bytecodeOf(vm, 2)
+		assert.True(t, before[0] == after[0])
+		assert.True(t, before[1] == after[1])
+
+		u := vm.procedures[procedureIndicator{name: foo, arity: 2}].(*userDefined)
+		c := u.clauses[0].raw.(Compound)
+		assert.Equal(t, atomIf, c.Functor())
+		assert.Equal(t, NewAtom("b"), u.clauses[1].raw.(Compound).Arg(1))
+
+		ok, err := Call(vm, foo.Apply(Integer(1), Integer(1)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		vm := newVM()
+		assert.NoError(t, vm.Compile(context.Background(), "foo(X, Y) :- X = Y. foo(a, b)."))
+		before := bytecodeOf(vm, 2)
+		assert.NoError(t, vm.Compile(context.Background(), "foo(X, X) :- true. foo(a, c)."))
+		after := bytecodeOf(vm, 2)
+		assert.False(t, before[0] == after[0])
+		assert.False(t, before[1] == after[1])
+
+		ok, err := Call(vm, foo.Apply(NewAtom("a"), NewAtom("c")), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = Call(vm, foo.Apply(NewAtom("a"), NewAtom("b")), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("variables shared differently", func(t *testing.T) {
+		vm := newVM()
+		assert.NoError(t, vm.Compile(context.Background(), "foo(X, Y)."))
+		assert.NoError(t, vm.Compile(context.Background(), "foo(X, X)."))
+
+		ok, err := Call(vm, foo.Apply(NewAtom("a"), NewAtom("b")), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("replaced on reload", func(t *testing.T) {
+		vm := newVM()
+		assert.NoError(t, vm.Compile(context.Background(), "foo(a, b). foo(b, c)."))
+		before := bytecodeOf(vm, 2)
+		assert.Len(t, vm.compiled[""], 2)
+		assert.NoError(t, vm.Compile(context.Background(), "foo(c, d)."))
+		assert.Len(t, vm.compiled[""], 1)
+		assert.NoError(t, vm.Compile(context.Background(), "foo(a, b). foo(b, c)."))
+		after := bytecodeOf(vm, 2)
+		assert.False(t, before[0] == after[0])
+		assert.False(t, before[1] == after[1])
+	})
+
+	t.Run("sources", func(t *testing.T) {
+		vm := newVM()
+		vm.operators.define(1200, operatorSpecifierFX, atomIf)
+		vm.FS = testdata
+		assert.NoError(t, vm.Compile(context.Background(), ":- ensure_loaded('testdata/foo')."))
+		assert.NoError(t, vm.Compile(context.Background(), "foo(a, b)."))
+		assert.Len(t, vm.compiled[""], 1)
+		assert.Len(t, vm.compiled["testdata/foo.pl"], 1)
+	})
+}
+
+func BenchmarkVM_Compile(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 10000; i++ {
+		_, _ = fmt.Fprintf(&sb, "foo(%d, X, [a, b, c|T]) :- bar(X, Y), baz(Y, T, f(g(h(%d)))), (X > 1 -> true ; fail).\n", i, i)
+	}
+	text := sb.String()
+
+	newVM := func() *VM {
+		var vm VM
+		vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+		vm.operators.define(1100, operatorSpecifierXFY, atomSemiColon)
+		vm.operators.define(1050, operatorSpecifierXFY, atomThen)
+		vm.operators.define(1000, operatorSpecifierXFY, atomComma)
+		vm.operators.define(700, operatorSpecifierXFX, atomGreaterThan)
+		return &vm
+	}
+
+	b.Run("load", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := newVM().Compile(context.Background(), text); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("reload", func(b *testing.B) {
+		vm := newVM()
+		if err := vm.Compile(context.Background(), text); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := vm.Compile(context.Background(), text); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
 func TestDiscontiguousError_Error(t *testing.T) {
 	e := discontiguousError{pi: procedureIndicator{name: NewAtom("foo"), arity: 1}}
 	assert.Equal(t, "foo/1 is discontiguous", e.Error())
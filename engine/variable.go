@@ -9,13 +9,14 @@ import (
 var varCounter int64
 
 func lastVariable() Variable {
-	return Variable(varCounter)
+	return Variable(atomic.LoadInt64(&varCounter))
 }
 
 // Variable is a prolog variable.
 type Variable int64
 
 // NewVariable creates a new anonymous variable.
+// It's safe to call NewVariable from multiple goroutines. Every call returns a distinct Variable.
 func NewVariable() Variable {
 	n := atomic.AddInt64(&varCounter, 1)
 	return Variable(n)
@@ -28,11 +29,19 @@ func (v Variable) WriteTerm(w io.Writer, opts *WriteOptions, env *Env) error {
 		return x.WriteTerm(w, opts, env)
 	}
 
+	ew := errWriter{w: w}
+	if letterDigit(opts.left.name) { // So that it won't be is_1.
+		_, _ = ew.Write([]byte(" "))
+	}
 	if a, ok := opts.variableNames[v]; ok {
-		return a.WriteTerm(w, opts.withQuoted(false).withLeft(operator{}).withRight(operator{}), env)
+		_ = a.WriteTerm(&ew, opts.withQuoted(false).withLeft(operator{}).withRight(operator{}), env)
+	} else {
+		_, _ = fmt.Fprintf(&ew, "_%d", v)
+	}
+	if letterDigit(opts.right.name) { // So that it won't be _1is.
+		_, _ = ew.Write([]byte(" "))
 	}
-	_, err := w.Write([]byte(fmt.Sprintf("_%d", v)))
-	return err
+	return ew.err
 }
 
 func (v Variable) Compare(t Term, env *Env) int {
@@ -44,14 +53,14 @@ func (v Variable) Compare(t Term, env *Env) int {
 
 	switch t := env.Resolve(t).(type) {
 	case Variable:
-		switch {
-		case v > t:
-			return 1
-		case v < t:
-			return -1
-		default:
+		// The same variable, possibly through a chain of bindings, is equal regardless of the order of variables.
+		if v == t {
 			return 0
 		}
+		if v > t {
+			return 1
+		}
+		return -1
 	default:
 		return -1
 	}
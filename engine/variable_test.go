@@ -4,11 +4,49 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+func TestNewVariable(t *testing.T) {
+	const (
+		goroutines = 8
+		n          = 1000
+	)
+
+	var (
+		wg      sync.WaitGroup
+		results [goroutines][]Variable
+	)
+	for i := range results {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vs := make([]Variable, n)
+			for j := range vs {
+				vs[j] = NewVariable()
+			}
+			results[i] = vs
+		}()
+	}
+	wg.Wait()
+
+	seen := map[Variable]struct{}{}
+	for _, vs := range results {
+		for j, v := range vs {
+			if j > 0 {
+				assert.Greater(t, v, vs[j-1])
+			}
+			seen[v] = struct{}{}
+		}
+	}
+	assert.Len(t, seen, goroutines*n)
+	assert.GreaterOrEqual(t, lastVariable(), Variable(goroutines*n))
+}
+
 func TestVariable_WriteTerm(t *testing.T) {
 	x := NewVariable()
 
@@ -21,6 +59,10 @@ func TestVariable_WriteTerm(t *testing.T) {
 	}{
 		{title: "unnamed", v: x, output: fmt.Sprintf("_%d", x)},
 		{title: "variable_names", v: x, opts: WriteOptions{variableNames: map[Variable]Atom{x: NewAtom("Foo")}}, output: `Foo`},
+		{title: "following an alphanumeric operator", v: x, opts: WriteOptions{left: operator{name: NewAtom("is")}}, output: fmt.Sprintf(" _%d", x)},
+		{title: "followed by an alphanumeric operator", v: x, opts: WriteOptions{right: operator{name: NewAtom("mod")}}, output: fmt.Sprintf("_%d ", x)},
+		{title: "followed by a symbolic operator", v: x, opts: WriteOptions{right: operator{name: atomPlus}}, output: fmt.Sprintf("_%d", x)},
+		{title: "variable_names between alphanumeric operators", v: x, opts: WriteOptions{variableNames: map[Variable]Atom{x: NewAtom("Foo")}, left: operator{name: NewAtom("is")}, right: operator{name: NewAtom("mod")}}, output: ` Foo `},
 	}
 
 	var buf bytes.Buffer
@@ -2,9 +2,11 @@ package engine
 
 import (
 	"context"
+	_ "embed" // for go:embed
 	"fmt"
 	"io"
 	"io/fs"
+	"os"
 	"strings"
 )
 
@@ -13,6 +15,9 @@ type bytecode []instruction
 type instruction struct {
 	opcode  opcode
 	operand Term
+
+	// branches are the bytecodes of the subgoals of a control construct, e.g. if-then-else.
+	branches []bytecode
 }
 
 type opcode byte
@@ -34,6 +39,8 @@ const (
 	opPutList
 	opGetPartial
 	opPutPartial
+	opOr
+	opIfThenElse
 )
 
 // Success is a continuation that leads to true.
@@ -47,11 +54,33 @@ func Failure(*Env) *Promise {
 }
 
 // VM is the core of a Prolog interpreter. The zero value for VM is a valid VM without any builtin predicates.
+//
+// A VM can serve multiple queries concurrently as long as none of them modifies the VM itself, e.g. the database,
+// operators, flags, or streams. Each query carries its own *Env, so bindings made in one query never leak into another.
 type VM struct {
 	// Unknown is a callback that is triggered when the VM reaches to an unknown predicate while current_prolog_flag(unknown, warning).
 	Unknown func(name Atom, args []Term, env *Env)
 
+	// Panic is a callback that is triggered when catch/3 comes across an error caused by a Go panic in a predicate.
+	// catch/3 doesn't catch such an error but lets it propagate. The callback is triggered at most once per panic.
+	Panic func(r interface{})
+
+	// MessageHook is a callback that is triggered when print_message/2 prints message of kind.
+	// If it returns true, the returned lines are printed instead of the default rendering of message. An atom is
+	// printed as is and format(Format, Args) is formatted as format/2 does. Returning true with no lines suppresses
+	// the message.
+	MessageHook func(kind string, message Term) ([]Term, bool)
+
+	// WarnSingletons makes consulting a clause which has named variables that appear only once print a warning
+	// singletons(Names) by print_message/2. It's off by default.
+	WarnSingletons bool
+
+	// BeforeHalt is a list of callbacks that are triggered with the exit code right before halt/1 exits the process.
+	// They're called in the reverse order so that the cleanup registered last runs first.
+	BeforeHalt []func(code int)
+
 	procedures map[procedureIndicator]procedure
+	constants  map[Atom]func() Number
 	unknown    unknownAction
 
 	// FS is a file system that is referenced when the VM loads Prolog texts e.g. ensure_loaded/1.
@@ -59,6 +88,10 @@ type VM struct {
 	FS     fs.FS
 	loaded map[string]struct{}
 
+	// compiled is the compilation cache keyed by the source of the text so that reloading an unchanged clause skips
+	// compilation. Each source keeps the clauses of its latest load only.
+	compiled map[string]compileCache
+
 	// Internal/external expression
 	operators       operators
 	charConversions map[rune]rune
@@ -69,10 +102,48 @@ type VM struct {
 	streams       streams
 	input, output *Stream
 
+	// wrapIntOverflow makes integer arithmetic wrap around instead of raising evaluation_error(int_overflow).
+	wrapIntOverflow bool
+
+	// MaxArity is the maximum arity of compound terms constructed by functor/3. If zero, it's unbounded.
+	// NewVM sets it to DefaultMaxArity.
+	MaxArity int
+
 	// Misc
 	debug bool
 }
 
+// DefaultMaxArity is the default of VM.MaxArity, which is also reported by current_prolog_flag(max_arity, _).
+// It's large enough for compound terms used as arrays while functor/3 can't exhaust the memory with an absurd arity.
+const DefaultMaxArity = 1 << 20
+
+// NewVM creates a new VM with the builtin predicates and the standard operators. The flags are set to the ISO
+// defaults, e.g. unknown is error and double_quotes is codes, and user_input, user_output, and user_error are bound to
+// the standard streams of the process. It also loads the bootstrap, so that the predicates defined in Prolog, e.g.
+// true/0, once/1, and member/2, are available. FS is left nil so set it before consulting files.
+func NewVM() *VM {
+	var vm VM
+	vm.SetUserInput(NewInputTextStream(os.Stdin))
+	vm.SetUserOutput(NewOutputTextStream(os.Stdout))
+	vm.SetUserError(NewOutputTextStream(os.Stderr))
+	vm.InstallDefaultOperators()
+	vm.RegisterBuiltins()
+	_ = vm.LoadBootstrap(context.Background())
+	vm.unknown = unknownError
+	vm.doubleQuotes = doubleQuotesCodes
+	vm.MaxArity = DefaultMaxArity
+	return &vm
+}
+
+//go:embed bootstrap.pl
+var bootstrap string
+
+// LoadBootstrap defines the predicates written in Prolog, e.g. true/0, once/1, and member/2, on top of the builtin
+// predicates and the default operators.
+func (vm *VM) LoadBootstrap(ctx context.Context) error {
+	return vm.Compile(ctx, bootstrap)
+}
+
 // Register0 registers a predicate of arity 0.
 func (vm *VM) Register0(name Atom, p Predicate0) {
 	if vm.procedures == nil {
@@ -145,6 +216,15 @@ func (vm *VM) Register8(name Atom, p Predicate8) {
 	vm.procedures[procedureIndicator{name: name, arity: 8}] = p
 }
 
+// RegisterConstant registers an evaluable atom, e.g. `X is name`, which evaluates to the result of c.
+// It takes precedence over the builtin constants such as pi and e.
+func (vm *VM) RegisterConstant(name Atom, c func() Number) {
+	if vm.constants == nil {
+		vm.constants = map[Atom]func() Number{}
+	}
+	vm.constants[name] = c
+}
+
 type unknownAction int
 
 const (
@@ -165,23 +245,21 @@ type procedure interface {
 	call(*VM, []Term, Cont, *Env) *Promise
 }
 
-// Cont is a continuation.
+// Cont is a continuation. A predicate calls it with the bindings of a solution to proceed to the rest of the query.
 type Cont func(*Env) *Promise
 
 // Arrive is the entry point of the VM.
 func (vm *VM) Arrive(name Atom, args []Term, k Cont, env *Env) (promise *Promise) {
 	defer ensurePromise(&promise)
 
-	if vm.Unknown == nil {
-		vm.Unknown = func(Atom, []Term, *Env) {}
-	}
-
 	pi := procedureIndicator{name: name, arity: Integer(len(args))}
 	p, ok := vm.procedures[pi]
 	if !ok {
 		switch vm.unknown {
 		case unknownWarning:
-			vm.Unknown(name, args, env)
+			if vm.Unknown != nil {
+				vm.Unknown(name, args, env)
+			}
 			fallthrough
 		case unknownFail:
 			return Bool(false)
@@ -244,7 +322,7 @@ func (vm *VM) exec(pc bytecode, vars []Variable, cont Cont, args []Term, astack
 		case opExit:
 			return cont(env)
 		case opCut:
-			return cut(cutParent, func(context.Context) *Promise {
+			return Cut(cutParent, func(context.Context) *Promise {
 				return vm.exec(pc, vars, cont, args, astack, env, cutParent)
 			})
 		case opGetList:
@@ -280,12 +358,245 @@ func (vm *VM) exec(pc bytecode, vars []Variable, cont Cont, args []Term, astack
 			args = append(args, arg)
 			astack = append(astack, args)
 			args = vs[:0]
+		case opOr:
+			k := func(env *Env) *Promise {
+				return vm.exec(pc, vars, cont, nil, nil, env, cutParent)
+			}
+			left, right := op.branches[0], op.branches[1]
+			return Delay(func(context.Context) *Promise {
+				return vm.exec(left, vars, k, nil, nil, env, cutParent)
+			}, func(context.Context) *Promise {
+				return vm.exec(right, vars, k, nil, nil, env, cutParent)
+			})
+		case opIfThenElse:
+			k := func(env *Env) *Promise {
+				return vm.exec(pc, vars, cont, nil, nil, env, cutParent)
+			}
+			cond, then, els := op.branches[0], op.branches[1], op.branches[2]
+
+			// A cut inside the condition is local to the condition while a cut inside the then or else part cuts
+			// through the clause. The condition has its own cut barrier so that a cut inside it doesn't remove the else
+			// part.
+			var p, c *Promise
+			c = Delay(func(context.Context) *Promise {
+				return vm.exec(cond, vars, func(env *Env) *Promise {
+					return Cut(p, func(context.Context) *Promise {
+						return vm.exec(then, vars, k, nil, nil, env, cutParent)
+					})
+				}, nil, nil, env, c)
+			})
+			ks := []func(context.Context) *Promise{
+				func(context.Context) *Promise {
+					return c
+				},
+			}
+			if els != nil {
+				ks = append(ks, func(context.Context) *Promise {
+					return vm.exec(els, vars, k, nil, nil, env, cutParent)
+				})
+			}
+			p = Delay(ks...)
+			return p
 		}
 	}
 
 	return Bool(false)
 }
 
+// RegisterBuiltins registers the builtin predicates implemented in Go.
+func (vm *VM) RegisterBuiltins() {
+	// Control constructs
+	vm.Register1(NewAtom("call"), Call)
+	vm.Register3(NewAtom("catch"), Catch)
+	vm.Register1(NewAtom("throw"), Throw)
+
+	// Term unification
+	vm.Register2(NewAtom("="), Unify)
+	vm.Register2(NewAtom("unify_with_occurs_check"), UnifyWithOccursCheck)
+	vm.Register2(NewAtom("subsumes_term"), SubsumesTerm)
+
+	// Type testing
+	vm.Register1(NewAtom("var"), TypeVar)
+	vm.Register1(NewAtom("atom"), TypeAtom)
+	vm.Register1(NewAtom("integer"), TypeInteger)
+	vm.Register1(NewAtom("float"), TypeFloat)
+	vm.Register1(NewAtom("compound"), TypeCompound)
+	vm.Register1(NewAtom("acyclic_term"), AcyclicTerm)
+
+	// Term comparison
+	vm.Register3(NewAtom("compare"), Compare)
+	vm.Register2(NewAtom("sort"), Sort)
+	vm.Register2(NewAtom("msort"), MSort)
+	vm.Register4(NewAtom("sort"), Sort4)
+	vm.Register2(NewAtom("keysort"), KeySort)
+
+	// Term creation and decomposition
+	vm.Register3(NewAtom("functor"), Functor)
+	vm.Register3(NewAtom("arg"), Arg)
+	vm.Register2(NewAtom("=.."), Univ)
+	vm.Register2(NewAtom("copy_term"), CopyTerm)
+	vm.Register2(NewAtom("unnumbervars"), UnnumberVars)
+	vm.Register2(NewAtom("term_variables"), TermVariables)
+
+	// Arithmetic evaluation
+	vm.Register2(NewAtom("is"), Is)
+
+	// Arithmetic comparison
+	vm.Register2(NewAtom("=:="), Equal)
+	vm.Register2(NewAtom("=\\="), NotEqual)
+	vm.Register2(NewAtom("<"), LessThan)
+	vm.Register2(NewAtom("=<"), LessThanOrEqual)
+	vm.Register2(NewAtom(">"), GreaterThan)
+	vm.Register2(NewAtom(">="), GreaterThanOrEqual)
+
+	// Clause retrieval and information
+	vm.Register2(NewAtom("clause"), Clause)
+	vm.Register1(NewAtom("current_predicate"), CurrentPredicate)
+
+	// Clause creation and destruction
+	vm.Register1(NewAtom("asserta"), Asserta)
+	vm.Register1(NewAtom("assertz"), Assertz)
+	vm.Register1(NewAtom("retract"), Retract)
+	vm.Register1(NewAtom("abolish"), Abolish)
+
+	// All solutions
+	vm.Register3(NewAtom("findall"), FindAll)
+	vm.Register4(NewAtom("findall"), FindAll4)
+	vm.Register3(NewAtom("aggregate_all"), AggregateAll)
+	vm.Register3(NewAtom("bagof"), BagOf)
+	vm.Register3(NewAtom("setof"), SetOf)
+
+	// Stream selection and control
+	vm.Register1(NewAtom("current_input"), CurrentInput)
+	vm.Register1(NewAtom("current_output"), CurrentOutput)
+	vm.Register1(NewAtom("set_input"), SetInput)
+	vm.Register1(NewAtom("set_output"), SetOutput)
+	vm.Register4(NewAtom("open"), Open)
+	vm.Register2(NewAtom("close"), Close)
+	vm.Register1(NewAtom("flush_output"), FlushOutput)
+	vm.Register2(NewAtom("stream_property"), StreamProperty)
+	vm.Register2(NewAtom("set_stream_position"), SetStreamPosition)
+
+	// Character input/output
+	vm.Register2(NewAtom("get_char"), GetChar)
+	vm.Register2(NewAtom("peek_char"), PeekChar)
+	vm.Register2(NewAtom("put_char"), PutChar)
+	vm.Register2(NewAtom("tab"), Tab)
+
+	// Byte input/output
+	vm.Register2(NewAtom("get_byte"), GetByte)
+	vm.Register2(NewAtom("peek_byte"), PeekByte)
+	vm.Register2(NewAtom("put_byte"), PutByte)
+
+	// Term input/output
+	vm.Register3(NewAtom("read_term"), ReadTerm)
+	vm.Register3(NewAtom("read_term_from_atom"), ReadTermFromAtom)
+	vm.Register3(NewAtom("write_term"), WriteTerm)
+	vm.Register3(NewAtom("op"), Op)
+	vm.Register3(NewAtom("current_op"), CurrentOp)
+	vm.Register2(NewAtom("char_conversion"), CharConversion)
+	vm.Register2(NewAtom("current_char_conversion"), CurrentCharConversion)
+	vm.Register2(NewAtom("print_message"), PrintMessage)
+	vm.Register3(atomFormat, Format)
+
+	// Logic and control
+	vm.Register1(NewAtom(`\+`), Negate)
+	vm.Register2(NewAtom("forall"), Forall)
+	vm.Register0(NewAtom("repeat"), Repeat)
+	vm.Register1(NewAtom("repeat"), RepeatN)
+	vm.Register2(NewAtom("call"), Call1)
+	vm.Register3(NewAtom("call"), Call2)
+	vm.Register4(NewAtom("call"), Call3)
+	vm.Register5(NewAtom("call"), Call4)
+	vm.Register6(NewAtom("call"), Call5)
+	vm.Register7(NewAtom("call"), Call6)
+	vm.Register8(NewAtom("call"), Call7)
+
+	// Atomic term processing
+	vm.Register2(NewAtom("atom_length"), AtomLength)
+	vm.Register3(NewAtom("atom_concat"), AtomConcat)
+	vm.Register5(NewAtom("sub_atom"), SubAtom)
+	vm.Register2(NewAtom("atom_chars"), AtomChars)
+	vm.Register2(NewAtom("atom_codes"), AtomCodes)
+	vm.Register2(NewAtom("char_code"), CharCode)
+	vm.Register2(NewAtom("char_type"), CharType)
+	vm.Register2(NewAtom("code_type"), CodeType)
+	vm.Register2(NewAtom("number_chars"), NumberChars)
+	vm.Register2(NewAtom("number_codes"), NumberCodes)
+	vm.Register2(NewAtom("write_to_codes"), WriteToCodes)
+	vm.Register2(NewAtom("term_string"), TermString)
+	vm.Register2(NewAtom("term_to_atom"), TermToAtom)
+	vm.Register3(NewAtom("atom_to_term"), AtomToTerm)
+
+	// Implementation defined hooks
+	vm.Register2(NewAtom("set_prolog_flag"), SetPrologFlag)
+	vm.Register2(NewAtom("current_prolog_flag"), CurrentPrologFlag)
+	vm.Register1(NewAtom("current_flag_values"), CurrentFlagValues)
+	vm.Register1(NewAtom("halt"), Halt)
+
+	// Consult
+	vm.Register1(NewAtom("consult"), Consult)
+	vm.Register2(NewAtom("consult"), Consult2)
+
+	// Definite clause grammar
+	vm.Register3(NewAtom("phrase"), Phrase)
+	vm.Register2(NewAtom("expand_term"), ExpandTerm)
+
+	// Prolog prologue
+	vm.Register3(NewAtom("append"), Append)
+	vm.Register2(NewAtom("length"), Length)
+	vm.Register3(NewAtom("between"), Between)
+	vm.Register2(NewAtom("succ"), Succ)
+	vm.Register3(NewAtom("plus"), Plus)
+	vm.Register3(NewAtom("nth0"), Nth0)
+	vm.Register3(NewAtom("nth1"), Nth1)
+	vm.Register2(NewAtom("call_nth"), CallNth)
+	vm.Register2(NewAtom("call_with_time_limit"), CallWithTimeLimit)
+	vm.Register3(NewAtom("call_with_inference_limit"), CallWithInferenceLimit)
+	vm.Register2(NewAtom("with_output_to"), WithOutputTo)
+
+	// Association lists
+	vm.Register1(NewAtom("empty_assoc"), EmptyAssoc)
+	vm.Register3(NewAtom("get_assoc"), GetAssoc)
+	vm.Register4(NewAtom("put_assoc"), PutAssoc)
+	vm.Register4(NewAtom("del_assoc"), DelAssoc)
+	vm.Register2(NewAtom("list_to_assoc"), ListToAssoc)
+	vm.Register2(NewAtom("assoc_to_list"), AssocToList)
+	vm.Register2(NewAtom("assoc_to_keys"), AssocToKeys)
+	vm.Register2(NewAtom("assoc_to_values"), AssocToValues)
+}
+
+// InstallDefaultOperators defines the standard operators so that the parser and the writer can handle them.
+func (vm *VM) InstallDefaultOperators() {
+	for _, o := range []struct {
+		priority  Integer
+		specifier operatorSpecifier
+		names     []string
+	}{
+		{priority: 1200, specifier: operatorSpecifierXFX, names: []string{":-", "-->"}},
+		{priority: 1200, specifier: operatorSpecifierFX, names: []string{":-", "?-"}},
+		{priority: 1105, specifier: operatorSpecifierXFY, names: []string{"|"}},
+		{priority: 1100, specifier: operatorSpecifierXFY, names: []string{";"}},
+		{priority: 1050, specifier: operatorSpecifierXFY, names: []string{"->"}},
+		{priority: 1000, specifier: operatorSpecifierXFY, names: []string{","}},
+		{priority: 900, specifier: operatorSpecifierFY, names: []string{`\+`}},
+		{priority: 700, specifier: operatorSpecifierXFX, names: []string{"=", `\=`}},
+		{priority: 700, specifier: operatorSpecifierXFX, names: []string{"==", `\==`, "@<", "@=<", "@>", "@>="}},
+		{priority: 700, specifier: operatorSpecifierXFX, names: []string{"=.."}},
+		{priority: 700, specifier: operatorSpecifierXFX, names: []string{"is", "=:=", `=\=`, "<", "=<", ">", ">="}},
+		{priority: 600, specifier: operatorSpecifierXFY, names: []string{":"}},
+		{priority: 500, specifier: operatorSpecifierYFX, names: []string{"+", "-", `/\`, `\/`}},
+		{priority: 400, specifier: operatorSpecifierYFX, names: []string{"*", "/", "//", "div", "rem", "mod", "<<", ">>"}},
+		{priority: 200, specifier: operatorSpecifierXFX, names: []string{"**"}},
+		{priority: 200, specifier: operatorSpecifierXFY, names: []string{"^"}},
+		{priority: 200, specifier: operatorSpecifierFY, names: []string{"+", "-", `\`}},
+	} {
+		for _, n := range o.names {
+			vm.operators.define(o.priority, o.specifier, NewAtom(n))
+		}
+	}
+}
+
 // SetUserInput sets the given stream as user_input.
 func (vm *VM) SetUserInput(s *Stream) {
 	s.vm = vm
@@ -302,6 +613,100 @@ func (vm *VM) SetUserOutput(s *Stream) {
 	vm.output = s
 }
 
+// SetUserError sets the given stream as user_error.
+func (vm *VM) SetUserError(s *Stream) {
+	s.vm = vm
+	s.alias = atomUserError
+	vm.streams.add(s)
+}
+
+// AssertzAll appends clauses to the database in one go as if assertz/1 is called for each of them in order.
+// All the clauses are validated before any modification to the database. If it finds an invalid clause, it reports
+// the index of the first invalid clause and leaves the database intact.
+func (vm *VM) AssertzAll(clauses []Term) error {
+	var (
+		pis   []procedureIndicator
+		added = map[procedureIndicator][]clause{}
+	)
+	for i, t := range clauses {
+		t = unqualify(t, nil)
+		pi, arg, err := piArg(t, nil)
+		if err != nil {
+			return fmt.Errorf("clause %d: %w", i, err)
+		}
+
+		if pi == (procedureIndicator{name: atomIf, arity: 2}) {
+			pi, _, err = piArg(arg(0), nil)
+			if err != nil {
+				return fmt.Errorf("clause %d: %w", i, err)
+			}
+		}
+
+		if p, ok := vm.procedures[pi]; ok {
+			if u, ok := p.(*userDefined); !ok || !u.dynamic {
+				return fmt.Errorf("clause %d: %w", i, permissionError(operationModify, permissionTypeStaticProcedure, pi.Term(), nil))
+			}
+		}
+
+		cs, err := compile(t, nil)
+		if err != nil {
+			return fmt.Errorf("clause %d: %w", i, err)
+		}
+
+		if _, ok := added[pi]; !ok {
+			pis = append(pis, pi)
+		}
+		added[pi] = append(added[pi], cs...)
+	}
+
+	if vm.procedures == nil {
+		vm.procedures = map[procedureIndicator]procedure{}
+	}
+	for _, pi := range pis {
+		u, ok := vm.procedures[pi].(*userDefined)
+		if !ok {
+			u = &userDefined{dynamic: true}
+			vm.procedures[pi] = u
+		}
+		u.append(added[pi])
+	}
+	return nil
+}
+
+// Snapshot is a checkpoint of the dynamic procedures in the database taken by VM.Snapshot.
+type Snapshot struct {
+	procedures map[procedureIndicator]userDefined
+}
+
+// Snapshot captures the clauses of the dynamic procedures so that VM.Restore can roll back the modifications to them,
+// e.g. asserta/1, assertz/1, retract/1, and abolish/1. Static and builtin procedures aren't captured.
+func (vm *VM) Snapshot() Snapshot {
+	s := Snapshot{procedures: map[procedureIndicator]userDefined{}}
+	for pi, p := range vm.procedures {
+		if u, ok := p.(*userDefined); ok && u.dynamic {
+			s.procedures[pi] = u.clone()
+		}
+	}
+	return s
+}
+
+// Restore brings the dynamic procedures back to the state captured by s. Dynamic procedures created after s are
+// removed. A snapshot can be restored as many times as needed. Restore must not be called while a query is running.
+func (vm *VM) Restore(s Snapshot) {
+	for pi, p := range vm.procedures {
+		if u, ok := p.(*userDefined); ok && u.dynamic {
+			delete(vm.procedures, pi)
+		}
+	}
+	if vm.procedures == nil {
+		vm.procedures = make(map[procedureIndicator]procedure, len(s.procedures))
+	}
+	for pi, u := range s.procedures {
+		u := u.clone()
+		vm.procedures[pi] = &u
+	}
+}
+
 // Predicate0 is a predicate of arity 0.
 type Predicate0 func(*VM, Cont, *Env) *Promise
 
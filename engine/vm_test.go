@@ -2,12 +2,54 @@ package engine
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+func TestNewVM(t *testing.T) {
+	vm := NewVM()
+	assert.Equal(t, unknownError, vm.unknown)
+	assert.Equal(t, doubleQuotesCodes, vm.doubleQuotes)
+	assert.Equal(t, DefaultMaxArity, vm.MaxArity)
+
+	tests := []struct {
+		title string
+		query string
+		ok    bool
+	}{
+		{title: "arithmetic", query: `X is 1+2, X =:= 3.`, ok: true},
+		{title: "double quotes", query: `"a" = [C], C == 0'a.`, ok: true},
+		{title: "true", query: `true.`, ok: true},
+		{title: "fail", query: `fail.`, ok: false},
+		{title: "negation", query: `\+ fail.`, ok: true},
+		{title: "once", query: `once(member(X, [1, 2])), X == 1.`, ok: true},
+		{title: "if-then-else", query: `(fail -> X = a ; X = b), X == b.`, ok: true},
+		{title: "disjunction", query: `(fail ; true).`, ok: true},
+		{title: "not unifiable", query: `a \= b.`, ok: true},
+		{title: "identical", query: `f(X) == f(X), f(X) \== f(_).`, ok: true},
+		{title: "findall", query: `findall(X, member(X, [a, b]), [a, b]).`, ok: true},
+		{title: "cut", query: `member(X, [1, 2]), !, X == 1.`, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			p := NewParser(vm, strings.NewReader(tt.query))
+			goal, err := p.Term()
+			assert.NoError(t, err)
+
+			ok, err := Call(vm, goal, Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
+
 func TestVM_Register0(t *testing.T) {
 	var vm VM
 	vm.Register0(NewAtom("foo"), func(_ *VM, k Cont, env *Env) *Promise {
@@ -190,6 +232,37 @@ func TestVM_Register8(t *testing.T) {
 	})
 }
 
+func TestVM_RegisterConstant(t *testing.T) {
+	var vm VM
+	vm.RegisterConstant(NewAtom("answer"), func() Number { return Integer(42) })
+	vm.RegisterConstant(atomPi, func() Number { return Integer(3) })
+
+	tests := []struct {
+		title      string
+		vm         *VM
+		expression Term
+		result     Term
+		err        error
+	}{
+		{title: "registered", vm: &vm, expression: atomPlus.Apply(NewAtom("answer"), Integer(1)), result: Integer(43)},
+		{title: "overridden", vm: &vm, expression: atomPi, result: Integer(3)},
+		{title: "builtin", vm: &vm, expression: atomSmallE, result: Float(math.E)},
+		{title: "another VM", vm: &VM{}, expression: NewAtom("answer"), err: typeError(validTypeEvaluable, atomSlash.Apply(NewAtom("answer"), Integer(0)), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			result := NewVariable()
+			ok, err := Is(tt.vm, result, tt.expression, func(env *Env) *Promise {
+				assert.Equal(t, tt.result, env.Resolve(result))
+				return Bool(true)
+			}, nil).Force(context.Background())
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.err == nil, ok)
+		})
+	}
+}
+
 func TestVM_Arrive(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		vm := VM{
@@ -243,6 +316,29 @@ func TestVM_Arrive(t *testing.T) {
 			assert.False(t, ok)
 		})
 	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		var vm VM
+		vm.Register1(NewAtom("foo"), func(_ *VM, t Term, k Cont, env *Env) *Promise {
+			return Unify(nil, t, NewAtom("a"), k, env)
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				v := NewVariable()
+				ok, err := vm.Arrive(NewAtom("foo"), []Term{v}, func(env *Env) *Promise {
+					assert.Equal(t, NewAtom("a"), env.Resolve(v))
+					return Bool(true)
+				}, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.True(t, ok)
+			}()
+		}
+		wg.Wait()
+	})
 }
 
 func TestVM_SetUserInput(t *testing.T) {
@@ -267,6 +363,181 @@ func TestVM_SetUserOutput(t *testing.T) {
 	})
 }
 
+func TestVM_InstallDefaultOperators(t *testing.T) {
+	var vm VM
+	vm.InstallDefaultOperators()
+
+	p := NewParser(&vm, strings.NewReader(`X is 1+2*3, \+ a:-b.`))
+	term, err := p.Term()
+	assert.NoError(t, err)
+
+	x := p.Vars[0].Variable
+	expected := atomIf.Apply(
+		atomComma.Apply(
+			NewAtom("is").Apply(x, atomPlus.Apply(Integer(1), NewAtom("*").Apply(Integer(2), Integer(3)))),
+			NewAtom(`\+`).Apply(NewAtom("a")),
+		),
+		NewAtom("b"),
+	)
+	assert.Equal(t, expected, term)
+
+	var sb strings.Builder
+	assert.NoError(t, NewAtom("is").Apply(NewAtom("x"), atomMinus.Apply(atomMinus.Apply(Integer(1), Integer(2)), atomMinus.Apply(Integer(3), Integer(4)))).WriteTerm(&sb, &WriteOptions{ops: vm.operators, priority: 1200}, nil))
+	assert.Equal(t, `x is 1-2-(3-4)`, sb.String())
+}
+
+func TestVM_AssertzAll(t *testing.T) {
+	foo, bar := NewAtom("foo"), NewAtom("bar")
+
+	t.Run("ok", func(t *testing.T) {
+		var vm VM
+		assert.NoError(t, vm.AssertzAll([]Term{
+			foo.Apply(Integer(1)),
+			bar,
+			atomIf.Apply(foo.Apply(Integer(2)), atomTrue),
+		}))
+		assert.NoError(t, vm.AssertzAll([]Term{
+			foo.Apply(Integer(3)),
+		}))
+
+		cs := vm.procedures[procedureIndicator{name: foo, arity: 1}].(*userDefined).clauses
+		assert.Len(t, cs, 3)
+		assert.Equal(t, foo.Apply(Integer(1)), cs[0].raw)
+		assert.Equal(t, atomIf.Apply(foo.Apply(Integer(2)), atomTrue), cs[1].raw)
+		assert.Equal(t, foo.Apply(Integer(3)), cs[2].raw)
+		assert.Len(t, vm.procedures[procedureIndicator{name: bar, arity: 0}].(*userDefined).clauses, 1)
+	})
+
+	t.Run("invalid clause", func(t *testing.T) {
+		var vm VM
+		err := vm.AssertzAll([]Term{
+			foo.Apply(Integer(1)),
+			Integer(0),
+		})
+		assert.Equal(t, fmt.Errorf("clause 1: %w", typeError(validTypeCallable, Integer(0), nil)), err)
+		assert.Empty(t, vm.procedures)
+	})
+
+	t.Run("static procedure", func(t *testing.T) {
+		vm := VM{
+			procedures: map[procedureIndicator]procedure{
+				{name: bar, arity: 0}: &userDefined{},
+			},
+		}
+		err := vm.AssertzAll([]Term{
+			foo.Apply(Integer(1)),
+			bar,
+		})
+		assert.Equal(t, fmt.Errorf("clause 1: %w", permissionError(operationModify, permissionTypeStaticProcedure, procedureIndicator{name: bar, arity: 0}.Term(), nil)), err)
+		_, ok := vm.procedures[procedureIndicator{name: foo, arity: 1}]
+		assert.False(t, ok)
+	})
+}
+
+func TestVM_Snapshot(t *testing.T) {
+	foo, bar, baz := NewAtom("foo"), NewAtom("bar"), NewAtom("baz")
+	fooPI := procedureIndicator{name: foo, arity: 1}
+
+	var vm VM
+	vm.procedures = map[procedureIndicator]procedure{
+		{name: bar, arity: 0}: &userDefined{},
+	}
+	var want []Term
+	for i := 1; i <= 10; i++ {
+		want = append(want, foo.Apply(Integer(i)))
+	}
+	assert.NoError(t, vm.AssertzAll(want))
+	s := vm.Snapshot()
+
+	raws := func() []Term {
+		var ret []Term
+		for _, c := range vm.procedures[fooPI].(*userDefined).clauses {
+			ret = append(ret, c.raw)
+		}
+		return ret
+	}
+
+	modify := func(t *testing.T) {
+		_, err := Asserta(&vm, foo.Apply(Integer(0)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		_, err = Assertz(&vm, foo.Apply(Integer(11)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		_, err = Retract(&vm, foo.Apply(Integer(5)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		_, err = Assertz(&vm, baz, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, raws(), 11)
+	}
+
+	t.Run("undo modifications", func(t *testing.T) {
+		modify(t)
+		vm.Restore(s)
+		assert.Equal(t, want, raws())
+		_, ok := vm.procedures[procedureIndicator{name: baz, arity: 0}]
+		assert.False(t, ok)
+		_, ok = vm.procedures[procedureIndicator{name: bar, arity: 0}]
+		assert.True(t, ok)
+
+		ok, err := Call(&vm, foo.Apply(Integer(5)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		ok, err = Call(&vm, foo.Apply(Integer(11)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("undo abolish", func(t *testing.T) {
+		_, err := Abolish(&vm, atomSlash.Apply(foo, Integer(1)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		_, ok := vm.procedures[fooPI]
+		assert.False(t, ok)
+		vm.Restore(s)
+		assert.Equal(t, want, raws())
+	})
+
+	t.Run("restore again", func(t *testing.T) {
+		modify(t)
+		vm.Restore(s)
+		assert.Equal(t, want, raws())
+		assert.Len(t, s.procedures[fooPI].clauses, 10)
+	})
+
+	t.Run("static procedures are intact", func(t *testing.T) {
+		vm.procedures[procedureIndicator{name: baz, arity: 0}] = &userDefined{}
+		vm.Restore(s)
+		_, ok := vm.procedures[procedureIndicator{name: baz, arity: 0}]
+		assert.True(t, ok)
+	})
+}
+
+func BenchmarkVM_AssertzAll(b *testing.B) {
+	foo := NewAtom("foo")
+	clauses := make([]Term, 100000)
+	for i := range clauses {
+		clauses[i] = foo.Apply(Integer(i))
+	}
+
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var vm VM
+			if err := vm.AssertzAll(clauses); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var vm VM
+			for _, c := range clauses {
+				if _, err := Assertz(&vm, c, Success, nil).Force(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
 func TestProcedureIndicator_Apply(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		c, err := procedureIndicator{name: NewAtom("foo"), arity: 2}.Apply(NewAtom("a"), NewAtom("b"))
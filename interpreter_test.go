@@ -10,6 +10,8 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -209,6 +211,212 @@ func TestNew(t *testing.T) {
 		assert.NoError(t, p.QuerySolution(`\+call_nth(1, 0).`).Err())
 		assert.NoError(t, p.QuerySolution(`\+call_nth(V, 0).`).Err())
 	})
+
+	t.Run("control constructs in clause body", func(t *testing.T) {
+		p := New(nil, nil)
+		assert.NoError(t, p.Exec(`
+r(X) :- X = 1.
+s(0).
+p(X) :- (X > 0 -> r(X) ; s(X)).
+
+q(X, Y) :- (X > 0 -> !, Y = a ; Y = b).
+q(_, c).
+
+f(Y) :- (member(X, [1, 2, 3]), X > 1 -> Y = X ; Y = none).
+
+g(X) :- (X = 1 ; X = 2), true.
+
+h :- ((!, fail) -> true ; true).
+`))
+
+		assert.NoError(t, p.QuerySolution(`p(1).`).Err())
+		assert.NoError(t, p.QuerySolution(`p(0).`).Err())
+		assert.Equal(t, ErrNoSolutions, p.QuerySolution(`p(2).`).Err())
+		assert.Equal(t, ErrNoSolutions, p.QuerySolution(`p(-1).`).Err())
+
+		// A cut in the then part cuts through the clause.
+		assert.NoError(t, p.QuerySolution(`findall(Y, q(1, Y), [a]).`).Err())
+		assert.NoError(t, p.QuerySolution(`findall(Y, q(0, Y), [b, c]).`).Err())
+
+		// The condition is committed to the first solution.
+		assert.NoError(t, p.QuerySolution(`findall(Y, f(Y), [2]).`).Err())
+
+		assert.NoError(t, p.QuerySolution(`findall(X, g(X), [1, 2]).`).Err())
+
+		// A cut in the condition is local to the condition.
+		assert.NoError(t, p.QuerySolution(`((!, fail) -> true ; true).`).Err())
+		assert.NoError(t, p.QuerySolution(`h.`).Err())
+	})
+
+	t.Run("forward reference", func(t *testing.T) {
+		p := New(nil, nil)
+		assert.NoError(t, p.QuerySolution(`assertz((p(X) :- q(X))).`).Err())
+
+		var ex engine.Exception
+		assert.True(t, errors.As(p.QuerySolution(`p(1).`).Err(), &ex))
+		assert.Regexp(t, `^error\(existence_error\(procedure,q/1\),`, ex.Error())
+
+		assert.NoError(t, p.QuerySolution(`set_prolog_flag(unknown, fail).`).Err())
+		assert.Equal(t, ErrNoSolutions, p.QuerySolution(`p(1).`).Err())
+
+		assert.NoError(t, p.QuerySolution(`assertz(q(1)).`).Err())
+		assert.NoError(t, p.QuerySolution(`p(1).`).Err())
+	})
+
+	t.Run("nested findall", func(t *testing.T) {
+		p := New(nil, nil)
+		assert.NoError(t, p.QuerySolution(`findall(X-L, (member(X, [1, 2]), findall(Y, member(Y, [a, b]), L)), [1-[a, b], 2-[a, b]]).`).Err())
+
+		// Variables in the inner results are fresh for each outer solution.
+		assert.NoError(t, p.QuerySolution(`findall(L, (member(_, [1, 2]), findall(Y, member(Y, [_, _]), L)), [[A, B], [C, D]]), A \== B, A \== C, A \== D, B \== C, B \== D, C \== D.`).Err())
+
+		// Inner bindings don't leak into the outer goal.
+		assert.NoError(t, p.QuerySolution(`findall(Y, (findall(Y, member(Y, [a, b]), _), var(Y)), [_]).`).Err())
+	})
+
+	t.Run("with_output_to", func(t *testing.T) {
+		p := New(nil, nil)
+		sol := p.QuerySolution(`findall(A, with_output_to(atom(A), (member(X, [a, b, c]), write(X))), As).`)
+		assert.NoError(t, sol.Err())
+
+		var s struct {
+			As []string
+		}
+		assert.NoError(t, sol.Scan(&s))
+		assert.Equal(t, []string{"a"}, s.As)
+	})
+
+	t.Run("format", func(t *testing.T) {
+		p := New(nil, nil)
+		assert.NoError(t, p.QuerySolution(`format(atom(A), "~w~t~8|~d~n", [foo, 42]), A == 'foo     42\n'.`).Err())
+		assert.NoError(t, p.QuerySolution(`with_output_to(atom(A), format("~a-~a", [x, y])), A == 'x-y'.`).Err())
+		assert.NoError(t, p.QuerySolution(`catch(format(atom(_), "~z", []), error(syntax_error(_), _), true).`).Err())
+	})
+
+	t.Run("write_canonical", func(t *testing.T) {
+		p := New(nil, nil)
+		for _, q := range []string{
+			`X = +(1,2), with_output_to(atom(A), write_canonical(X)), A == '+(1,2)', term_to_atom(Y, A), X == Y.`,
+			`X = (+), with_output_to(atom(A), write_canonical(X)), term_to_atom(Y, A), X == Y.`,
+			`X = -(a), with_output_to(atom(A), write_canonical(X)), A == '-(a)', term_to_atom(Y, A), X == Y.`,
+		} {
+			assert.NoError(t, p.QuerySolution(q).Err(), q)
+		}
+	})
+
+	t.Run("unnumbervars", func(t *testing.T) {
+		p := New(nil, nil)
+
+		// '$VAR'(N) written by writeq/1 with numbervars(true) reads back as the same structure with fresh variables.
+		assert.NoError(t, p.QuerySolution(`T = f('$VAR'(0), g('$VAR'(1)), '$VAR'(0)), with_output_to(atom(A), writeq(T)), A == 'f(A,g(B),A)', unnumbervars(T, U), U = f(X, g(Y), Z), var(X), var(Y), X == Z, X \== Y.`).Err())
+		assert.NoError(t, p.QuerySolution(`unnumbervars(f(X, '$VAR'(0)), f(Y, Z)), X == Y, var(Z), Z \== X.`).Err())
+	})
+
+	t.Run("at_end_of_stream", func(t *testing.T) {
+		p := New(strings.NewReader("a"), nil)
+		assert.Equal(t, ErrNoSolutions, p.QuerySolution(`at_end_of_stream.`).Err())
+		assert.NoError(t, p.QuerySolution(`get_char(a), at_end_of_stream.`).Err())
+	})
+
+	t.Run("evaluable type errors", func(t *testing.T) {
+		p := New(nil, nil)
+		assert.NoError(t, p.QuerySolution(`catch(X is foo, error(type_error(evaluable, foo/0), _), true).`).Err())
+		assert.NoError(t, p.QuerySolution(`catch(X is foo(1), error(type_error(evaluable, foo/1), _), true).`).Err())
+		assert.NoError(t, p.QuerySolution(`catch(X is foo(1,2), error(type_error(evaluable, foo/2), _), true).`).Err())
+		assert.NoError(t, p.QuerySolution(`catch(X is 1 + foo(1,2,3), error(type_error(evaluable, foo/3), _), true).`).Err())
+		assert.NoError(t, p.QuerySolution(`catch(X is 1/0, error(evaluation_error(zero_divisor), _), true).`).Err())
+		assert.NoError(t, p.QuerySolution(`catch(X is 1 + a, error(type_error(evaluable, a/0), _), true).`).Err())
+	})
+
+	t.Run("max_arity", func(t *testing.T) {
+		p := New(nil, nil)
+		assert.NoError(t, p.QuerySolution(`current_prolog_flag(max_arity, N), N == ? .`, engine.DefaultMaxArity).Err())
+		assert.NoError(t, p.QuerySolution(`current_prolog_flag(max_arity, N), M is N + 1, catch(functor(_, f, M), error(representation_error(max_arity), _), true).`).Err())
+		assert.NoError(t, p.QuerySolution(`catch(functor(_, f, 99999999999), error(representation_error(max_arity), _), true).`).Err())
+	})
+
+	t.Run("arithmetic errors", func(t *testing.T) {
+		p := New(nil, nil)
+		for _, q := range []string{
+			`catch((X is _ + 1, fail), error(instantiation_error, _), true).`,
+			`catch((X is foo + 1, fail), error(type_error(evaluable, foo/0), _), true).`,
+			`catch((X is 1.0 mod 2, fail), error(type_error(integer, 1.0), _), true).`,
+			`catch((X is float_integer_part(1), fail), error(type_error(float, 1), _), true).`,
+			`catch((X is 1 // 0, fail), error(evaluation_error(zero_divisor), _), true).`,
+			`catch((X is 9223372036854775807 + 1, fail), error(evaluation_error(int_overflow), _), true).`,
+			`catch((X is 1.0e308 * 10.0, fail), error(evaluation_error(float_overflow), _), true).`,
+			`catch((X is 1.0e-308 * 1.0e-308, fail), error(evaluation_error(underflow), _), true).`,
+			`catch((X is 0 ** -1, fail), error(evaluation_error(undefined), _), true).`,
+			`catch((1 < 1 / 0, fail), error(evaluation_error(zero_divisor), _), true).`,
+			`catch((succ(9223372036854775807, _), fail), error(evaluation_error(int_overflow), _), true).`,
+		} {
+			assert.NoError(t, p.QuerySolution(q).Err(), q)
+		}
+	})
+
+	t.Run("all solutions", func(t *testing.T) {
+		var s struct {
+			K int
+			L []int
+		}
+
+		p := New(nil, nil)
+
+		assert.Equal(t, ErrNoSolutions, p.QuerySolution(`bagof(X, fail, L).`).Err())
+		assert.Equal(t, ErrNoSolutions, p.QuerySolution(`setof(X, fail, L).`).Err())
+		assert.NoError(t, p.QuerySolution(`findall(X, fail, L), L = [].`).Err())
+
+		sols, err := p.Query(`bagof(V, member(K-V, [2-3, 1-2, 2-1, 1-1]), L).`)
+		assert.NoError(t, err)
+		assert.True(t, sols.Next())
+		assert.NoError(t, sols.Scan(&s))
+		assert.Equal(t, 2, s.K)
+		assert.Equal(t, []int{3, 1}, s.L)
+		assert.True(t, sols.Next())
+		assert.NoError(t, sols.Scan(&s))
+		assert.Equal(t, 1, s.K)
+		assert.Equal(t, []int{2, 1}, s.L)
+		assert.False(t, sols.Next())
+		assert.NoError(t, sols.Err())
+
+		sols, err = p.Query(`setof(V, member(K-V, [2-3, 1-2, 2-1, 1-1]), L).`)
+		assert.NoError(t, err)
+		assert.True(t, sols.Next())
+		assert.NoError(t, sols.Scan(&s))
+		assert.Equal(t, 2, s.K)
+		assert.Equal(t, []int{1, 3}, s.L)
+		assert.True(t, sols.Next())
+		assert.NoError(t, sols.Scan(&s))
+		assert.Equal(t, 1, s.K)
+		assert.Equal(t, []int{1, 2}, s.L)
+		assert.False(t, sols.Next())
+		assert.NoError(t, sols.Err())
+	})
+}
+
+func TestNew_singletons(t *testing.T) {
+	const text = `
+foo(X, Y) :- bar(Y).
+bar(_Z).
+baz(X) :- X = a.
+`
+
+	t.Run("off", func(t *testing.T) {
+		var buf bytes.Buffer
+		p := New(nil, nil)
+		p.SetUserError(engine.NewOutputTextStream(&buf))
+		assert.NoError(t, p.Exec(text))
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("on", func(t *testing.T) {
+		var buf bytes.Buffer
+		p := New(nil, nil)
+		p.SetUserError(engine.NewOutputTextStream(&buf))
+		p.WarnSingletons = true
+		assert.NoError(t, p.Exec(text))
+		assert.Equal(t, "Warning: Singleton variables: [X]\n", buf.String())
+	})
 }
 
 func TestNew_variableNames(t *testing.T) {
@@ -839,6 +1047,98 @@ func TestInterpreter_Query_close(t *testing.T) {
 	assert.NoError(t, sols.Close())
 }
 
+func TestInterpreter_QueryContext(t *testing.T) {
+	i := New(nil, nil)
+	assert.NoError(t, i.Exec(`
+loop :- loop.
+count(N) :- M is N + 1, count(M).
+`))
+
+	t.Run("timeout", func(t *testing.T) {
+		for _, q := range []string{`loop.`, `count(0).`, `repeat, fail.`, `between(1, inf, _), fail.`} {
+			t.Run(q, func(t *testing.T) {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				assert.Equal(t, context.DeadlineExceeded, i.QuerySolutionContext(ctx, q).Err())
+			})
+		}
+	})
+
+	t.Run("cancel between solutions", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		sols, err := i.QueryContext(ctx, `repeat.`)
+		assert.NoError(t, err)
+		assert.True(t, sols.Next())
+		cancel()
+		assert.False(t, sols.Next())
+		assert.Equal(t, context.Canceled, sols.Err())
+		assert.NoError(t, sols.Close())
+	})
+
+	t.Run("inference limit", func(t *testing.T) {
+		ctx := engine.WithInferenceLimit(context.Background(), 1000)
+		assert.Equal(t, engine.NewException(engine.NewAtom("error").Apply(engine.NewAtom("resource_error").Apply(engine.NewAtom("inferences")), engine.NewAtom("root")), nil), i.QuerySolutionContext(ctx, `loop.`).Err())
+		assert.NoError(t, i.QuerySolution(`call_with_inference_limit(loop, 1000, inference_limit_exceeded).`).Err())
+		assert.NoError(t, i.QuerySolution(`call_with_inference_limit(member(X, [a, b]), 1000, !), X == a.`).Err())
+	})
+
+	t.Run("usable after cancellation", func(t *testing.T) {
+		assert.NoError(t, i.QuerySolution(`true.`).Err())
+	})
+}
+
+func TestInterpreter_Query_concurrent(t *testing.T) {
+	i := New(nil, nil)
+	assert.NoError(t, i.Exec(`
+parent(tom, bob).
+parent(tom, liz).
+parent(bob, ann).
+parent(bob, pat).
+parent(pat, jim).
+
+ancestor(X, Y) :- parent(X, Y).
+ancestor(X, Y) :- parent(X, Z), ancestor(Z, Y).
+`))
+
+	queries := []struct {
+		query string
+		want  []string
+	}{
+		{query: `ancestor(tom, X).`, want: []string{"bob", "liz", "ann", "pat", "jim"}},
+		{query: `ancestor(X, jim).`, want: []string{"pat", "tom", "bob"}},
+	}
+
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		for _, q := range queries {
+			q := q
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sols, err := i.Query(q.query)
+				if !assert.NoError(t, err) {
+					return
+				}
+				defer func() {
+					assert.NoError(t, sols.Close())
+				}()
+
+				var got []string
+				for sols.Next() {
+					var s struct {
+						X string
+					}
+					assert.NoError(t, sols.Scan(&s))
+					got = append(got, s.X)
+				}
+				assert.NoError(t, sols.Err())
+				assert.Equal(t, q.want, got)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
 func TestMisc(t *testing.T) {
 	t.Run("negation", func(t *testing.T) {
 		i := New(nil, nil)
@@ -848,6 +1148,18 @@ func TestMisc(t *testing.T) {
 		assert.False(t, sols.Next())
 	})
 
+	t.Run("catch undoes bindings by goal", func(t *testing.T) {
+		i := New(nil, nil)
+		assert.NoError(t, i.QuerySolution(`catch((X = a, throw(b)), b, true), var(X).`).Err())
+		assert.NoError(t, i.QuerySolution(`catch((X = a, Y = c, throw(f(X))), f(Z), true), var(X), var(Y), Z == a.`).Err())
+	})
+
+	t.Run("assoc", func(t *testing.T) {
+		i := New(nil, nil)
+		assert.NoError(t, i.QuerySolution(`list_to_assoc([b-2, a-1], A), put_assoc(c, A, 3, A2), get_assoc(c, A2, 3), \+get_assoc(c, A, _), assertz(saved(A2)).`).Err())
+		assert.NoError(t, i.QuerySolution(`saved(A), del_assoc(a, A, 1, A2), assoc_to_keys(A2, [b, c]), assoc_to_values(A2, [2, 3]).`).Err())
+	})
+
 	t.Run("cut", func(t *testing.T) {
 		// https://www.cs.uleth.ca/~gaur/post/prolog-cut-negation/
 		t.Run("p", func(t *testing.T) {
@@ -1061,6 +1373,24 @@ studies(alex, physics).
 				assert.False(t, sols.Next())
 			})
 		})
+
+		t.Run("in a disjunction of an asserted clause", func(t *testing.T) {
+			i := New(nil, nil)
+			assert.NoError(t, i.QuerySolution(`assertz((p :- (true, ! ; throw(should_not_happen)))), assertz((p :- throw(should_not_happen))).`).Err())
+			assert.NoError(t, i.QuerySolution(`assertz((q(X) :- (X = 1, ! ; X = 2))), assertz(q(3)).`).Err())
+
+			sols, err := i.Query(`p.`)
+			assert.NoError(t, err)
+			defer func() {
+				assert.NoError(t, sols.Close())
+			}()
+			assert.True(t, sols.Next())
+			assert.False(t, sols.Next())
+			assert.NoError(t, sols.Err())
+
+			assert.NoError(t, i.QuerySolution(`findall(X, q(X), [1]).`).Err())
+			assert.NoError(t, i.QuerySolution(`findall(X, (q(X) ; X = 4), [1, 4]).`).Err())
+		})
 	})
 
 	t.Run("repeat", func(t *testing.T) {
@@ -1280,6 +1610,70 @@ func ExampleInterpreter_Exec_placeholders() {
 	// [1.0,2.0,3.0]
 }
 
+func ExampleInterpreter_Register2() {
+	p := New(nil, os.Stdout)
+
+	// double(X, Y) succeeds if Y is twice X. Either of them can be a variable.
+	p.Register2(engine.NewAtom("double"), func(vm *engine.VM, x, y engine.Term, k engine.Cont, env *engine.Env) *engine.Promise {
+		switch x := env.Resolve(x).(type) {
+		case engine.Integer:
+			return engine.Unify(vm, y, 2*x, k, env)
+		case engine.Variable:
+			switch y := env.Resolve(y).(type) {
+			case engine.Integer:
+				if y%2 != 0 {
+					return engine.Bool(false)
+				}
+				return engine.Unify(vm, x, y/2, k, env)
+			case engine.Variable:
+				return engine.Error(engine.InstantiationError(env))
+			default:
+				return engine.Error(engine.TypeError(engine.NewAtom("integer"), y, env))
+			}
+		default:
+			return engine.Error(engine.TypeError(engine.NewAtom("integer"), x, env))
+		}
+	})
+
+	// either(X, A, B) succeeds twice, first with X = A and then with X = B.
+	p.Register3(engine.NewAtom("either"), func(vm *engine.VM, x, a, b engine.Term, k engine.Cont, env *engine.Env) *engine.Promise {
+		return engine.Delay(func(context.Context) *engine.Promise {
+			return engine.Unify(vm, x, a, k, env)
+		}, func(context.Context) *engine.Promise {
+			return engine.Unify(vm, x, b, k, env)
+		})
+	})
+
+	// first(Closure, X) commits to the first solution of call(Closure, X). The continuation cuts the choices made since
+	// the promise of the goal so that backtracking doesn't reach the other solutions.
+	p.Register2(engine.NewAtom("first"), func(vm *engine.VM, closure, x engine.Term, k engine.Cont, env *engine.Env) *engine.Promise {
+		var p *engine.Promise
+		p = engine.Call1(vm, closure, x, func(env *engine.Env) *engine.Promise {
+			return engine.Cut(p, func(context.Context) *engine.Promise {
+				return k(env)
+			})
+		}, env)
+		return p
+	})
+
+	_ = p.QuerySolution(`double(3, X), write(X), nl.`).Err()
+	_ = p.QuerySolution(`double(X, 8), write(X), nl.`).Err()
+	_ = p.QuerySolution(`(double(_, 7) -> true; write(no)), nl.`).Err()
+	_ = p.QuerySolution(`catch(double(foo, _), error(E, _), true), write(E), nl.`).Err()
+	_ = p.QuerySolution(`findall(X, (either(Y, 1, 2), double(Y, X)), Xs), write(Xs), nl.`).Err()
+	_ = p.QuerySolution(`findall(X, first(between(1, 3), X), Xs), write(Xs), nl.`).Err()
+	_ = p.QuerySolution(`findall(X, (first(either(X, a), b) ; X = c), Xs), write(Xs), nl.`).Err()
+
+	// Output:
+	// 6
+	// 4
+	// no
+	// type_error(integer,foo)
+	// [2,4]
+	// [1]
+	// [a,c]
+}
+
 func ExampleInterpreter_Query_placeholders() {
 	p := New(nil, os.Stdout)
 	sols, _ := p.Query(`A = ?, maplist(atom, A), write(A), nl.`, "foo")